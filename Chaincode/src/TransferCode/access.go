@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Read access - a contract and what belongs to it, its documents, checkpoints, breaches, escrow and history, is
+//				   read by its seller, transporter and buyer, and by an arbiter once the contract was disputed. An
+//				   asset is read by its owner, by inspectors and by whoever reads the contract it is committed to,
+//				   an account by its owner and the treasury, the recalls outstanding on the assets of an owner by
+//				   the owner and inspectors. The part catalog and the lifecycle status of an asset are read by any
+//				   caller with a certificate. An auditor reads every record, so does an admin, who alone lists the
+//				   raw keys of the ledger. The caller is known from the attributes of its certificate, listings
+//				   leave out what the caller may not read.
+//==============================================================================================================================
+const AUDITOR = "auditor"
+const ADMIN = "admin"
+
+// reader struct - the caller of a query, empty when its certificate carries no attributes
+type reader struct {
+	username string
+	role     string
+}
+
+// get_reader - Reads the enrollment ID and role of the caller of a query from its certificate
+func (t *SimpleChaincode) get_reader(stub shim.ChaincodeStubInterface) reader {
+
+	username, role, err := t.get_caller_data(stub)
+	if err != nil {
+		logFor(stub, "").debug("caller without attributes", "reason", err)
+		return reader{}
+	}
+	return reader{username, role}
+}
+
+// privileged reports whether the reader reads every record
+func (r reader) privileged() bool {
+	return r.role == AUDITOR || r.role == ADMIN
+}
+
+// canReadContract reports whether the reader may read a contract
+func (r reader) canReadContract(sc SalesContractObject) bool {
+
+	if r.username == "" {
+		return false
+	}
+	switch r.role {
+	case AUDITOR, ADMIN:
+		return true
+	case SELLER:
+		return sc.Seller == r.username
+	case TRANSPORTER:
+		return sc.Transporter == r.username
+	case BUYER:
+		return sc.Buyer == r.username
+	case ARBITER:
+		_, disputed := sc.StageTimes[STATE_DISPUTED]
+		return disputed
+	}
+	return false
+}
+
+// canReadAsset reports whether the reader may read an asset
+func (r reader) canReadAsset(stub shim.ChaincodeStubInterface, ast AssetObject) bool {
+
+	if r.username == "" {
+		return false
+	}
+	if r.privileged() || r.role == INSPECTOR || ast.Owner == r.username {
+		return true
+	}
+	if ast.Contractid == "" {
+		return false
+	}
+	sc, err := getContractObject(stub, ast.Contractid)
+	return err == nil && r.canReadContract(sc)
+}
+
+// canReadAccount reports whether the reader may read the accounts of a party
+func (r reader) canReadAccount(owner string) bool {
+	return r.username != "" && (r.privileged() || r.role == TREASURY || r.username == owner)
+}
+
+// canReadRecalls reports whether the reader may list the recalls outstanding on the assets of an owner
+func (r reader) canReadRecalls(owner string) bool {
+	return r.username != "" && (r.privileged() || r.role == INSPECTOR || r.username == owner)
+}
+
+// check_contract_reader - Verifies that the caller may read a contract and returns the contract
+func (t *SimpleChaincode) check_contract_reader(stub shim.ChaincodeStubInterface, function string, contractID string) (SalesContractObject, error) {
+
+	sc, err := getContractObject(stub, contractID)
+	if err != nil {
+		return sc, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if !t.get_reader(stub).canReadContract(sc) {
+		logFor(stub, contractID).debug("caller may not read the contract")
+		return sc, permissionDenied(function)
+	}
+	return sc, nil
+}
+
+// check_history_reader - Verifies that the caller may read the asset or contract a history is kept for
+func (t *SimpleChaincode) check_history_reader(stub shim.ChaincodeStubInterface, objectType string, objectID string) error {
+
+	r := t.get_reader(stub)
+	if r.privileged() {
+		return nil
+	}
+	var ok bool
+	if objectType == HISTORY_ASSET {
+		ast, err := getAssetObject(stub, objectID)
+		ok = err == nil && r.canReadAsset(stub, ast)
+	} else {
+		sc, err := getContractObject(stub, objectID)
+		ok = err == nil && r.canReadContract(sc)
+	}
+	if !ok {
+		logFor(stub, objectID).debug("caller may not read the history", "objectType", objectType)
+		return permissionDenied("getHistory")
+	}
+	return nil
+}
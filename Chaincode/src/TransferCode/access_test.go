@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestContractReaders(t *testing.T) {
+
+	stub := newStub(t)
+	readers := []struct {
+		caller  caller
+		allowed bool
+	}{
+		{bosch, true},
+		{dhl, true},
+		{lht, true},
+		{auditor, true},
+		{admin, true},
+		{continental, false},
+		{caller{"lht", SELLER}, false}, // the buyer of the contract in another role
+		{arbiter, false},
+		{anonymous, false},
+	}
+	for _, reader := range readers {
+		for _, function := range []string{"readContract", "getTrackingTimeline", "allowedActions"} {
+			_, err := query(stub, reader.caller, function, "C1")
+			if reader.allowed && err != nil || !reader.allowed && (err == nil || asChaincodeError(err).Message != "Permission Denied. "+function) {
+				t.Fatalf("%s as %+v: expected allowed %v, got %v", function, reader.caller, reader.allowed, err)
+			}
+		}
+	}
+
+	// the arbiter reads a contract once it is disputed
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C1")
+	mustInvoke(t, stub, lht, "raiseDispute", "C1", "late", "E1")
+	if _, err := query(stub, arbiter, "readContract", "C1"); err != nil {
+		t.Fatalf("expected the arbiter to read a disputed contract, got %s", err)
+	}
+	if _, err := query(stub, arbiter, "readState", "1001"); err != nil {
+		t.Fatalf("expected the arbiter to read the assets of a disputed contract, got %s", err)
+	}
+}
+
+func TestListingsOfReader(t *testing.T) {
+
+	stub := newListingStub(t)
+	ups := caller{"ups", BUYER}
+
+	tests := []struct {
+		caller   caller
+		function string
+		args     []string
+		want     []string
+	}{
+		{lht, "listContractsBySeller", []string{"bosch"}, []string{"C1", "C2", "C3"}},
+		{ups, "listContractsBySeller", []string{"bosch"}, []string{"C4", "C5", "C6"}},
+		{continental, "listContractsBySeller", []string{"bosch"}, []string{}},
+		{dhl, "listContractsByStage", []string{"1"}, []string{"C2", "C4", "C6"}},
+		{ups, "listContractsByTime", []string{"created", ""}, []string{"C4", "C5", "C6"}},
+		{lht, "listAssetsByPartno", []string{"LHTMO"}, []string{"1001", "1003", "1004"}},
+		{lht, "listAssetsByOwner", []string{"lht"}, []string{"1009"}},
+		{anonymous, "listAssetsByOwner", []string{"bosch"}, []string{}},
+		{auditor, "listAssetsByOwner", []string{"bosch"}, []string{"1001", "1002", "1003", "1004", "1005", "1006", "1007", "1008"}},
+	}
+	for _, test := range tests {
+		got, err := query(stub, test.caller, test.function, test.args...)
+		if err != nil {
+			t.Fatalf("%s as %s failed: %s", test.function, test.caller.username, err)
+		}
+		var objects []struct {
+			Contractid string
+			Serialno   string
+		}
+		json.Unmarshal(got, &objects)
+		ids := []string{}
+		for _, object := range objects {
+			if object.Serialno != "" {
+				ids = append(ids, object.Serialno)
+			} else {
+				ids = append(ids, object.Contractid)
+			}
+		}
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Fatalf("%s(%v) as %s: expected %v, got %v", test.function, test.args, test.caller.username, test.want, ids)
+		}
+	}
+
+	// pages hold the contracts of the caller only
+	got, _ := query(stub, ups, "listContracts", `{"Seller":"bosch"}`, "2")
+	var page ContractPage
+	json.Unmarshal(got, &page)
+	if len(page.Contracts) != 2 || page.Contracts[0].Contractid != "C4" || page.Bookmark == "" {
+		t.Fatalf("unexpected page %s", got)
+	}
+	got, _ = query(stub, ups, "listContracts", `{"Seller":"bosch"}`, "2", page.Bookmark)
+	json.Unmarshal(got, &page)
+	if len(page.Contracts) != 1 || page.Contracts[0].Contractid != "C6" || page.Bookmark != "" {
+		t.Fatalf("unexpected page %s", got)
+	}
+}
+
+func TestAccountReaders(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	readers := []struct {
+		caller   caller
+		function string
+		args     []string
+		wantErr  string
+	}{
+		{lht, "getAccount", []string{"lht"}, ""},
+		{bank, "getAccount", []string{"lht"}, ""},
+		{auditor, "getAccount", []string{"lht"}, ""},
+		{bosch, "getAccount", []string{"lht"}, "Permission Denied"},
+		{anonymous, "getAccount", []string{""}, "Owner is required"},
+		{dhl, "getEscrow", []string{"C2"}, ""},
+		{continental, "getEscrow", []string{"C2"}, "Permission Denied"},
+		{bank, "getEscrow", []string{"C2"}, "Permission Denied"},
+		{lht, "listBreaches", nil, ""},
+		{bosch, "getHistory", []string{"contract", "C2"}, ""},
+		{dhl, "getHistory", []string{"asset", "1002"}, "Permission Denied"},
+	}
+	for _, reader := range readers {
+		_, err := query(stub, reader.caller, reader.function, reader.args...)
+		checkError(t, err, reader.wantErr)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"strconv"
 	//"strconv"
 	"encoding/json"
 	"time"
@@ -15,68 +14,112 @@ import (
 type SimpleChaincode struct {
 }
 
-var assestIndexstr = "_assestindex"
-
 // AssetObject struct
 type AssetObject struct {
-	Serialno string
-	Partno   string
-	Owner    string
+	Serialno      string
+	Partno        string
+	Owner         string
+	Contractid    string // the open sales contract the asset is committed to, ownership can't be updated while set
+	Status        string // lifecycle status, see lifecycle.go
+	RecallID      string // the recall campaign that flagged the asset, set while it is recalled, see recall.go
+	SchemaVersion int    // see schema.go
 }
 
 //==============================================================================================================================
 //	 Status types - contract lifecycle is broken down into 5 statuses, this is part of the business logic to determine what can
-//					be done to the vehicle at points in it's lifecycle
+//					be done to the vehicle at points in it's lifecycle. A shipment can leave the forward chain by being
+//					cancelled, rejected on arrival or disputed, it then ends delivered or returned to the seller.
 //==============================================================================================================================
 const STATE_OPEN = 0
 const STATE_READYFORSHIPMENT = 1
 const STATE_INTRANSIT = 2
 const STATE_SHIPMENT_REACHED = 3
 const STATE_SHIPMENT_DELIVERED = 4
+const STATE_CANCELLED = 5
+const STATE_REJECTED = 6
+const STATE_DISPUTED = 7
+const STATE_RESOLVED = 8
+const STATE_RETURNED_TO_SELLER = 9
 
 const SELLER = "seller"
 const TRANSPORTER = "transporter"
 const BUYER = "lease_company"
+const ARBITER = "arbiter"
+
+// Outcomes of a resolved dispute
+const RESOLUTION_DELIVER = "deliver"
+const RESOLUTION_RETURN = "return"
+
+//==============================================================================================================================
+//	 Certificate attributes - the enrollment ID and role of the caller are read from the transaction certificate,
+//							  see aca.attributes in membersrvc.yaml
+//==============================================================================================================================
+const ATTR_USERNAME = "username"
+const ATTR_ROLE = "role"
+
+// TIME_FORMAT is the layout of every time written to the ledger
+const TIME_FORMAT = time.RFC3339
 
 // SalesContractObject struct
 type SalesContractObject struct {
-	Contractid  string
-	Stage       int
-	Buyer       string
-	Transporter string
-	Seller      string
-	AssetID     string
-	DocumentID  string
-	TimeStamp   string // This is the time stamp
+	Contractid     string
+	Stage          int
+	Buyer          string
+	Transporter    string
+	Seller         string
+	DocumentID     string
+	Currency       string // ISO 4217 code of the prices of the line items
+	Incoterms      string
+	LineItems      []LineItem
+	Total          int64          // sum of the amounts of the line items
+	TransporterFee int64          // paid to the transporter out of the escrow on delivery, see escrow.go
+	FundedAt       string         // transaction time the buyer paid the price and fee into the escrow, see escrow.go
+	Deadlines      Deadlines      // see sla.go
+	Breaches       []Breach       // deadlines missed, oldest first
+	CreatedAt      string         // transaction time of initContract
+	UpdatedAt      string         // transaction time of the last change
+	StageTimes     map[int]string // transaction time each stage was entered, by stage
+	Reason         string         // why the contract was cancelled, rejected, disputed or resolved
+	EvidenceID     string         // DocumentID of the evidence supporting Reason
+	Resolution     string         // outcome of a resolved dispute, RESOLUTION_DELIVER or RESOLUTION_RETURN
+	Sealed         *SealedFields  // set on a confidential contract, see confidential.go
+	SchemaVersion  int            // see schema.go
 }
 
 func main() {
+	init_logging()
 	err := shim.Start(new(SimpleChaincode))
 	if err != nil {
-		fmt.Printf("Error starting Simple chaincode: %s", err)
+		logger.Criticalf("Error starting Simple chaincode: %s", err)
 	}
 }
 
-// Init initializes the chain
+// Init initializes the chain and records the names of the peer chaincodes, see crosschain.go. The log level
+// is set on the peer running it, see logging.go.
+// args: [assetRegistry=<name>] [settlement=<name>] [logLevel=<level>]
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 
-	var err error
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty)
-	err = stub.PutState(assestIndexstr, jsonAsBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	return nil, nil
+	payload, err := t.configure(stub, args)
+	return respond(stub, payload, err)
 }
 
-// Invoke is our entry point to invoke a chaincode function
+// Invoke is our entry point to invoke a chaincode function, it returns errors as a ChaincodeError, see errors.go
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("invoke is running " + function)
+
+	payload, err := t.run_invoke(stub, function, args)
+	return respond(stub, payload, err)
+}
+
+// run_invoke - Runs the invoke function
+func (t *SimpleChaincode) run_invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	logFor(stub, "").debug("invoke is running")
 
 	// Handle different functions
-	if function == "init" {
+	if function == "init" { // sets the peer chaincodes and the log level, see configure
+		if _, err := t.check_role(stub, ADMIN); err != nil {
+			logFor(stub, "").debug("caller may not configure the chaincode", "reason", err)
+			return nil, permissionDenied("init")
+		}
 		return t.Init(stub, "init", args)
 	} else if function == "initAssset" {
 		return t.initAssset(stub, args)
@@ -86,23 +129,56 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
 		return t.initContract(stub, args)
 	} else if function == "contractUpdation" {
 		return t.updateContract(stub, args)
-	} else if function == "readyForShipment" {
-		return t.toReadyForShipment(stub, args)
-	} else if function == "inTransit" {
-		return t.toInTransit(stub, args)
-	} else if function == "shipmentReached" {
-		return t.toShipmentReached(stub, args)
-	} else if function == "shipmentDelivered" {
-		return t.toShipmentDelivered(stub, args)
+	} else if function == "initAssetsBatch" {
+		return t.initAssetsBatch(stub, args)
+	} else if function == "transferAssetsBatch" {
+		return t.transferAssetsBatch(stub, args)
+	} else if function == "migrateRecords" {
+		return t.migrateRecords(stub, args)
+	} else if function == "attachDocument" {
+		return t.attachDocument(stub, args)
+	} else if function == "recordCheckpoint" {
+		return t.recordCheckpoint(stub, args)
+	} else if function == "depositFunds" {
+		return t.depositFunds(stub, args)
+	} else if function == "fundEscrow" {
+		return t.fundEscrow(stub, args)
+	} else if function == "lockAsset" || function == "releaseAsset" || function == "lockEscrow" || function == "settleEscrow" {
+		return t.run_peer_invoke(stub, function, args) // called by the contract chaincode, see crosschain.go
+	} else if function == "recordMaintenance" {
+		return t.recordMaintenance(stub, args)
+	} else if function == "recordInspection" {
+		return t.recordInspection(stub, args)
+	} else if function == "setAssetStatus" {
+		return t.setAssetStatus(stub, args)
+	} else if function == "registerPart" {
+		return t.registerPart(stub, args)
+	} else if function == "supersedePart" {
+		return t.supersedePart(stub, args)
+	} else if function == "launchRecall" {
+		return t.launchRecall(stub, args)
+	} else if function == "deliverLineItems" {
+		return t.deliverLineItems(stub, args)
+	} else if function == "handOverAssets" {
+		return t.handOverAssets(stub, args)
+	} else if function == "transition" {
+		return t.transition(stub, args)
+	} else if tr, ok := getTransition(function); ok { // readyForShipment, inTransit, ... see transitions.go
+		return t.positional_transition(stub, tr, args)
 	}
-	fmt.Println("invoke did not find func: " + function) //error
-
-	return nil, errors.New("Received unknown function invocation: " + function)
+	return nil, unknownFunction("Received unknown function invocation: " + function)
 }
 
-// Query queries the hyperledger
+// Query queries the hyperledger, it returns errors as a ChaincodeError, see errors.go
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-	fmt.Println("query is running " + function)
+
+	payload, err := t.run_query(stub, function, args)
+	return respond(stub, payload, err)
+}
+
+// run_query - Runs the query function
+func (t *SimpleChaincode) run_query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	logFor(stub, "").debug("query is running")
 
 	// Handle different functions
 	if function == "readState" { //read a variable
@@ -114,216 +190,436 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
 	if function == "readContract" { //read a contract
 		return t.readContract(stub, args)
 	}
-	fmt.Println("query did not find func: " + function) //error
+	if function == "getWrappedKey" { //read the key of a confidential contract wrapped for the caller
+		return t.getWrappedKey(stub, args)
+	}
+	if function == "allowedActions" { //list the transitions the caller may perform on a contract
+		return t.allowedActions(stub, args)
+	}
+	if function == "getHistory" { //read the change history of an asset or a contract
+		return t.getHistory(stub, args)
+	}
+	if function == "listAssetsByOwner" {
+		return t.listAssetsByIndex(stub, INDEX_ASSET_OWNER, args)
+	}
+	if function == "listAssetsByPartno" {
+		return t.listAssetsByIndex(stub, INDEX_ASSET_PARTNO, args)
+	}
+	if function == "listContractsByBuyer" {
+		return t.listContractsByIndex(stub, INDEX_CONTRACT_BUYER, args)
+	}
+	if function == "listContractsBySeller" {
+		return t.listContractsByIndex(stub, INDEX_CONTRACT_SELLER, args)
+	}
+	if function == "listContractsByTransporter" {
+		return t.listContractsByIndex(stub, INDEX_CONTRACT_TRANSPORTER, args)
+	}
+	if function == "listContractsByStage" {
+		return t.listContractsByIndex(stub, INDEX_CONTRACT_STAGE, args)
+	}
+	if function == "listContractsByAsset" {
+		return t.listContractsByIndex(stub, INDEX_CONTRACT_ASSET, args)
+	}
+	if function == "listContracts" { //list a page of the contracts matching a filter
+		return t.listContracts(stub, args)
+	}
+	if function == "listAssets" { //list a page of the assets matching a filter
+		return t.listAssets(stub, args)
+	}
+	if function == "listContractsByTime" { //list the contracts created, updated or entering a stage in a time window
+		return t.listContractsByTime(stub, args)
+	}
+	if function == "listDocuments" { //list the documents attached to a contract
+		return t.listDocuments(stub, args)
+	}
+	if function == "verifyDocument" { //check the hash of a copy of a document against the ledger
+		return t.verifyDocument(stub, args)
+	}
+	if function == "getTrackingTimeline" { //list the checkpoints of a shipment and check their chain
+		return t.getTrackingTimeline(stub, args)
+	}
+	if function == "listBreaches" { //list the deadlines missed by contracts
+		return t.listBreaches(stub, args)
+	}
+	if function == "getAssetRecords" { //read the status of an asset and its maintenance and inspection records
+		return t.getAssetRecords(stub, args)
+	}
+	if function == "readPart" { //read a part of the catalog and the parts superseding it
+		return t.readPart(stub, args)
+	}
+	if function == "getAssetStatus" { //read the lifecycle status of an asset, see recall.go
+		return t.getAssetStatus(stub, args)
+	}
+	if function == "readRecall" { //read a recall campaign and the assets it flagged
+		return t.readRecall(stub, args)
+	}
+	if function == "listRecallsByOwner" { //list the recalls outstanding on the assets of an owner
+		return t.listRecallsByOwner(stub, args)
+	}
+	if function == "getAccount" { //read the balances and postings of a party
+		return t.getAccount(stub, args)
+	}
+	if function == "getEscrow" { //read the escrow of a contract and its postings
+		return t.getEscrow(stub, args)
+	}
+	return nil, unknownFunction("Received unknown function query " + function)
+}
 
-	return nil, errors.New("Received unknown function query " + function)
+// AssetRequest struct - the arguments of initAssset, also an item of initAssetsBatch
+type AssetRequest struct {
+	Serialno string `validate:"required,pattern=integer"`
+	Partno   string `validate:"required"`
+	Owner    string `validate:"required"`
+
+	violations []Violation // the rules an item of initAssetsBatch broke while decoded, see UnmarshalJSON
+}
+
+// ContractRequest struct - the positional arguments of initContract for a contract of one asset, kept for older
+// clients. The trailing TimeStamp may be left out and must otherwise be empty: the transaction time is used instead.
+type ContractRequest struct {
+	Contractid  string `validate:"required"`
+	Stage       int    `validate:"required,min=0,max=0"` // a contract is created open
+	Buyer       string `validate:"required"`
+	Transporter string `validate:"required"`
+	Seller      string `validate:"required"`
+	AssetID     string `validate:"required"`
+	DocumentID  string
+	TimeStamp   string
+}
+
+// ContractIDRequest struct - the arguments of the functions that read a contract
+type ContractIDRequest struct {
+	Contractid string `validate:"required"`
+}
+
+// SerialnoRequest struct - the arguments of readState
+type SerialnoRequest struct {
+	Serialno string `validate:"required"`
 }
 
+// UpdateContractRequest struct - the arguments of contractUpdation
+type UpdateContractRequest struct {
+	Contractid string `validate:"required"`
+	DocumentID string
+	Stage      int `validate:"required,min=0,max=9"`
+}
+
+// KeysRequest struct - the arguments of keys, the range of ledger keys to list
+type KeysRequest struct {
+	StartKey string
+	EndKey   string `validate:"required"`
+}
+
+// initAssset registers an asset. An owner registers its own assets, an admin registers them for any owner.
+// args: Serialno, Partno, Owner or {"Serialno":"1001","Partno":"LHTMO","Owner":"bosch"}
 func (t *SimpleChaincode) initAssset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 
 	//convert the arguments into an asset Object
-	AssetObject, err := CreateAssetObject(args[0:])
-	if err != nil {
-		fmt.Println("initAsset(): Cannot create asset object ")
-		return nil, errors.New("initAsset(): Cannot create asset object")
+	var req AssetRequest
+	if err = decodeRequest("initAssset", args, &req); err != nil {
+		return nil, err
+	}
+	AssetObject := CreateAssetObject(req)
+	if err = t.check_owner(stub, AssetObject.Owner); err != nil {
+		logFor(stub, AssetObject.Serialno).debug("caller may not register the asset", "reason", err)
+		return nil, permissionDenied("initAssset")
+	}
+	if err = check_part_listed(stub, AssetObject.Partno); err != nil {
+		return nil, wrapError(ERR_INVALID_ARGUMENT, "initAssset()", err)
 	}
 
 	// check if the asset already exists
-	assestAsBytes, err := stub.GetState(AssetObject.Serialno)
+	assetKey, err := getAssetKey(AssetObject.Serialno)
+	if err != nil {
+		return nil, err
+	}
+	assestAsBytes, err := stub.GetState(assetKey)
 	if err != nil {
-		fmt.Println("initAssset() : failed to get asset")
 		return nil, errors.New("Failed to get asset")
 	}
 	if assestAsBytes != nil {
-		fmt.Println("initAssset() : Asset already exists ", AssetObject.Serialno)
-		jsonResp := "{\"Error\":\"Failed - Asset already exists " + AssetObject.Serialno + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, alreadyExists(AssetObject.Serialno, "Asset already exists "+AssetObject.Serialno)
 	}
 
-	buff, err := ARtoJSON(AssetObject)
+	_, err = t.save_asset(stub, AssetObject)
 	if err != nil {
-		errorStr := "initAssset() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(errorStr)
-		return nil, errors.New(errorStr)
-	} else {
-		err = stub.PutState(args[0], buff)
-		if err != nil {
-			fmt.Println("initAssset() : write error while inserting record\n")
-			return nil, errors.New("initAssset() : write error while inserting record : " + err.Error())
-		}
+		return nil, wrapError(ERR_INTERNAL, "initAssset() : write error while inserting record", err)
+	}
+	err = emitAssetEvent(stub, EVENT_ASSET_CREATED, "", AssetObject)
+	if err != nil {
+		return nil, err
 	}
+	logFor(stub, AssetObject.Serialno).info("asset created", "partno", AssetObject.Partno, "owner", AssetObject.Owner)
 	return nil, nil
 }
 
+// initContract creates an open contract and locks its assets. Only the seller creates a contract, the buyer then
+// pays into its escrow with fundEscrow.
+// args: the ContractTerms as JSON, see lineitems.go, or Contractid, Stage, Buyer, Transporter, Seller, AssetID,
+// DocumentID[, TimeStamp] for a contract of one asset
 func (t *SimpleChaincode) initContract(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
 
-	//convert the arguments into an asset Object
-	contractObject, err := CreateContractObject(args[0:])
+	//convert the arguments into a contract Object, either the terms as JSON or the positional form for one asset
+	var contractObject SalesContractObject
+	if len(args) == 1 {
+		var terms ContractTerms
+		if err = decodeRequest("initContract", args, &terms); err != nil {
+			return nil, err
+		}
+		contractObject, err = CreateContractFromTerms(terms)
+	} else {
+		var req ContractRequest
+		if err = decodeRequest("initContract", args, &req); err != nil {
+			return nil, err
+		}
+		if req.TimeStamp != "" {
+			return nil, requestError("initContract", []Violation{{"TimeStamp", "empty", "TimeStamp should be left empty, the contract takes the transaction time : " + req.TimeStamp}})
+		}
+		contractObject = CreateContractObject(req)
+	}
 	if err != nil {
-		fmt.Println("initContract(): Cannot create contract object ")
-		return nil, errors.New("initAsset(): Cannot create contract object")
+		return nil, wrapError(ERR_INVALID_ARGUMENT, "initContract() : Cannot create contract object", err)
+	}
+	if err = t.check_caller(stub, contractObject.Seller, SELLER); err != nil {
+		logFor(stub, contractObject.Contractid).debug("caller may not create the contract", "reason", err)
+		return nil, permissionDenied("initContract")
 	}
 
 	// check if the contract already exists
-	contractAsBytes, err := stub.GetState(contractObject.Contractid)
+	contractKey, err := getContractKey(contractObject.Contractid)
+	if err != nil {
+		return nil, err
+	}
+	contractAsBytes, err := stub.GetState(contractKey)
 	if err != nil {
-		fmt.Println("initContract() : failed to get contract")
 		return nil, errors.New("Failed to get contract")
 	}
 	if contractAsBytes != nil {
-		fmt.Println("initContract() : contract already exists for ", contractObject.Contractid)
-		jsonResp := "{\"Error\":\"Failed - contract already exists " + contractObject.Contractid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, alreadyExists(contractObject.Contractid, "contract already exists "+contractObject.Contractid)
 	}
 
-	buff, err := CTRCTtoJSON(contractObject)
+	// a key for the contract in the metadata makes it confidential
+	contractObject.Sealed, err = new_sealed_fields(stub, contractObject)
 	if err != nil {
-		errorStr := "initContract() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(errorStr)
-		return nil, errors.New(errorStr)
+		return nil, wrapError(ERR_INVALID_ARGUMENT, "initContract()", err)
 	}
-	err = stub.PutState(args[0], buff)
+
+	// every asset must exist, belong to the seller and not be sold under another contract
+	var assets []AssetObject
+	locked := make(map[string]bool)
+	for i := range contractObject.LineItems {
+		line := &contractObject.LineItems[i]
+		for _, assetID := range line.AssetIDs {
+			asset, err := get_registry_asset(stub, assetID)
+			if err != nil {
+				return nil, err
+			}
+			if locked[asset.Serialno] {
+				return nil, &ChaincodeError{ERR_INVALID_ARGUMENT, "asset " + asset.Serialno + " is listed more than once", "LineItems", asset.Serialno, nil}
+			}
+			if err = check_lockable(asset, contractObject.Seller); err != nil {
+				return nil, err
+			}
+			if line.Partno == "" {
+				line.Partno = asset.Partno
+			}
+			if asset.Partno != line.Partno {
+				return nil, &ChaincodeError{ERR_INVALID_ARGUMENT, "asset " + asset.Serialno + " is not a " + line.Partno, "Partno", asset.Serialno, nil}
+			}
+			locked[asset.Serialno] = true
+			assets = append(assets, asset)
+		}
+	}
+
+	_, err = t.save_changes(stub, &contractObject)
 	if err != nil {
-		fmt.Println("initContract() : write error while inserting record\n")
-		return nil, errors.New("initContract() : write error while inserting record : " + err.Error())
+		return nil, wrapError(ERR_INTERNAL, "initContract() : write error while inserting record", err)
 	}
+	for _, asset := range assets {
+		err = t.lock_registry_asset(stub, asset, contractObject.Contractid, contractObject.Seller, contractObject.Buyer)
+		if err != nil {
+			return nil, wrapError(ERR_INTERNAL, "initContract() : write error while locking asset", err)
+		}
+	}
+	err = emitContractEvent(stub, EVENT_CONTRACT_CREATED, nil, contractObject)
+	if err != nil {
+		return nil, err
+	}
+	logFor(stub, contractObject.Contractid).info("contract created", "lineItems", len(contractObject.LineItems), "assets", len(assets))
 	return nil, nil
 }
 
 // read function return value
+// args: Serialno or {"Serialno":"1001"}
 func (t *SimpleChaincode) readState(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var name, jsonResp string
+	var req SerialnoRequest
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	if err = decodeRequest("readState", args, &req); err != nil {
+		return nil, err
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetState(name)
+	name := req.Serialno
+	assetKey, err := getAssetKey(name)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	valAsbytes, err := stub.GetState(assetKey)
+	if err != nil {
+		return nil, errors.New("Failed to get state for " + name)
+	}
+	if valAsbytes == nil {
+		return nil, nil
 	}
 
-	return valAsbytes, nil
+	// return the asset in the current schema whatever version it was stored with
+	ast, err := decodeAsset(valAsbytes)
+	if err != nil {
+		return nil, err
+	}
+	if !t.get_reader(stub).canReadAsset(stub, ast) {
+		logFor(stub, name).debug("caller may not read the asset")
+		return nil, permissionDenied("readState")
+	}
+	return ARtoJSON(ast)
 }
 
 // read function return value
+// args: Contractid or {"Contractid":"C1"}
 func (t *SimpleChaincode) readContract(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var name, jsonResp string
+	var req ContractIDRequest
 	var err error
 
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting name of the var to query")
+	if err = decodeRequest("readContract", args, &req); err != nil {
+		return nil, err
 	}
 
-	name = args[0]
-	valAsbytes, err := stub.GetState(name)
+	name := req.Contractid
+	contractKey, err := getContractKey(name)
+	if err != nil {
+		return nil, err
+	}
+	valAsbytes, err := stub.GetState(contractKey)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + name + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, errors.New("Failed to get state for " + name)
+	}
+	if valAsbytes == nil {
+		return nil, nil
 	}
 
-	return valAsbytes, nil
+	// return the contract in the current schema whatever version it was stored with
+	sc, err := decodeContract(valAsbytes)
+	if err != nil {
+		return nil, err
+	}
+	if sc, err = open_contract(stub, sc); err != nil {
+		return nil, err
+	}
+	if !t.get_reader(stub).canReadContract(sc) {
+		logFor(stub, name).debug("caller may not read the contract")
+		return nil, permissionDenied("readContract")
+	}
+	return CTRCTtoJSON(sc)
 }
 
-// read function return value
+// updateOwner hands an asset over to a new owner. The owner hands over its own assets, an admin those of any owner.
+// args: Serialno, NewOwner or {"Serialno":"1001","NewOwner":"lht"}
 func (t *SimpleChaincode) updateOwner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp string
+	var req AssetTransfer
 	var err error
 
-	if len(args) != 2 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 2 args")
+	if err = decodeRequest("ownerUpdation", args, &req); err != nil {
+		return nil, err
 	}
 
-	serialNo := args[0]
-	newOwner := args[1]
-	valAsbytes, err := stub.GetState(serialNo)
+	serialNo := req.Serialno
+	newOwner := req.NewOwner
+	myAsset, err := getAssetObject(stub, serialNo)
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + serialNo + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
 	}
-	dat, err := JSONtoArgs(valAsbytes)
-	if err != nil {
-		return nil, errors.New("unable to convert jsonToArgs for" + serialNo)
+	if err = t.check_owner(stub, myAsset.Owner); err != nil {
+		logFor(stub, serialNo).debug("caller may not hand the asset over", "reason", err)
+		return nil, permissionDenied("ownerUpdation")
 	}
-	fmt.Println(dat)
 
-	serialFromLedger := dat["Serialno"].(string)
-	fmt.Println(serialFromLedger)
-	partFromLeger := dat["Partno"].(string)
-	fmt.Println(partFromLeger)
-
-	myAsset := AssetObject{serialFromLedger, partFromLeger, newOwner}
+	// an asset committed to an open contract only changes hands on delivery
+	if myAsset.Contractid != "" {
+		return nil, conflict(serialNo, "asset "+serialNo+" is locked by contract "+myAsset.Contractid)
+	}
+	oldOwner := myAsset.Owner
+	myAsset.Owner = newOwner
 
-	buff, err := ARtoJSON(myAsset)
+	_, err = t.save_asset(stub, myAsset)
 	if err != nil {
-		errorStr := "initAssset() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(errorStr)
-		return nil, errors.New(errorStr)
+		return nil, wrapError(ERR_INTERNAL, "updateOwner() : write error while inserting record", err)
 	}
-	err = stub.PutState(serialFromLedger, buff)
+	err = emitAssetEvent(stub, EVENT_ASSET_OWNER_CHANGED, oldOwner, myAsset)
 	if err != nil {
-		fmt.Println("initAssset() : write error while inserting record\n")
-		return nil, errors.New("initAssset() : write error while inserting record : " + err.Error())
+		return nil, err
 	}
+	logFor(stub, serialNo).info("asset owner changed", "from", oldOwner, "to", newOwner)
 	return nil, nil
 }
 
-// read function return value
+// updateContract replaces the document of a contract, for a party to it. The stage is only changed by the
+// transitions, see transitions.go, older clients still pass the current stage.
+// args: Contractid, DocumentID, Stage or {"Contractid":"C1","DocumentID":"D2","Stage":0}
 func (t *SimpleChaincode) updateContract(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp string
+	var req UpdateContractRequest
 	var err error
 
-	if len(args) != 3 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 3 args")
+	if err = decodeRequest("contractUpdation", args, &req); err != nil {
+		return nil, err
 	}
 
-	Contractid := args[0]
-	NewDocumentID := args[1]
-	Newstage, err := strconv.Atoi(args[2])
+	Contractid := req.Contractid
+	NewDocumentID := req.DocumentID
+	updatedContract, err := getContractObject(stub, Contractid)
 	if err != nil {
-		fmt.Println("updateContract(): Stage should be an integer create failed! ")
-		return nil, errors.New("updateContract(): Stage should be an integer create failed. ")
+		return nil, wrapError(ERR_INTERNAL, "Failed to get state for "+Contractid, err)
 	}
-	contractAsbytes, err := stub.GetState(Contractid)
-	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + Contractid + "\"}"
-		return nil, errors.New(jsonResp)
+	if err = check_open(updatedContract); err != nil {
+		return nil, err
 	}
-	dat, err := JSONtoArgs(contractAsbytes)
-	if err != nil {
-		return nil, errors.New("unable to convert jsonToArgs for" + Contractid)
+	if _, err = t.check_party(stub, updatedContract); err != nil {
+		logFor(stub, Contractid).debug("caller may not update the contract", "reason", err)
+		return nil, permissionDenied("contractUpdation")
 	}
-	fmt.Println(dat)
-
-	updatedContract := SalesContractObject{dat["Contractid"].(string), Newstage, dat["Buyer"].(string), dat["Transporter"].(string), dat["Seller"].(string), dat["AssetID"].(string), NewDocumentID, time.Now().Format("20060102150405")}
+	if req.Stage != updatedContract.Stage {
+		return nil, invalidArgument("Stage", fmt.Sprintf("contractUpdation() : Stage should be the current stage %d, stages change through the transitions, e.g. readyForShipment", updatedContract.Stage))
+	}
+	oldContract := updatedContract
+	updatedContract.DocumentID = NewDocumentID
 
-	buff, err := CTRCTtoJSON(updatedContract)
+	_, err = t.save_changes(stub, &updatedContract)
 	if err != nil {
-		errorStr := "updateContract() : Failed Cannot create object buffer for write : " + args[0]
-		fmt.Println(errorStr)
-		return nil, errors.New(errorStr)
+		return nil, wrapError(ERR_INTERNAL, "updateContract() : write error while inserting record", err)
 	}
-	err = stub.PutState(dat["Contractid"].(string), buff)
+	err = emitContractEvent(stub, EVENT_CONTRACT_UPDATED, &oldContract, updatedContract)
 	if err != nil {
-		fmt.Println("initAssset() : write error while inserting record\n")
-		return nil, errors.New("initAssset() : write error while inserting record : " + err.Error())
+		return nil, err
 	}
+	logFor(stub, Contractid).info("contract updated", "documentID", NewDocumentID)
 	return nil, nil
 }
 
+// getAllKeys lists the ledger keys in a range, for admins
+// args: StartKey, EndKey or {"StartKey":"...","EndKey":"..."}
 func (t *SimpleChaincode) getAllKeys(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
-	if len(args) < 2 {
-		return nil, errors.New("put operation must include two arguments, a key and value")
+	var req KeysRequest
+	if err := decodeRequest("keys", args, &req); err != nil {
+		return nil, err
+	}
+	if t.get_reader(stub).role != ADMIN {
+		return nil, permissionDenied("keys")
 	}
 
-	startKey := args[0]
-	endKey := args[1]
+	startKey := req.StartKey
+	endKey := req.EndKey
 
 	keysIter, err := stub.RangeQueryState(startKey, endKey)
 
@@ -340,9 +636,7 @@ func (t *SimpleChaincode) getAllKeys(stub shim.ChaincodeStubInterface, args []st
 		keys = append(keys, response)
 	}
 
-	for key, value := range keys {
-		fmt.Printf("key %d contains %s\n", key, value)
-	}
+	logFor(stub, "").debug("keys listed", "startKey", startKey, "endKey", endKey, "count", len(keys))
 
 	jsonKeys, err := json.Marshal(keys)
 	if err != nil {
@@ -352,250 +646,376 @@ func (t *SimpleChaincode) getAllKeys(stub shim.ChaincodeStubInterface, args []st
 	return jsonKeys, nil
 }
 
-// CreateAssetObject creates an asset
-func CreateAssetObject(args []string) (AssetObject, error) {
-	// S001 LHTMO bosch
-	var err error
-	var myAsset AssetObject
+// CreateAssetObject creates an asset from a validated request
+func CreateAssetObject(req AssetRequest) AssetObject {
+	return AssetObject{Serialno: req.Serialno, Partno: req.Partno, Owner: req.Owner, Status: ASSET_ACTIVE, SchemaVersion: ASSET_SCHEMA_VERSION}
+}
 
-	// Check there are 3 Arguments provided as per the the struct
-	if len(args) != 3 {
-		fmt.Println("CreateAssetObject(): Incorrect number of arguments. Expecting 3 ")
-		return myAsset, errors.New("CreateAssetObject(): Incorrect number of arguments. Expecting 3 ")
+// CreateContractObject creates an open contract of one asset from a validated request
+func CreateContractObject(req ContractRequest) SalesContractObject {
+	return SalesContractObject{
+		Contractid:    req.Contractid,
+		Stage:         STATE_OPEN,
+		Buyer:         req.Buyer,
+		Transporter:   req.Transporter,
+		Seller:        req.Seller,
+		DocumentID:    req.DocumentID,
+		LineItems:     []LineItem{{LineNo: 1, AssetIDs: []string{req.AssetID}, Quantity: 1}},
+		SchemaVersion: CONTRACT_SCHEMA_VERSION,
 	}
+}
 
-	// Validate Serialno is an integer
+// ARtoJSON Converts an Asset Object to a JSON String
+func ARtoJSON(ast AssetObject) ([]byte, error) {
 
-	_, err = strconv.Atoi(args[0])
+	ajson, err := json.Marshal(ast)
 	if err != nil {
-		fmt.Println("CreateAssetObject(): SerialNo should be an integer create failed! ")
-		return myAsset, errors.New("CreateAssetbject(): SerialNo should be an integer create failed. ")
+		return nil, err
 	}
+	return ajson, nil
+}
 
-	myAsset = AssetObject{args[0], args[1], args[2]}
+// CTRCTtoJSON Converts an contract Object to a JSON String
+func CTRCTtoJSON(c SalesContractObject) ([]byte, error) {
 
-	fmt.Println("CreateAssetObject(): Asset Object created: ", myAsset.Serialno, myAsset.Partno, myAsset.Owner)
-	return myAsset, nil
+	cjson, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return cjson, nil
 }
 
-// CreateContractObject creates an contract
-func CreateContractObject(args []string) (SalesContractObject, error) {
-	// S001 LHTMO bosch
-	var err error
-	var myContract SalesContractObject
+// getTxTime returns the timestamp of the current transaction. Unlike time.Now() it is the same on
+// every validating peer, so it is safe to write to the ledger.
+func getTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
 
-	// Check there are 3 Arguments provided as per the the struct
-	if len(args) != 8 {
-		fmt.Println("CreateContractObject(): Incorrect number of arguments. Expecting 8 ")
-		return myContract, errors.New("CreateContractObject(): Incorrect number of arguments. Expecting 8 ")
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if ts == nil {
+		return time.Time{}, errors.New("transaction timestamp is not available")
 	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
 
-	// Validate Serialno is an integer
+// parseTime validates a time passed in RFC 3339 and returns it in the UTC form the ledger holds, in which
+// times sort in time order. An empty string is returned as is.
+func parseTime(value string) (string, error) {
 
-	stage, err := strconv.Atoi(args[1])
+	if value == "" {
+		return "", nil
+	}
+	parsed, err := time.Parse(TIME_FORMAT, value)
 	if err != nil {
-		fmt.Println("CreateAssetObject(): Stage should be an integer create failed! ")
-		return myContract, errors.New("CreateAssetbject(): Stage should be an integer create failed. ")
+		return "", invalidArgument("", "time should be in RFC 3339, e.g. 2016-11-01T10:30:00Z : "+value)
 	}
-	if stage != 0 {
-		fmt.Println("CreateAssetObject(): Stage should be set as open ")
-		return myContract, errors.New("CreateAssetbject(): Stage should be set as open")
+	return parsed.UTC().Format(TIME_FORMAT), nil
+}
+
+// getFunction returns the name of the chaincode function the transaction invoked
+func getFunction(stub shim.ChaincodeStubInterface) string {
+
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return ""
 	}
+	return args[0]
+}
+
+//	 Caller identity
 
-	myContract = SalesContractObject{args[0], STATE_OPEN, args[2], args[3], args[4], args[5], args[6], time.Now().Format("20060102150405")}
+// get_caller_data - Reads the enrollment ID and role of the caller from the attributes of the
+// transaction certificate. The attributes are provisioned by the membersrvc ACA.
+func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (string, string, error) {
 
-	fmt.Println("CreateContractObject(): Contract Object created: ", myContract.Contractid, myContract.Stage, myContract.Buyer, myContract.Transporter, myContract.Seller, myContract.AssetID, myContract.DocumentID, time.Now().Format("20060102150405"))
-	return myContract, nil
+	username, err := stub.ReadCertAttribute(ATTR_USERNAME)
+	if err != nil {
+		return "", "", errors.New("Couldn't get attribute '" + ATTR_USERNAME + "'. Error: " + err.Error())
+	}
+	role, err := stub.ReadCertAttribute(ATTR_ROLE)
+	if err != nil {
+		return "", "", errors.New("Couldn't get attribute '" + ATTR_ROLE + "'. Error: " + err.Error())
+	}
+	if len(username) == 0 || len(role) == 0 {
+		return "", "", errors.New("Caller certificate is missing the '" + ATTR_USERNAME + "' or '" + ATTR_ROLE + "' attribute")
+	}
+	return string(username), string(role), nil
 }
 
-// ARtoJSON Converts an Asset Object to a JSON String
-func ARtoJSON(ast AssetObject) ([]byte, error) {
+// getActor returns the enrollment ID of the caller for the records kept of a change. The caller attributes
+// are not mandatory for every function, an empty string is returned when they can't be read.
+func getActor(stub shim.ChaincodeStubInterface) string {
 
-	ajson, err := json.Marshal(ast)
+	username, err := stub.ReadCertAttribute(ATTR_USERNAME)
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		return ""
 	}
-	return ajson, nil
+	return string(username)
 }
 
-// CTRCTtoJSON Converts an contract Object to a JSON String
-func CTRCTtoJSON(c SalesContractObject) ([]byte, error) {
+// check_caller - Verifies that the caller is the given party of the contract and that the
+// caller's certificate carries the expected role attribute.
+func (t *SimpleChaincode) check_caller(stub shim.ChaincodeStubInterface, party string, role string) error {
 
-	cjson, err := json.Marshal(c)
+	caller, err := t.check_role(stub, role)
 	if err != nil {
-		fmt.Println(err)
-		return nil, err
+		return err
 	}
-	return cjson, nil
+	if caller != party {
+		return errors.New("caller " + caller + " is not the " + role + " of the contract")
+	}
+	return nil
 }
 
-// JSON To args[] - return a map of the JSON string
-func JSONtoArgs(Avalbytes []byte) (map[string]interface{}, error) {
+// check_owner - Verifies that the caller is the owner of an asset, or an admin, who acts for any owner.
+func (t *SimpleChaincode) check_owner(stub shim.ChaincodeStubInterface, owner string) error {
 
-	var data map[string]interface{}
+	if _, err := t.check_role(stub, ADMIN); err == nil {
+		return nil
+	}
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil {
+		return err
+	}
+	if caller != owner {
+		return errors.New("caller " + caller + " is not the owner " + owner)
+	}
+	return nil
+}
 
-	if err := json.Unmarshal(Avalbytes, &data); err != nil {
-		return nil, err
+// check_role - Verifies that the caller's certificate carries the given role attribute and returns the
+// enrollment ID of the caller.
+func (t *SimpleChaincode) check_role(stub shim.ChaincodeStubInterface, role string) (string, error) {
+
+	caller, _, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", err
 	}
+	ok, err := stub.VerifyAttribute(ATTR_ROLE, []byte(role))
+	if err != nil {
+		return "", errors.New("Couldn't verify attribute '" + ATTR_ROLE + "'. Error: " + err.Error())
+	}
+	if !ok {
+		return "", errors.New("caller " + caller + " does not hold the " + role + " role")
+	}
+	return caller, nil
+}
 
-	return data, nil
+// check_party - Verifies that the caller is the seller, transporter or buyer of the contract and returns
+// the role the caller holds in it.
+func (t *SimpleChaincode) check_party(stub shim.ChaincodeStubInterface, sc SalesContractObject) (string, error) {
+
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return "", err
+	}
+	parties := map[string]string{SELLER: sc.Seller, TRANSPORTER: sc.Transporter, BUYER: sc.Buyer}
+	party, ok := parties[role]
+	if !ok {
+		return "", errors.New("role " + role + " is not a party to a contract")
+	}
+	if err = t.check_caller(stub, party, role); err != nil {
+		return "", err
+	}
+	return role, nil
 }
 
-//	 Transfer Functions
-//	 seller to transporter
+// save_changes - Writes to the ledger the Contract struct passed in a JSON format. Uses the shim file's
+//				  method 'PutState'. The contract is stamped with the transaction time and checked against its
+//				  deadlines, sc is updated with what was written. Its indexes and history are updated along with it.
+func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, sc *SalesContractObject) (bool, error) {
 
-func (t *SimpleChaincode) toReadyForShipment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	txTime, err := getTxTime(stub)
 
-	contractid := args[0]
-	caller := args[1]
-	callerAffiliation := args[2]
-	newDocumentID := args[3]
-	// check if the contract exists
-	sc, err := getContractObject(stub, contractid)
 	if err != nil {
-		fmt.Println("sellerToTransporter() : failed to get contract object")
-		return nil, errors.New("Failed to get contract object")
+		logFor(stub, sc.Contractid).error("error reading transaction time", "reason", err)
+		return false, errors.New("Error reading transaction time")
 	}
 
-	if sc.Stage == STATE_OPEN &&
-		sc.Seller == caller &&
-		callerAffiliation == SELLER {
-		sc.Stage = STATE_READYFORSHIPMENT // and mark it in the state of ready for shipment
-		sc.DocumentID = newDocumentID     //attach the new document
-	} else { // Otherwise if there is an error
-		fmt.Printf("sellerToTransporter: Permission Denied")
-		return nil, errors.New(fmt.Sprintf("Permission Denied. sellerToTransporter"))
+	contractKey, err := getContractKey(sc.Contractid)
 
+	if err != nil {
+		return false, err
 	}
 
-	status, err := t.save_changes(stub, sc) // Write new state
+	before, err := stub.GetState(contractKey)
+
 	if err != nil {
-		fmt.Printf("sellerToTransporter: Error saving changes: %s", err)
-		return nil, errors.New("Error saving changes")
+		logFor(stub, sc.Contractid).error("error reading contract", "reason", err)
+		return false, errors.New("Error reading contract")
 	}
-	fmt.Println("sellerToTransporter: Transfer complete : %s", status)
-	return nil, nil // We are Done
 
-}
-
-//	 transporter
+	// the index entries are those of the record as stored, its times come from the upgraded record
+	var oldEntries []indexEntry
+	var old *SalesContractObject
+	if before != nil {
+		var stored SalesContractObject
+		if err = json.Unmarshal(before, &stored); err != nil {
+			logFor(stub, sc.Contractid).error("error converting stored contract", "reason", err)
+			return false, errors.New("Error converting stored contract")
+		}
+		oldEntries = contractIndexEntries(stored)
+		upgraded, err := decodeContract(before)
+		if err != nil {
+			logFor(stub, sc.Contractid).error("error converting stored contract", "reason", err)
+			return false, errors.New("Error converting stored contract")
+		}
+		old = &upgraded
+	}
+	sc.SchemaVersion = CONTRACT_SCHEMA_VERSION
+	sc.stamp(old, txTime.Format(TIME_FORMAT))
+	sc.check_deadlines(old, txTime, stub.GetTxID())
 
-func (t *SimpleChaincode) toInTransit(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	// a confidential contract is stored with its confidential fields encrypted
+	stored, err := seal_contract(stub, *sc)
 
-	contractid := args[0]
-	caller := args[1]
-	callerAffiliation := args[2]
-	// check if the contract exists
-	sc, err := getContractObject(stub, contractid)
 	if err != nil {
-		fmt.Println("toInTransit() : failed to get contract object")
-		return nil, errors.New("Failed to get contract object")
+		logFor(stub, sc.Contractid).error("error sealing contract", "reason", err)
+		return false, wrapError(ERR_INTERNAL, "Error sealing contract", err)
 	}
 
-	if sc.Stage == STATE_READYFORSHIPMENT &&
-		sc.Transporter == caller &&
-		callerAffiliation == TRANSPORTER {
-		sc.Stage = STATE_INTRANSIT // and mark it in the state of ready for shipment
-	} else { // Otherwise if there is an error
-		fmt.Printf("toInTransit: Permission Denied")
-		return nil, errors.New(fmt.Sprintf("Permission Denied. toInTransit"))
+	bytes, err := json.Marshal(stored)
 
+	if err != nil {
+		logFor(stub, sc.Contractid).error("error converting contract", "reason", err)
+		return false, errors.New("Error converting contract ")
 	}
 
-	status, err := t.save_changes(stub, sc) // Write new state
+	err = stub.PutState(contractKey, bytes)
+
 	if err != nil {
-		fmt.Printf("toInTransit: Error saving changes: %s", err)
-		return nil, errors.New("Error saving changes")
+		logFor(stub, sc.Contractid).error("error storing contract", "reason", err)
+		return false, errors.New("Error storing contract")
 	}
-	fmt.Println("toInTransit: Transfer complete : %s", status)
-	return nil, nil // We are Done
 
-}
+	err = updateIndexes(stub, sc.Contractid, oldEntries, contractIndexEntries(stored))
 
-//	 shipment reached
+	if err != nil {
+		logFor(stub, sc.Contractid).error("error storing contract indexes", "reason", err)
+		return false, errors.New("Error storing contract indexes")
+	}
 
-func (t *SimpleChaincode) toShipmentReached(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	// the history of a confidential contract doesn't tell which party changed it, see confidential.go
+	actor := getActor(stub)
+	if stored.Sealed != nil {
+		actor = ""
+	}
+	err = writeHistory(stub, HISTORY_CONTRACT, sc.Contractid, actor, before, bytes)
 
-	contractid := args[0]
-	caller := args[1]
-	callerAffiliation := args[2]
-	// check if the contract exists
-	sc, err := getContractObject(stub, contractid)
 	if err != nil {
-		fmt.Println("toShipmentReached() : failed to get contract object")
-		return nil, errors.New("Failed to get contract object")
+		logFor(stub, sc.Contractid).error("error storing contract history", "reason", err)
+		return false, errors.New("Error storing contract history")
 	}
+	return true, nil
+}
 
-	if sc.Stage == STATE_INTRANSIT &&
-		sc.Transporter == caller &&
-		callerAffiliation == TRANSPORTER {
-		sc.Stage = STATE_SHIPMENT_REACHED // and mark it in the state of ready for shipment
-	} else { // Otherwise if there is an error
-		fmt.Printf("toShipmentReached() : Permission Denied")
-		return nil, errors.New(fmt.Sprintf("Permission Denied. toInTransit"))
+// stamp sets the creation, update and stage times of a contract about to be saved over old, which is nil
+// for a new contract. The stage time is only set when the contract enters a new stage.
+func (sc *SalesContractObject) stamp(old *SalesContractObject, txTime string) {
 
+	stageTimes := make(map[int]string)
+	if old == nil {
+		sc.CreatedAt = txTime
+	} else {
+		sc.CreatedAt = old.CreatedAt
+		for stage, stageTime := range old.StageTimes {
+			stageTimes[stage] = stageTime
+		}
+	}
+	if old == nil || old.Stage != sc.Stage {
+		stageTimes[sc.Stage] = txTime
 	}
+	sc.StageTimes = stageTimes
+	sc.UpdatedAt = txTime
+}
+
+// transfer_assets - Hands the assets of the line items of a delivered contract not received yet over to the
+//					 buyer, marks the lines delivered and releases the lock the contract held on the assets.
+func (t *SimpleChaincode) transfer_assets(stub shim.ChaincodeStubInterface, sc *SalesContractObject) (bool, error) {
+	return t.settle_lines(stub, sc, nil, true)
+}
+
+// release_assets - Releases the lock a cancelled or returned contract held on the assets of the line items not
+//					delivered, which stay with the seller.
+func (t *SimpleChaincode) release_assets(stub shim.ChaincodeStubInterface, sc *SalesContractObject) (bool, error) {
+	return t.settle_lines(stub, sc, nil, false)
+}
+
+// unlock_asset - Releases the lock of a contract on an asset and hands the asset to owner
+func (t *SimpleChaincode) unlock_asset(stub shim.ChaincodeStubInterface, contractID string, assetID string, owner string) (bool, error) {
+
+	asset, err := getAssetObject(stub, assetID)
 
-	status, err := t.save_changes(stub, sc) // Write new state
 	if err != nil {
-		fmt.Printf("toShipmentReached() : Error saving changes: %s", err)
-		return nil, errors.New("Error saving changes")
+		logFor(stub, assetID).error("error reading asset", "reason", err)
+		return false, errors.New("Error reading asset " + assetID)
 	}
-	fmt.Println("toShipmentReached() : Transfer complete : %s", status)
-	return nil, nil // We are Done
 
+	if asset.Contractid != contractID {
+		return false, conflict(asset.Serialno, "Asset "+asset.Serialno+" is not locked by contract "+contractID)
+	}
+
+	asset.Owner = owner
+	asset.Contractid = ""
+
+	return t.save_asset(stub, asset)
 }
 
-//	 shipment reached
+// save_asset - Writes to the ledger the Asset struct passed in a JSON format, together with its indexes
+//				and history.
+func (t *SimpleChaincode) save_asset(stub shim.ChaincodeStubInterface, ast AssetObject) (bool, error) {
 
-func (t *SimpleChaincode) toShipmentDelivered(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	ast.SchemaVersion = ASSET_SCHEMA_VERSION
+	bytes, err := ARtoJSON(ast)
 
-	contractid := args[0]
-	caller := args[1]
-	callerAffiliation := args[2]
-	// check if the contract exists
-	sc, err := getContractObject(stub, contractid)
 	if err != nil {
-		fmt.Println("toShipmentDelivered() : failed to get contract object")
-		return nil, errors.New("Failed to get contract object")
+		logFor(stub, ast.Serialno).error("error converting asset", "reason", err)
+		return false, errors.New("Error converting asset")
 	}
 
-	if sc.Stage == STATE_SHIPMENT_REACHED &&
-		sc.Buyer == caller &&
-		callerAffiliation == BUYER {
-		sc.Stage = STATE_SHIPMENT_DELIVERED // and mark it in the state of ready for shipment
-	} else { // Otherwise if there is an error
-		fmt.Printf("toShipmentDelivered() : Permission Denied")
-		return nil, errors.New(fmt.Sprintf("Permission Denied. toInTransit"))
+	assetKey, err := getAssetKey(ast.Serialno)
 
+	if err != nil {
+		return false, err
 	}
 
-	status, err := t.save_changes(stub, sc) // Write new state
+	before, err := stub.GetState(assetKey)
+
 	if err != nil {
-		fmt.Printf("toShipmentDelivered() : Error saving changes: %s", err)
-		return nil, errors.New("Error saving changes")
+		logFor(stub, ast.Serialno).error("error reading asset", "reason", err)
+		return false, errors.New("Error reading asset")
 	}
-	fmt.Println("toShipmentDelivered() : Transfer complete : %s", status)
-	return nil, nil // We are Done
-}
 
-// save_changes - Writes to the ledger the Contract struct passed in a JSON format. Uses the shim file's
-//				  method 'PutState'.
-func (t *SimpleChaincode) save_changes(stub shim.ChaincodeStubInterface, sc SalesContractObject) (bool, error) {
+	var oldEntries []indexEntry
+	if before != nil {
+		var old AssetObject
+		if err = json.Unmarshal(before, &old); err != nil {
+			logFor(stub, ast.Serialno).error("error converting stored asset", "reason", err)
+			return false, errors.New("Error converting stored asset")
+		}
+		oldEntries = assetIndexEntries(old)
+	}
 
-	bytes, err := json.Marshal(sc)
+	err = stub.PutState(assetKey, bytes)
 
 	if err != nil {
-		fmt.Printf("SAVE_CHANGES: Error converting contract : %s", err)
-		return false, errors.New("Error converting contract ")
+		logFor(stub, ast.Serialno).error("error storing asset", "reason", err)
+		return false, errors.New("Error storing asset")
 	}
 
-	err = stub.PutState(sc.Contractid, bytes)
+	err = updateIndexes(stub, ast.Serialno, oldEntries, assetIndexEntries(ast))
 
 	if err != nil {
-		fmt.Printf("SAVE_CHANGES: Error storing contract : %s", err)
-		return false, errors.New("Error storing contract")
+		logFor(stub, ast.Serialno).error("error storing asset indexes", "reason", err)
+		return false, errors.New("Error storing asset indexes")
+	}
+
+	err = writeHistory(stub, HISTORY_ASSET, ast.Serialno, getActor(stub), before, bytes)
+
+	if err != nil {
+		logFor(stub, ast.Serialno).error("error storing asset history", "reason", err)
+		return false, errors.New("Error storing asset history")
 	}
 	return true, nil
 }
@@ -604,22 +1024,43 @@ func getContractObject(stub shim.ChaincodeStubInterface, contractID string) (Sal
 
 	// check that the contract already exists
 	var sco SalesContractObject
-	contractAsBytes, err := stub.GetState(contractID)
+	contractKey, err := getContractKey(contractID)
+	if err != nil {
+		return sco, err
+	}
+	contractAsBytes, err := stub.GetState(contractKey)
 	if err != nil {
-		fmt.Println("getcontractObject() : failed to get contract")
 		return sco, errors.New("Failed to get contract")
 	}
 	if contractAsBytes == nil {
-		fmt.Println("getcontractObject() : erreneous contact object for", contractID)
-		jsonResp := "{\"Error\":\"Failed - erreneous contact object for" + contractID + "\"}"
-		return sco, errors.New(jsonResp)
+		return sco, notFound(contractID, "no contract for "+contractID)
+	}
+	if sco, err = decodeContract(contractAsBytes); err != nil {
+		return sco, wrapError(ERR_INTERNAL, "Failed to convert to object", err)
+	}
+	if sco, err = open_contract(stub, sco); err != nil {
+		return sco, wrapError(ERR_INTERNAL, "Failed to open confidential contract", err)
 	}
-	dat, err := JSONtoArgs(contractAsBytes)
+	return sco, nil
+}
+
+func getAssetObject(stub shim.ChaincodeStubInterface, serialNo string) (AssetObject, error) {
+
+	// check that the asset already exists
+	var ast AssetObject
+	assetKey, err := getAssetKey(serialNo)
 	if err != nil {
-		fmt.Println("getcontractObject() : failed to convert to object")
-		return sco, errors.New("Failed to convert to object")
+		return ast, err
+	}
+	assetAsBytes, err := stub.GetState(assetKey)
+	if err != nil {
+		return ast, errors.New("Failed to get asset")
+	}
+	if assetAsBytes == nil {
+		return ast, notFound(serialNo, "no asset for "+serialNo)
+	}
+	if ast, err = decodeAsset(assetAsBytes); err != nil {
+		return ast, wrapError(ERR_INTERNAL, "Failed to convert to object", err)
 	}
-	stage := dat["Stage"].(float64)
-	salesContract := SalesContractObject{dat["Contractid"].(string), int(stage), dat["Buyer"].(string), dat["Transporter"].(string), dat["Seller"].(string), dat["AssetID"].(string), dat["DocumentID"].(string), dat["TimeStamp"].(string)}
-	return salesContract, nil
+	return ast, nil
 }
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// caller is the enrollment ID and role carried by the certificate of a test transaction
+type caller struct {
+	username string
+	role     string
+}
+
+var (
+	bosch       = caller{"bosch", SELLER}
+	dhl         = caller{"dhl", TRANSPORTER}
+	lht         = caller{"lht", BUYER}
+	arbiter     = caller{"arbiter", ARBITER}
+	continental = caller{"continental", SELLER} // a seller that is not a party to the contracts
+	bank        = caller{"bank", TREASURY}      // credits the money paid in off the ledger
+	auditor     = caller{"kpmg", AUDITOR}       // reads every record
+	admin       = caller{"root", ADMIN}         // reads every record and the raw keys
+	inspector   = caller{"tuev", INSPECTOR}     // certified to service and inspect parts
+	anonymous   = caller{}                      // a certificate without attributes
+)
+
+var testTime = time.Date(2016, 11, 1, 10, 30, 0, 0, time.UTC)
+
+var txCount int
+
+// newCatalogStub returns a stub of a chaincode named name with parts LHTMO of bosch and A320 of airbus in its catalog
+func newCatalogStub(t *testing.T, name string) *shim.MockStub {
+
+	stub := shim.NewMockStub(name, new(SimpleChaincode))
+	stub.MockTxTimestamp(testTime)
+	mustInvoke(t, stub, admin, "registerPart", "LHTMO", "bosch", "landing gear actuator")
+	mustInvoke(t, stub, admin, "registerPart", "A320", "airbus", "airframe")
+	return stub
+}
+
+// newStub returns a stub holding asset 1001 of bosch, committed to contract C1 with lht and dhl, and the
+// free asset 1002 of bosch
+func newStub(t *testing.T) *shim.MockStub {
+
+	stub := newCatalogStub(t, "TransferCode")
+	mustInvoke(t, stub, bosch, "initAssset", "1001", "LHTMO", "bosch")
+	mustInvoke(t, stub, bosch, "initAssset", "1002", "LHTMO", "bosch")
+	mustInvoke(t, stub, bosch, "initContract", "C1", "0", "lht", "dhl", "bosch", "1001", "D1", "")
+	return stub
+}
+
+func invoke(stub *shim.MockStub, c caller, function string, args ...string) ([]byte, error) {
+
+	txCount++
+	as(stub, c)
+	return stub.MockInvoke(fmt.Sprintf("tx%06d", txCount), function, args)
+}
+
+func mustInvoke(t *testing.T, stub *shim.MockStub, c caller, function string, args ...string) {
+
+	if _, err := invoke(stub, c, function, args...); err != nil {
+		t.Fatalf("%s(%v) as %s failed: %s", function, args, c.username, err)
+	}
+}
+
+func query(stub *shim.MockStub, c caller, function string, args ...string) ([]byte, error) {
+
+	as(stub, c)
+	return stub.MockQuery(function, args)
+}
+
+func as(stub *shim.MockStub, c caller) {
+
+	attributes := map[string]string{}
+	if c.username != "" {
+		attributes[ATTR_USERNAME] = c.username
+		attributes[ATTR_ROLE] = c.role
+	}
+	stub.MockCaller(nil, nil, attributes)
+}
+
+func getAsset(t *testing.T, stub *shim.MockStub, serialNo string) AssetObject {
+
+	ast, err := getAssetObject(stub, serialNo)
+	if err != nil {
+		t.Fatalf("asset %s not found: %s", serialNo, err)
+	}
+	return ast
+}
+
+func getContract(t *testing.T, stub *shim.MockStub, contractID string) SalesContractObject {
+
+	sc, err := getContractObject(stub, contractID)
+	if err != nil {
+		t.Fatalf("contract %s not found: %s", contractID, err)
+	}
+	return sc
+}
+
+// checkEvent verifies the name of the event set by the last transaction and decodes its payload
+func checkEvent(t *testing.T, stub *shim.MockStub, name string, payload interface{}) {
+
+	if stub.ChaincodeEvent == nil {
+		t.Fatalf("expected event %s, no event was set", name)
+	}
+	if stub.ChaincodeEvent.EventName != name {
+		t.Fatalf("expected event %s, got %s", name, stub.ChaincodeEvent.EventName)
+	}
+	if err := json.Unmarshal(stub.ChaincodeEvent.Payload, payload); err != nil {
+		t.Fatalf("event %s has an invalid payload: %s", name, err)
+	}
+}
+
+func checkError(t *testing.T, err error, wantErr string) {
+
+	if wantErr == "" && err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)) {
+		t.Fatalf("expected an error containing %q, got %v", wantErr, err)
+	}
+}
+
+func TestInvoke(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		caller   caller
+		function string
+		args     []string
+		wantErr  string
+		check    func(t *testing.T, stub *shim.MockStub)
+	}{
+		{name: "init", caller: admin, function: "init"},
+		{name: "init by a seller", caller: bosch, function: "init", args: []string{"logLevel=debug"},
+			wantErr: "Permission Denied"},
+		{name: "unknown function", caller: bosch, function: "burnAsset", args: []string{"1001"},
+			wantErr: "unknown function"},
+
+		// initAssset
+		{name: "initAssset", caller: bosch, function: "initAssset", args: []string{"1003", "LHTMO", "bosch"},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if ast := getAsset(t, stub, "1003"); ast != (AssetObject{Serialno: "1003", Partno: "LHTMO", Owner: "bosch", Status: ASSET_ACTIVE, SchemaVersion: ASSET_SCHEMA_VERSION}) {
+					t.Fatalf("unexpected asset %+v", ast)
+				}
+				var ev AssetEvent
+				checkEvent(t, stub, EVENT_ASSET_CREATED, &ev)
+				if ev.Serialno != "1003" || ev.OldOwner != "" || ev.NewOwner != "bosch" || ev.Actor != "bosch" {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+			}},
+		{name: "initAssset missing argument", caller: bosch, function: "initAssset", args: []string{"1003", "LHTMO"},
+			wantErr: "initAssset() : Owner is required"},
+		{name: "initAssset non numeric serial number", caller: bosch, function: "initAssset", args: []string{"S1003", "LHTMO", "bosch"},
+			wantErr: "Serialno should be an integer"},
+		{name: "initAssset existing asset", caller: bosch, function: "initAssset", args: []string{"1001", "LHTMO", "bosch"},
+			wantErr: "Asset already exists"},
+		{name: "initAssset for another owner", caller: bosch, function: "initAssset", args: []string{"1003", "LHTMO", "lht"},
+			wantErr: "Permission Denied"},
+		{name: "initAssset by an admin", caller: admin, function: "initAssset", args: []string{"1003", "LHTMO", "lht"}},
+
+		// ownerUpdation
+		{name: "ownerUpdation", caller: bosch, function: "ownerUpdation", args: []string{"1002", "lht"},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if owner := getAsset(t, stub, "1002").Owner; owner != "lht" {
+					t.Fatalf("expected owner lht, got %s", owner)
+				}
+				var ev AssetEvent
+				checkEvent(t, stub, EVENT_ASSET_OWNER_CHANGED, &ev)
+				if ev.OldOwner != "bosch" || ev.NewOwner != "lht" {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+			}},
+		{name: "ownerUpdation by another party", caller: lht, function: "ownerUpdation", args: []string{"1002", "lht"},
+			wantErr: "Permission Denied"},
+		{name: "ownerUpdation by an admin", caller: admin, function: "ownerUpdation", args: []string{"1002", "continental"}},
+		{name: "ownerUpdation locked asset", caller: bosch, function: "ownerUpdation", args: []string{"1001", "lht"},
+			wantErr: "locked by contract C1"},
+		{name: "ownerUpdation unknown asset", caller: bosch, function: "ownerUpdation", args: []string{"1009", "lht"},
+			wantErr: "no asset for 1009"},
+		{name: "ownerUpdation missing argument", caller: bosch, function: "ownerUpdation", args: []string{"1002"},
+			wantErr: "NewOwner is required"},
+
+		// initContract
+		{name: "initContract", caller: bosch, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "bosch", "1002", "D2", ""},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				sc := getContract(t, stub, "C2")
+				if sc.Stage != STATE_OPEN || sc.Buyer != "lht" || sc.Transporter != "dhl" || sc.Seller != "bosch" || !reflect.DeepEqual(sc.assetIDs(), []string{"1002"}) {
+					t.Fatalf("unexpected contract %+v", sc)
+				}
+				if lock := getAsset(t, stub, "1002").Contractid; lock != "C2" {
+					t.Fatalf("expected asset 1002 to be locked by C2, got %q", lock)
+				}
+				var ev ContractEvent
+				checkEvent(t, stub, EVENT_CONTRACT_CREATED, &ev)
+				if ev.Contractid != "C2" || ev.OldStage != nil || ev.NewStage != STATE_OPEN {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+			}},
+		{name: "initContract missing argument", caller: bosch, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "bosch"},
+			wantErr: "AssetID is required"},
+		{name: "initContract with a time stamp", caller: bosch, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "bosch", "1002", "D2", "20161101103000"},
+			wantErr: "TimeStamp should be left empty"},
+		{name: "initContract non numeric stage", caller: bosch, function: "initContract", args: []string{"C2", "open", "lht", "dhl", "bosch", "1002", "D2", ""},
+			wantErr: "Stage should be an integer"},
+		{name: "initContract not open", caller: bosch, function: "initContract", args: []string{"C2", "2", "lht", "dhl", "bosch", "1002", "D2", ""},
+			wantErr: "Stage should be at most 0"},
+		{name: "initContract existing contract", caller: bosch, function: "initContract", args: []string{"C1", "0", "lht", "dhl", "bosch", "1002", "D2", ""},
+			wantErr: "contract already exists C1"},
+		{name: "initContract unknown asset", caller: bosch, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "bosch", "1009", "D2", ""},
+			wantErr: "no asset for 1009"},
+		{name: "initContract asset of another owner", caller: continental, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "continental", "1002", "D2", ""},
+			wantErr: "is not owned by continental"},
+		{name: "initContract locked asset", caller: bosch, function: "initContract", args: []string{"C2", "0", "lht", "dhl", "bosch", "1001", "D2", ""},
+			wantErr: "is locked by contract C1"},
+
+		// contractUpdation
+		{name: "contractUpdation same stage", caller: bosch, function: "contractUpdation", args: []string{"C1", "D9", "0"},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if doc := getContract(t, stub, "C1").DocumentID; doc != "D9" {
+					t.Fatalf("expected document D9, got %s", doc)
+				}
+				var ev ContractEvent
+				checkEvent(t, stub, EVENT_CONTRACT_UPDATED, &ev)
+				if ev.DocumentID != "D9" {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+			}},
+		{name: "contractUpdation by the buyer", caller: lht, function: "contractUpdation", args: []string{"C1", "D9", "0"}},
+		{name: "contractUpdation delivered", caller: lht, function: "contractUpdation", args: []string{"C1", "D1", "4"},
+			wantErr: "Stage should be the current stage 0"},
+		{name: "contractUpdation cancelled", caller: bosch, function: "contractUpdation", args: []string{"C1", "D1", "5"},
+			wantErr: "stages change through the transitions"},
+		{name: "contractUpdation by another seller", caller: continental, function: "contractUpdation", args: []string{"C1", "D9", "0"},
+			wantErr: "Permission Denied"},
+		{name: "contractUpdation without attributes", caller: anonymous, function: "contractUpdation", args: []string{"C1", "D9", "0"},
+			wantErr: "Permission Denied"},
+		{name: "contractUpdation non numeric stage", caller: bosch, function: "contractUpdation", args: []string{"C1", "D1", "open"},
+			wantErr: "Stage should be an integer"},
+		{name: "contractUpdation unknown stage", caller: bosch, function: "contractUpdation", args: []string{"C1", "D1", "10"},
+			wantErr: "Stage should be at most 9"},
+		{name: "contractUpdation unknown contract", caller: bosch, function: "contractUpdation", args: []string{"C9", "D1", "1"},
+			wantErr: "Failed to get state for C9"},
+		{name: "contractUpdation missing argument", caller: bosch, function: "contractUpdation", args: []string{"C1", "D1"},
+			wantErr: "Stage is required"},
+
+		// positional transitions
+		{name: "readyForShipment", caller: bosch, function: "readyForShipment", args: []string{"C1", "D2"},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if sc := getContract(t, stub, "C1"); sc.Stage != STATE_READYFORSHIPMENT || sc.DocumentID != "D2" {
+					t.Fatalf("unexpected contract %+v", sc)
+				}
+				var ev ContractEvent
+				checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &ev)
+				if ev.Function != "readyForShipment" || ev.Actor != "bosch" || ev.NewStage != STATE_READYFORSHIPMENT {
+					t.Fatalf("unexpected event %+v", ev)
+				}
+			}},
+		{name: "readyForShipment by the transporter", caller: dhl, function: "readyForShipment", args: []string{"C1", "D2"},
+			wantErr: "Permission Denied"},
+		{name: "readyForShipment by another seller", caller: continental, function: "readyForShipment", args: []string{"C1", "D2"},
+			wantErr: "Permission Denied"},
+		{name: "readyForShipment without attributes", caller: anonymous, function: "readyForShipment", args: []string{"C1", "D2"},
+			wantErr: "Permission Denied"},
+		{name: "readyForShipment with the seller name but another role", caller: caller{"bosch", TRANSPORTER}, function: "readyForShipment", args: []string{"C1", "D2"},
+			wantErr: "Permission Denied"},
+		{name: "readyForShipment missing argument", caller: bosch, function: "readyForShipment", args: []string{"C1"},
+			wantErr: "Incorrect number of arguments"},
+		{name: "readyForShipment unknown contract", caller: bosch, function: "readyForShipment", args: []string{"C9", "D2"},
+			wantErr: "Failed to get contract object"},
+		{name: "inTransit from open", caller: dhl, function: "inTransit", args: []string{"C1"},
+			wantErr: "Permission Denied"},
+		{name: "cancelContract", caller: bosch, function: "cancelContract", args: []string{"C1", "price changed", ""},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if sc := getContract(t, stub, "C1"); sc.Stage != STATE_CANCELLED || sc.Reason != "price changed" {
+					t.Fatalf("unexpected contract %+v", sc)
+				}
+				if lock := getAsset(t, stub, "1001").Contractid; lock != "" {
+					t.Fatalf("expected asset 1001 to be released, got lock %q", lock)
+				}
+			}},
+		{name: "cancelContract without a reason", caller: bosch, function: "cancelContract", args: []string{"C1", "", ""},
+			wantErr: "Reason is required"},
+
+		// transition
+		{name: "transition", caller: bosch, function: "transition", args: []string{"C1", "cancelContract", `{"Reason":"price changed","EvidenceID":"D7"}`},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if sc := getContract(t, stub, "C1"); sc.Stage != STATE_CANCELLED || sc.EvidenceID != "D7" {
+					t.Fatalf("unexpected contract %+v", sc)
+				}
+			}},
+		{name: "transition without fields", caller: bosch, function: "transition", args: []string{"C1", "readyForShipment"},
+			check: func(t *testing.T, stub *shim.MockStub) {
+				if sc := getContract(t, stub, "C1"); sc.Stage != STATE_READYFORSHIPMENT || sc.DocumentID != "D1" {
+					t.Fatalf("unexpected contract %+v", sc)
+				}
+			}},
+		{name: "transition by the buyer", caller: lht, function: "transition", args: []string{"C1", "cancelContract", `{"Reason":"price changed"}`},
+			wantErr: "Permission Denied"},
+		{name: "transition missing required field", caller: bosch, function: "transition", args: []string{"C1", "cancelContract", `{"EvidenceID":"D7"}`},
+			wantErr: "Reason is required"},
+		{name: "transition malformed fields", caller: bosch, function: "transition", args: []string{"C1", "cancelContract", `{"Reason":`},
+			wantErr: "TransitionFields should be a JSON object"},
+		{name: "transition unknown action", caller: bosch, function: "transition", args: []string{"C1", "teleport"},
+			wantErr: "unknown action teleport"},
+		{name: "transition missing argument", caller: bosch, function: "transition", args: []string{"C1"},
+			wantErr: "Action is required"},
+		{name: "transition invalid resolution", caller: arbiter, function: "transition", args: []string{"C1", "resolveDispute", `{"Resolution":"keep","Reason":"r"}`},
+			wantErr: "Resolution should be one of deliver, return"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newStub(t)
+			_, err := invoke(stub, test.caller, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+			if err == nil && test.check != nil {
+				test.check(t, stub)
+			}
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+
+	tests := []struct {
+		name     string
+		caller   caller
+		function string
+		args     []string
+		wantErr  string
+		want     string // expected JSON response, compared after decoding when set
+	}{
+		{name: "unknown query", caller: bosch, function: "readEverything", wantErr: "unknown function"},
+
+		// readState
+		{name: "readState", caller: bosch, function: "readState", args: []string{"1002"},
+			want: `{"Serialno":"1002","Partno":"LHTMO","Owner":"bosch","Contractid":"","Status":"active","RecallID":"","SchemaVersion":3}`},
+		{name: "readState of a contract of the caller", caller: lht, function: "readState", args: []string{"1001"},
+			want: `{"Serialno":"1001","Partno":"LHTMO","Owner":"bosch","Contractid":"C1","Status":"active","RecallID":"","SchemaVersion":3}`},
+		{name: "readState as auditor", caller: auditor, function: "readState", args: []string{"1002"},
+			want: `{"Serialno":"1002","Partno":"LHTMO","Owner":"bosch","Contractid":"","Status":"active","RecallID":"","SchemaVersion":3}`},
+		{name: "readState of another party", caller: lht, function: "readState", args: []string{"1002"}, wantErr: "Permission Denied"},
+		{name: "readState without attributes", caller: anonymous, function: "readState", args: []string{"1001"}, wantErr: "Permission Denied"},
+		{name: "readState unknown asset", caller: lht, function: "readState", args: []string{"1009"}},
+		{name: "readState missing argument", caller: lht, function: "readState", wantErr: "Serialno is required"},
+
+		// readContract
+		{name: "readContract missing argument", caller: lht, function: "readContract", wantErr: "Contractid is required"},
+		{name: "readContract unknown contract", caller: lht, function: "readContract", args: []string{"C9"}},
+
+		// keys
+		{name: "keys", caller: admin, function: "keys", args: []string{ASSET_OBJECT + "\x00", ASSET_OBJECT + "\x01"},
+			want: `["Asset\u00001001\u0000","Asset\u00001002\u0000"]`},
+		{name: "keys as auditor", caller: auditor, function: "keys", args: []string{ASSET_OBJECT + "\x00", ASSET_OBJECT + "\x01"}, wantErr: "Permission Denied"},
+		{name: "keys as party", caller: bosch, function: "keys", args: []string{ASSET_OBJECT + "\x00", ASSET_OBJECT + "\x01"}, wantErr: "Permission Denied"},
+		{name: "keys missing argument", caller: lht, function: "keys", args: []string{ASSET_OBJECT}, wantErr: "EndKey is required"},
+
+		// allowedActions
+		{name: "allowedActions of the transporter", caller: dhl, function: "allowedActions", args: []string{"C1"},
+			want: `[{"Action":"inTransit","To":2,"Params":null,"Required":null}]`},
+		{name: "allowedActions of the seller", caller: bosch, function: "allowedActions", args: []string{"C1"},
+			want: `[{"Action":"cancelContract","To":5,"Params":["Reason","EvidenceID"],"Required":["Reason"]}]`},
+		{name: "allowedActions of the buyer", caller: lht, function: "allowedActions", args: []string{"C1"}, want: `[]`},
+		{name: "allowedActions of the auditor", caller: auditor, function: "allowedActions", args: []string{"C1"}, want: `[]`},
+		{name: "allowedActions without attributes", caller: anonymous, function: "allowedActions", args: []string{"C1"}, wantErr: "Permission Denied"},
+		{name: "allowedActions of another seller", caller: continental, function: "allowedActions", args: []string{"C1"}, wantErr: "Permission Denied"},
+		{name: "allowedActions unknown contract", caller: dhl, function: "allowedActions", args: []string{"C9"}, wantErr: "Failed to get contract object"},
+		{name: "allowedActions missing argument", caller: dhl, function: "allowedActions", wantErr: "Contractid is required"},
+
+		// getHistory
+		{name: "getHistory bad object type", caller: lht, function: "getHistory", args: []string{"vehicle", "1001"}, wantErr: "ObjectType should be one of asset, contract"},
+		{name: "getHistory bad page size", caller: lht, function: "getHistory", args: []string{"asset", "1001", "0"}, wantErr: "PageSize should be at least 1"},
+		{name: "getHistory bad bookmark", caller: lht, function: "getHistory", args: []string{"asset", "1001", "", "not a bookmark"}, wantErr: "invalid bookmark"},
+		{name: "getHistory missing argument", caller: lht, function: "getHistory", args: []string{"asset"}, wantErr: "ObjectID is required"},
+		{name: "getHistory unknown object", caller: auditor, function: "getHistory", args: []string{"asset", "1009"}, want: `{"Records":[],"Bookmark":""}`},
+		{name: "getHistory unknown object of a party", caller: lht, function: "getHistory", args: []string{"asset", "1009"}, wantErr: "Permission Denied"},
+		{name: "getHistory of another party", caller: continental, function: "getHistory", args: []string{"contract", "C1"}, wantErr: "Permission Denied"},
+
+		// indexes
+		{name: "listAssetsByOwner", caller: bosch, function: "listAssetsByOwner", args: []string{"bosch"},
+			want: `[{"Serialno":"1001","Partno":"LHTMO","Owner":"bosch","Contractid":"C1","Status":"active","RecallID":"","SchemaVersion":3},{"Serialno":"1002","Partno":"LHTMO","Owner":"bosch","Contractid":"","Status":"active","RecallID":"","SchemaVersion":3}]`},
+		{name: "listAssetsByOwner of another party", caller: lht, function: "listAssetsByOwner", args: []string{"bosch"},
+			want: `[{"Serialno":"1001","Partno":"LHTMO","Owner":"bosch","Contractid":"C1","Status":"active","RecallID":"","SchemaVersion":3}]`},
+		{name: "listAssetsByOwner no match", caller: lht, function: "listAssetsByOwner", args: []string{"lht"}, want: `[]`},
+		{name: "listAssetsByPartno missing argument", caller: lht, function: "listAssetsByPartno", wantErr: "Value is required"},
+		{name: "listContractsByStage no match", caller: lht, function: "listContractsByStage", args: []string{"0"}, want: `[]`},
+		{name: "listContractsByAsset missing argument", caller: lht, function: "listContractsByAsset", wantErr: "Value is required"},
+		{name: "listContractsByTime bad filter", caller: lht, function: "listContractsByTime", args: []string{"delivered", ""}, wantErr: "should filter on"},
+		{name: "listContractsByTime unknown stage", caller: lht, function: "listContractsByTime", args: []string{"10", ""}, wantErr: "should filter on"},
+		{name: "listContractsByTime bad time", caller: lht, function: "listContractsByTime", args: []string{"created", "01/11/2016"}, wantErr: "RFC 3339"},
+		{name: "listContractsByTime missing argument", caller: lht, function: "listContractsByTime", wantErr: "Filter is required"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := query(stub, test.caller, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			if test.want == "" {
+				if got != nil {
+					t.Fatalf("expected no response, got %s", got)
+				}
+				return
+			}
+			var gotValue, wantValue interface{}
+			if err = json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("invalid response %s", got)
+			}
+			json.Unmarshal([]byte(test.want), &wantValue)
+			if fmt.Sprint(gotValue) != fmt.Sprint(wantValue) {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestListContracts(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D2", "")
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D3")
+
+	for _, test := range []struct {
+		function string
+		value    string
+		want     []string
+	}{
+		{"listContractsByBuyer", "lht", []string{"C1", "C2"}},
+		{"listContractsBySeller", "bosch", []string{"C1", "C2"}},
+		{"listContractsByTransporter", "dhl", []string{"C1", "C2"}},
+		{"listContractsByTransporter", "bosch", []string{}},
+		{"listContractsByStage", "0", []string{"C1"}},
+		{"listContractsByStage", "1", []string{"C2"}},
+		{"listContractsByAsset", "1002", []string{"C2"}},
+	} {
+		got, err := query(stub, lht, test.function, test.value)
+		if err != nil {
+			t.Fatalf("%s(%s) failed: %s", test.function, test.value, err)
+		}
+		var contracts []SalesContractObject
+		json.Unmarshal(got, &contracts)
+		ids := []string{}
+		for _, sc := range contracts {
+			ids = append(ids, sc.Contractid)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.want) {
+			t.Errorf("%s(%s): expected %v, got %v", test.function, test.value, test.want, ids)
+		}
+	}
+}
+
+func TestReadContract(t *testing.T) {
+
+	stub := newStub(t)
+	got, err := query(stub, lht, "readContract", "C1")
+	if err != nil {
+		t.Fatalf("readContract failed: %s", err)
+	}
+	var sc SalesContractObject
+	if err = json.Unmarshal(got, &sc); err != nil {
+		t.Fatalf("readContract returned %s: %s", got, err)
+	}
+	if sc.Contractid != "C1" || sc.Stage != STATE_OPEN || !reflect.DeepEqual(sc.assetIDs(), []string{"1001"}) || sc.DocumentID != "D1" {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "ownerUpdation", "1002", "lht")
+	stub.MockTxTimestamp(testTime.Add(time.Hour))
+	mustInvoke(t, stub, lht, "ownerUpdation", "1002", "continental")
+
+	got, err := query(stub, continental, "getHistory", "asset", "1002", "2")
+	if err != nil {
+		t.Fatalf("getHistory failed: %s", err)
+	}
+	var page HistoryPage
+	json.Unmarshal(got, &page)
+	if len(page.Records) != 2 || page.Bookmark == "" {
+		t.Fatalf("expected a first page of 2 records with a bookmark, got %s", got)
+	}
+	if string(page.Records[0].Before) != "null" || page.Records[1].Function != "ownerUpdation" || page.Records[1].Actor != "bosch" {
+		t.Fatalf("unexpected records %s", got)
+	}
+
+	got, err = query(stub, continental, "getHistory", "asset", "1002", "2", page.Bookmark)
+	if err != nil {
+		t.Fatalf("getHistory failed: %s", err)
+	}
+	page = HistoryPage{}
+	json.Unmarshal(got, &page)
+	if len(page.Records) != 1 || page.Bookmark != "" {
+		t.Fatalf("expected a last page of 1 record, got %s", got)
+	}
+	record := page.Records[0]
+	if record.Actor != "lht" || record.TimeStamp != testTime.Add(time.Hour).Format(TIME_FORMAT) {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	var before, after AssetObject
+	json.Unmarshal(record.Before, &before)
+	json.Unmarshal(record.After, &after)
+	if before.Owner != "lht" || after.Owner != "continental" {
+		t.Fatalf("unexpected change %s -> %s", record.Before, record.After)
+	}
+}
+
+func TestContractTimes(t *testing.T) {
+
+	stub := newStub(t)
+	created := testTime.Format(TIME_FORMAT)
+	ready := testTime.Add(time.Hour).Format(TIME_FORMAT)
+	updated := testTime.Add(2 * time.Hour).Format(TIME_FORMAT)
+
+	stub.MockTxTimestamp(testTime.Add(time.Hour))
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	stub.MockTxTimestamp(testTime.Add(2 * time.Hour))
+	mustInvoke(t, stub, bosch, "contractUpdation", "C1", "D3", "1")
+
+	sc := getContract(t, stub, "C1")
+	if sc.CreatedAt != created || sc.UpdatedAt != updated {
+		t.Fatalf("expected created %s and updated %s, got %+v", created, updated, sc)
+	}
+	want := map[int]string{STATE_OPEN: created, STATE_READYFORSHIPMENT: ready}
+	if fmt.Sprint(sc.StageTimes) != fmt.Sprint(want) {
+		t.Fatalf("expected stage times %v, got %v", want, sc.StageTimes)
+	}
+}
+
+func TestContractTimesAreDeterministic(t *testing.T) {
+
+	// the same transactions replayed on another peer write the same state, whatever the local clock
+	var peers []*shim.MockStub
+	for i := 0; i < 2; i++ {
+		txCount = 0
+		stub := newStub(t)
+		mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+		peers = append(peers, stub)
+		time.Sleep(time.Millisecond)
+	}
+	first, second := peers[0], peers[1]
+	if len(first.State) != len(second.State) {
+		t.Fatalf("expected %d keys, got %d", len(first.State), len(second.State))
+	}
+	for key, value := range first.State {
+		if string(second.State[key]) != string(value) {
+			t.Fatalf("state of %q differs: %s and %s", key, value, second.State[key])
+		}
+	}
+}
+
+func TestListContractsByTime(t *testing.T) {
+
+	stub := newStub(t)
+	stub.MockTxTimestamp(testTime.Add(24 * time.Hour))
+	mustInvoke(t, stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D2", "")
+	stub.MockTxTimestamp(testTime.Add(48 * time.Hour))
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D3")
+
+	day := func(days int) string { return testTime.Add(time.Duration(days) * 24 * time.Hour).Format(TIME_FORMAT) }
+	for _, test := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{TIME_CREATED, ""}, []string{"C1", "C2"}},
+		{[]string{TIME_CREATED, day(1)}, []string{"C2"}},
+		{[]string{TIME_CREATED, "", day(0)}, []string{"C1"}},
+		{[]string{TIME_CREATED, day(0), day(1)}, []string{"C1", "C2"}},
+		{[]string{TIME_CREATED, "2016-11-01T12:30:00+02:00", "2016-11-01T12:30:00+02:00"}, []string{"C1"}},
+		{[]string{TIME_CREATED, day(3), ""}, []string{}},
+		{[]string{TIME_UPDATED, "", day(1)}, []string{"C2"}},
+		{[]string{TIME_UPDATED, ""}, []string{"C2", "C1"}},
+		{[]string{"1", day(2), day(2)}, []string{"C1"}},
+		{[]string{"0", "", day(1)}, []string{"C1", "C2"}},
+	} {
+		got, err := query(stub, lht, "listContractsByTime", test.args...)
+		if err != nil {
+			t.Fatalf("listContractsByTime(%q) failed: %s", test.args, err)
+		}
+		var contracts []SalesContractObject
+		json.Unmarshal(got, &contracts)
+		ids := []string{}
+		for _, sc := range contracts {
+			ids = append(ids, sc.Contractid)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(test.want) {
+			t.Errorf("listContractsByTime(%q): expected %v, got %v", test.args, test.want, ids)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Batches - initAssetsBatch and transferAssetsBatch apply a JSON array of assets or transfers in one transaction.
+//			   Every item is validated before anything is written: either the whole batch is applied, or the
+//			   invoke fails with the report of the items at fault and the ledger is left untouched.
+//==============================================================================================================================
+const BATCH_ITEM_OK = "ok"
+const BATCH_ITEM_FAILED = "failed"
+
+// AssetTransfer struct - an item of transferAssetsBatch, also the arguments of ownerUpdation
+type AssetTransfer struct {
+	Serialno string `validate:"required"`
+	NewOwner string `validate:"required"`
+}
+
+// AssetsBatchRequest struct - the arguments of initAssetsBatch
+type AssetsBatchRequest struct {
+	Items []AssetRequest `validate:"required,length=1-500"`
+}
+
+// TransfersBatchRequest struct - the arguments of transferAssetsBatch
+type TransfersBatchRequest struct {
+	Items []AssetTransfer `validate:"required,length=1-500"`
+}
+
+// BatchItemResult struct - the outcome of one item of a batch
+type BatchItemResult struct {
+	Index    int
+	Serialno string
+	Status   string // BATCH_ITEM_OK or BATCH_ITEM_FAILED
+	Code     string // code of the error of a failed item, see errors.go
+	Error    string
+}
+
+// BatchReport struct - the response of a batch invoke, also carried by the error of a rejected batch
+type BatchReport struct {
+	Applied bool
+	Results []BatchItemResult
+}
+
+// UnmarshalJSON decodes an item of initAssetsBatch the way the named form of initAssset is decoded. The unknown
+// and mistyped fields of the item are kept in violations, to be reported with the item rather than dropped.
+func (req *AssetRequest) UnmarshalJSON(data []byte) error {
+
+	violations := decodeNamed(string(data), "an asset", namedFields(reflect.ValueOf(req).Elem()), make(map[string]bool))
+	if len(violations) == 1 && violations[0].Field == "" {
+		return errors.New(violations[0].Message)
+	}
+	req.violations = violations
+	return nil
+}
+
+// initAssetsBatch registers a list of assets, validating each one the way initAssset does, the caller being the
+// owner of each asset or an admin.
+// args: JSON array of assets, e.g. [{"Serialno":"1001","Partno":"LHTMO","Owner":"bosch"}], or {"Items":[...]}
+func (t *SimpleChaincode) initAssetsBatch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req AssetsBatchRequest
+	if err := decodeRequest("initAssetsBatch", args, &req); err != nil {
+		return nil, err
+	}
+	items := req.Items
+
+	report := BatchReport{Results: make([]BatchItemResult, len(items))}
+	assets := make([]AssetObject, len(items))
+	seen := make(map[string]bool)
+	for i, item := range items {
+		report.Results[i] = BatchItemResult{Index: i, Serialno: item.Serialno, Status: BATCH_ITEM_OK}
+		err := requestError("initAssetsBatch", item.violations)
+		if err == nil {
+			err = validateRequest("initAssetsBatch", &item)
+		}
+		ast := CreateAssetObject(item)
+		if err == nil && seen[ast.Serialno] {
+			err = invalidArgument("Serialno", "asset "+ast.Serialno+" is listed more than once")
+		}
+		if err == nil {
+			err = check_asset_absent(stub, ast.Serialno)
+		}
+		if err == nil && t.check_owner(stub, ast.Owner) != nil {
+			err = permissionDenied("initAssetsBatch")
+		}
+		if err == nil {
+			err = check_part_listed(stub, ast.Partno)
+		}
+		if err != nil {
+			report.fail(i, err)
+			continue
+		}
+		seen[ast.Serialno] = true
+		assets[i] = ast
+	}
+	if !report.ok() {
+		return nil, report.rejected("initAssetsBatch")
+	}
+
+	events := []AssetEvent{}
+	for _, ast := range assets {
+		if _, err := t.save_asset(stub, ast); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "initAssetsBatch() : write error while inserting record", err)
+		}
+		events = append(events, newAssetEvent(stub, "", ast))
+	}
+	if err := emitAssetBatchEvent(stub, EVENT_ASSETS_CREATED, events); err != nil {
+		return nil, err
+	}
+	logFor(stub, "").info("assets created", "count", len(assets))
+	report.Applied = true
+	return json.Marshal(report)
+}
+
+// transferAssetsBatch hands a list of assets over to new owners, validating each transfer the way ownerUpdation does,
+// the caller being the owner of each asset or an admin.
+// args: JSON array of transfers, e.g. [{"Serialno":"1001","NewOwner":"lht"}], or {"Items":[...]}
+func (t *SimpleChaincode) transferAssetsBatch(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req TransfersBatchRequest
+	if err := decodeRequest("transferAssetsBatch", args, &req); err != nil {
+		return nil, err
+	}
+	items := req.Items
+
+	report := BatchReport{Results: make([]BatchItemResult, len(items))}
+	assets := make([]AssetObject, len(items))
+	seen := make(map[string]bool)
+	for i, item := range items {
+		report.Results[i] = BatchItemResult{Index: i, Serialno: item.Serialno, Status: BATCH_ITEM_OK}
+		if err := validateRequest("transferAssetsBatch", &item); err != nil {
+			report.fail(i, err)
+			continue
+		}
+		if seen[item.Serialno] {
+			report.fail(i, invalidArgument("Serialno", "asset "+item.Serialno+" is listed more than once"))
+			continue
+		}
+		ast, err := getAssetObject(stub, item.Serialno)
+		if err != nil {
+			report.fail(i, err)
+			continue
+		}
+		if t.check_owner(stub, ast.Owner) != nil {
+			report.fail(i, permissionDenied("transferAssetsBatch"))
+			continue
+		}
+		if ast.Contractid != "" {
+			report.fail(i, conflict(ast.Serialno, "asset "+ast.Serialno+" is locked by contract "+ast.Contractid))
+			continue
+		}
+		seen[item.Serialno] = true
+		assets[i] = ast
+	}
+	if !report.ok() {
+		return nil, report.rejected("transferAssetsBatch")
+	}
+
+	events := []AssetEvent{}
+	for i, ast := range assets {
+		oldOwner := ast.Owner
+		ast.Owner = items[i].NewOwner
+		if _, err := t.save_asset(stub, ast); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "transferAssetsBatch() : write error while inserting record", err)
+		}
+		events = append(events, newAssetEvent(stub, oldOwner, ast))
+	}
+	if err := emitAssetBatchEvent(stub, EVENT_ASSETS_TRANSFERRED, events); err != nil {
+		return nil, err
+	}
+	logFor(stub, "").info("assets transferred", "count", len(assets))
+	report.Applied = true
+	return json.Marshal(report)
+}
+
+// check_asset_absent - Verifies no asset is registered under a serial number
+func check_asset_absent(stub shim.ChaincodeStubInterface, serialNo string) error {
+
+	assetKey, err := getAssetKey(serialNo)
+	if err != nil {
+		return err
+	}
+	assetAsBytes, err := stub.GetState(assetKey)
+	if err != nil {
+		return errors.New("Failed to get asset")
+	}
+	if assetAsBytes != nil {
+		return alreadyExists(serialNo, "Asset already exists "+serialNo)
+	}
+	return nil
+}
+
+func (r *BatchReport) fail(index int, err error) {
+	ce := asChaincodeError(err)
+	r.Results[index].Status = BATCH_ITEM_FAILED
+	r.Results[index].Code = ce.Code
+	r.Results[index].Error = ce.Message
+}
+
+func (r BatchReport) ok() bool {
+	for _, result := range r.Results {
+		if result.Status != BATCH_ITEM_OK {
+			return false
+		}
+	}
+	return true
+}
+
+// rejected returns the error of a batch that was not applied, carrying the report as JSON. The error has the
+// code of the first item at fault.
+func (r BatchReport) rejected(function string) error {
+
+	code := ERR_INVALID_ARGUMENT
+	for _, result := range r.Results {
+		if result.Status == BATCH_ITEM_FAILED {
+			code = result.Code
+			break
+		}
+	}
+	buff, err := json.Marshal(r)
+	if err != nil {
+		return &ChaincodeError{Code: code, Message: function + "() : batch rejected, nothing was applied"}
+	}
+	return &ChaincodeError{Code: code, Message: function + "() : batch rejected, nothing was applied : " + string(buff)}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestInitAssetsBatch(t *testing.T) {
+
+	stub := newStub(t)
+	// an owner registers its own assets only, an admin those of any owner
+	_, err := invoke(stub, bosch, "initAssetsBatch", `[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2002","Partno":"A320","Owner":"lht"}]`)
+	checkCode(t, err, ERR_PERMISSION_DENIED, "", "")
+	if !strings.Contains(err.Error(), `\"Serialno\":\"2002\",\"Status\":\"failed\"`) {
+		t.Fatalf("expected item 2002 to fail, got %s", err)
+	}
+	got, err := invoke(stub, admin, "initAssetsBatch", `[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2002","Partno":"A320","Owner":"lht"}]`)
+	if err != nil {
+		t.Fatalf("initAssetsBatch failed: %s", err)
+	}
+	var report BatchReport
+	json.Unmarshal(got, &report)
+	if !report.Applied || len(report.Results) != 2 || report.Results[1].Serialno != "2002" || report.Results[1].Status != BATCH_ITEM_OK {
+		t.Fatalf("unexpected report %s", got)
+	}
+	if ast := getAsset(t, stub, "2002"); ast.Owner != "lht" || ast.Partno != "A320" {
+		t.Fatalf("unexpected asset %+v", ast)
+	}
+	var ev AssetBatchEvent
+	checkEvent(t, stub, EVENT_ASSETS_CREATED, &ev)
+	if len(ev.Assets) != 2 || ev.Assets[0].Serialno != "2001" || ev.Assets[0].NewOwner != "bosch" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	got, _ = query(stub, lht, "listAssetsByOwner", "lht")
+	if !strings.Contains(string(got), `"Serialno":"2002"`) {
+		t.Fatalf("expected 2002 in the owner index, got %s", got)
+	}
+}
+
+func TestTransferAssetsBatch(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initAssset", "1003", "LHTMO", "bosch")
+	_, err := invoke(stub, lht, "transferAssetsBatch", `[{"Serialno":"1002","NewOwner":"lht"}]`)
+	checkCode(t, err, ERR_PERMISSION_DENIED, "", "")
+	if getAsset(t, stub, "1002").Owner != "bosch" {
+		t.Fatal("expected 1002 to stay with bosch")
+	}
+	got, err := invoke(stub, bosch, "transferAssetsBatch", `[{"Serialno":"1002","NewOwner":"lht"},{"Serialno":"1003","NewOwner":"ups"}]`)
+	if err != nil {
+		t.Fatalf("transferAssetsBatch failed: %s", err)
+	}
+	var report BatchReport
+	json.Unmarshal(got, &report)
+	if !report.Applied || len(report.Results) != 2 {
+		t.Fatalf("unexpected report %s", got)
+	}
+	if getAsset(t, stub, "1002").Owner != "lht" || getAsset(t, stub, "1003").Owner != "ups" {
+		t.Fatal("expected both assets to change hands")
+	}
+	var ev AssetBatchEvent
+	checkEvent(t, stub, EVENT_ASSETS_TRANSFERRED, &ev)
+	if len(ev.Assets) != 2 || ev.Assets[1].OldOwner != "bosch" || ev.Assets[1].NewOwner != "ups" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestRejectedBatches(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+		wantErr  string
+		failed   []int // items reported at fault
+	}{
+		{"assets not an array", "initAssetsBatch", []string{`{"Serialno":"2001"}`}, "unknown field Serialno", nil},
+		{"assets missing argument", "initAssetsBatch", nil, "Items is required", nil},
+		{"empty assets", "initAssetsBatch", []string{`[]`}, "Items is required", nil},
+		{"invalid assets", "initAssetsBatch",
+			[]string{`[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"S2002","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"1001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"}]`},
+			"batch rejected", []int{1, 2, 3}},
+		{"assets with fields set by the chaincode", "initAssetsBatch",
+			[]string{`[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2002","Partno":"LHTMO","Owner":"bosch","Contractid":"C1"},{"Serialno":"2003","Partno":"LHTMO","Owner":"bosch","Status":"active"},{"Serialno":2004,"Partno":"LHTMO","Owner":"bosch"}]`},
+			"batch rejected", []int{1, 2, 3}},
+		{"asset not an object", "initAssetsBatch", []string{`["2001"]`}, "Items should be", nil},
+		{"transfers not an array", "transferAssetsBatch", []string{`"1001"`}, "Items should be a JSON array", nil},
+		{"invalid transfers", "transferAssetsBatch",
+			[]string{`[{"Serialno":"1002","NewOwner":"lht"},{"Serialno":"1001","NewOwner":"lht"},{"Serialno":"1009","NewOwner":"lht"},{"Serialno":"1002","NewOwner":"ups"},{"Serialno":"1002"}]`},
+			"batch rejected", []int{1, 2, 3, 4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newStub(t)
+			keys := len(stub.State)
+			_, err := invoke(stub, bosch, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+			if len(stub.State) != keys || getAsset(t, stub, "1002").Owner != "bosch" {
+				t.Fatal("expected a rejected batch to leave the ledger untouched")
+			}
+			if test.failed == nil {
+				return
+			}
+
+			var report BatchReport
+			message := asChaincodeError(err).Message
+			if err = json.Unmarshal([]byte(message[strings.Index(message, "{"):]), &report); err != nil {
+				t.Fatalf("the error does not carry a report: %s", err)
+			}
+			failed := []int{}
+			for _, result := range report.Results {
+				if result.Status == BATCH_ITEM_FAILED {
+					if result.Error == "" {
+						t.Fatalf("item %d failed without an error", result.Index)
+					}
+					failed = append(failed, result.Index)
+				}
+			}
+			if report.Applied || len(failed) != len(test.failed) {
+				t.Fatalf("expected items %v to fail, got %+v", test.failed, report)
+			}
+			for i := range failed {
+				if failed[i] != test.failed[i] {
+					t.Fatalf("expected items %v to fail, got %v", test.failed, failed)
+				}
+			}
+		})
+	}
+}
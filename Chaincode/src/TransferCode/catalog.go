@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Part catalog - the master data of the part numbers assets are registered under. An admin lists each part number
+//					with its manufacturer and description, an asset can only be registered under a listed part
+//					number. A part superseded by a newer one stays in the catalog with a link to its successor, so
+//					older stock can still be registered and traced, and readPart follows the links to the part
+//					currently made. The manufacturer of a part launches its recalls, see recall.go.
+//==============================================================================================================================
+
+// Longest chain of superseded-by links readPart follows
+const MAX_SUPERSESSIONS = 100
+
+// PartObject struct
+type PartObject struct {
+	Partno       string
+	Manufacturer string // enrollment ID of the manufacturer
+	Description  string
+	SupersededBy string // the part number replacing this one, empty while it is current
+	CreatedAt    string // transaction time of registerPart
+	UpdatedAt    string // transaction time of the last change
+}
+
+// CatalogEntry struct - the response of readPart
+type CatalogEntry struct {
+	Part         PartObject
+	Replacements []string // the part numbers superseding the part in order, the last one is current
+}
+
+// PartRequest struct - the arguments of registerPart
+type PartRequest struct {
+	Partno       string `validate:"required"`
+	Manufacturer string `validate:"required"`
+	Description  string `validate:"required,length=1-1000"`
+	SupersededBy string
+}
+
+// SupersedeRequest struct - the arguments of supersedePart
+type SupersedeRequest struct {
+	Partno       string `validate:"required"`
+	SupersededBy string `validate:"required"`
+}
+
+// PartnoRequest struct - the arguments of readPart
+type PartnoRequest struct {
+	Partno string `validate:"required"`
+}
+
+// registerPart lists a part number in the catalog. Only admins register parts.
+// args: partno, manufacturer, description, [supersededBy] or
+// {"Partno":"LHTMO","Manufacturer":"bosch","Description":"landing gear actuator"}
+func (t *SimpleChaincode) registerPart(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req PartRequest
+	if err := decodeRequest("registerPart", args, &req); err != nil {
+		return nil, err
+	}
+	if _, err := t.check_role(stub, ADMIN); err != nil {
+		logFor(stub, req.Partno).debug("caller may not register parts", "reason", err)
+		return nil, permissionDenied("registerPart")
+	}
+
+	// check if the part already exists
+	listed, err := part_listed(stub, req.Partno)
+	if err != nil {
+		return nil, err
+	}
+	if listed {
+		return nil, alreadyExists(req.Partno, "part already exists "+req.Partno)
+	}
+	part := PartObject{Partno: req.Partno, Manufacturer: req.Manufacturer, Description: req.Description, SupersededBy: req.SupersededBy}
+	if err = check_successor(stub, part); err != nil {
+		return nil, err
+	}
+
+	if err = save_part(stub, &part); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "registerPart() : write error while inserting record", err)
+	}
+	if err = emitPartEvent(stub, EVENT_PART_REGISTERED, part); err != nil {
+		return nil, err
+	}
+	logFor(stub, part.Partno).info("part registered", "manufacturer", part.Manufacturer, "supersededBy", part.SupersededBy)
+	return nil, nil
+}
+
+// supersedePart links a part number to the one replacing it. Admins and the manufacturer of the part supersede it.
+// args: partno, supersededBy
+func (t *SimpleChaincode) supersedePart(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req SupersedeRequest
+	if err := decodeRequest("supersedePart", args, &req); err != nil {
+		return nil, err
+	}
+	part, err := getPartObject(stub, req.Partno)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.check_manufacturer(stub, part); err != nil {
+		logFor(stub, part.Partno).debug("caller may not supersede the part", "reason", err)
+		return nil, permissionDenied("supersedePart")
+	}
+
+	part.SupersededBy = req.SupersededBy
+	if err = check_successor(stub, part); err != nil {
+		return nil, err
+	}
+	if err = save_part(stub, &part); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "supersedePart() : write error while inserting record", err)
+	}
+	if err = emitPartEvent(stub, EVENT_PART_SUPERSEDED, part); err != nil {
+		return nil, err
+	}
+	logFor(stub, part.Partno).info("part superseded", "supersededBy", part.SupersededBy)
+	return nil, nil
+}
+
+// readPart returns a part of the catalog and the part numbers superseding it. Any caller with a certificate reads it.
+// args: partno
+func (t *SimpleChaincode) readPart(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req PartnoRequest
+	if err := decodeRequest("readPart", args, &req); err != nil {
+		return nil, err
+	}
+	if t.get_reader(stub).username == "" {
+		return nil, permissionDenied("readPart")
+	}
+	part, err := getPartObject(stub, req.Partno)
+	if err != nil {
+		return nil, err
+	}
+	replacements, err := getReplacements(stub, part)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(CatalogEntry{part, replacements})
+}
+
+// check_manufacturer - Verifies that the caller is an admin or the manufacturer of a part
+func (t *SimpleChaincode) check_manufacturer(stub shim.ChaincodeStubInterface, part PartObject) error {
+
+	caller, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return err
+	}
+	if role == ADMIN {
+		_, err = t.check_role(stub, ADMIN)
+		return err
+	}
+	if caller != part.Manufacturer {
+		return errors.New("caller " + caller + " is not the manufacturer of part " + part.Partno)
+	}
+	return nil
+}
+
+// check_successor - Verifies that the part superseding a part is listed and does not lead back to it
+func check_successor(stub shim.ChaincodeStubInterface, part PartObject) error {
+
+	if part.SupersededBy == "" {
+		return nil
+	}
+	if part.SupersededBy == part.Partno {
+		return invalidArgument("SupersededBy", "part "+part.Partno+" can't supersede itself")
+	}
+	successor, err := getPartObject(stub, part.SupersededBy)
+	if err != nil {
+		return invalidArgument("SupersededBy", "part "+part.SupersededBy+" is not in the catalog")
+	}
+	replacements, err := getReplacements(stub, successor)
+	if err != nil {
+		return err
+	}
+	if contains(replacements, part.Partno) {
+		return conflict(part.Partno, "part "+part.Partno+" already supersedes "+part.SupersededBy)
+	}
+	return nil
+}
+
+// check_part_listed - Verifies that an asset is registered under a part number of the catalog
+func check_part_listed(stub shim.ChaincodeStubInterface, partno string) error {
+
+	listed, err := part_listed(stub, partno)
+	if err != nil {
+		return err
+	}
+	if !listed {
+		return invalidArgument("Partno", "part "+partno+" is not in the catalog")
+	}
+	return nil
+}
+
+// part_listed - Tells whether a part number is in the catalog
+func part_listed(stub shim.ChaincodeStubInterface, partno string) (bool, error) {
+
+	partKey, err := getPartKey(partno)
+	if err != nil {
+		return false, err
+	}
+	partAsBytes, err := stub.GetState(partKey)
+	if err != nil {
+		return false, errors.New("Failed to get part")
+	}
+	return partAsBytes != nil, nil
+}
+
+// getReplacements follows the superseded-by links of a part and returns the part numbers met, in order
+func getReplacements(stub shim.ChaincodeStubInterface, part PartObject) ([]string, error) {
+
+	replacements := []string{}
+	for part.SupersededBy != "" {
+		if len(replacements) == MAX_SUPERSESSIONS || contains(replacements, part.SupersededBy) {
+			return nil, wrapError(ERR_INTERNAL, "getReplacements()", errors.New("superseded-by links of "+part.Partno+" do not end"))
+		}
+		replacements = append(replacements, part.SupersededBy)
+		next, err := getPartObject(stub, part.SupersededBy)
+		if err != nil {
+			return nil, wrapError(ERR_INTERNAL, "getReplacements()", err)
+		}
+		part = next
+	}
+	return replacements, nil
+}
+
+// save_part - Writes a part of the catalog, stamped with the transaction time
+func save_part(stub shim.ChaincodeStubInterface, part *PartObject) error {
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	part.UpdatedAt = txTime.Format(TIME_FORMAT)
+	if part.CreatedAt == "" {
+		part.CreatedAt = part.UpdatedAt
+	}
+	partKey, err := getPartKey(part.Partno)
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(part)
+	if err != nil {
+		return errors.New("Error converting part")
+	}
+	return stub.PutState(partKey, buff)
+}
+
+func getPartObject(stub shim.ChaincodeStubInterface, partno string) (PartObject, error) {
+
+	var part PartObject
+	partKey, err := getPartKey(partno)
+	if err != nil {
+		return part, err
+	}
+	partAsBytes, err := stub.GetState(partKey)
+	if err != nil {
+		return part, errors.New("Failed to get part")
+	}
+	if partAsBytes == nil {
+		return part, notFound(partno, "no part for "+partno)
+	}
+	if err = json.Unmarshal(partAsBytes, &part); err != nil {
+		return part, wrapError(ERR_INTERNAL, "invalid part record", err)
+	}
+	return part, nil
+}
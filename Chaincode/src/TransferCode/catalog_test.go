@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPartCatalog(t *testing.T) {
+
+	stub := newCatalogStub(t, "TransferCode")
+	mustInvoke(t, stub, admin, "registerPart", `{"Partno":"LHTMO-2","Manufacturer":"bosch","Description":"landing gear actuator, new seal"}`)
+	var ev PartEvent
+	checkEvent(t, stub, EVENT_PART_REGISTERED, &ev)
+	if ev.Part.Partno != "LHTMO-2" || ev.Part.Manufacturer != "bosch" || ev.Part.CreatedAt != testTime.Format(TIME_FORMAT) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	mustInvoke(t, stub, admin, "registerPart", "LHTMO-3", "bosch", "landing gear actuator, titanium")
+
+	// the manufacturer supersedes its parts, the links lead to the part currently made
+	mustInvoke(t, stub, bosch, "supersedePart", "LHTMO", "LHTMO-2")
+	checkEvent(t, stub, EVENT_PART_SUPERSEDED, &ev)
+	mustInvoke(t, stub, admin, "supersedePart", "LHTMO-2", "LHTMO-3")
+	got, err := query(stub, dhl, "readPart", "LHTMO")
+	if err != nil {
+		t.Fatalf("readPart failed: %s", err)
+	}
+	var entry CatalogEntry
+	json.Unmarshal(got, &entry)
+	if entry.Part.SupersededBy != "LHTMO-2" || !reflect.DeepEqual(entry.Replacements, []string{"LHTMO-2", "LHTMO-3"}) {
+		t.Fatalf("unexpected catalog entry %s", got)
+	}
+
+	// assets are registered under listed part numbers only, superseded ones included
+	mustInvoke(t, stub, bosch, "initAssset", "1001", "LHTMO", "bosch")
+	_, err = invoke(stub, bosch, "initAssset", "1002", "XYZ", "bosch")
+	checkCode(t, err, ERR_INVALID_ARGUMENT, "Partno", "")
+	checkError(t, err, "part XYZ is not in the catalog")
+	_, err = invoke(stub, bosch, "initAssetsBatch", `[{"Serialno":"1003","Partno":"A320","Owner":"bosch"},{"Serialno":"1004","Partno":"XYZ","Owner":"bosch"}]`)
+	checkError(t, err, "part XYZ is not in the catalog")
+
+	tests := []struct {
+		name     string
+		caller   caller
+		function string
+		args     []string
+		wantErr  string
+	}{
+		{"registered by a seller", bosch, "registerPart", []string{"BOLT", "bosch", "bolt"}, "Permission Denied"},
+		{"registered twice", admin, "registerPart", []string{"A320", "airbus", "airframe"}, "part already exists A320"},
+		{"no description", admin, "registerPart", []string{"BOLT", "bosch"}, "Description is required"},
+		{"unknown successor", admin, "registerPart", []string{"BOLT", "bosch", "bolt", "BOLT-2"}, "part BOLT-2 is not in the catalog"},
+		{"superseded by another manufacturer", continental, "supersedePart", []string{"LHTMO-2", "A320"}, "Permission Denied"},
+		{"superseded by itself", bosch, "supersedePart", []string{"LHTMO-3", "LHTMO-3"}, "can't supersede itself"},
+		{"superseded in a loop", bosch, "supersedePart", []string{"LHTMO-3", "LHTMO"}, "already supersedes LHTMO"},
+		{"unknown part", bosch, "supersedePart", []string{"BOLT", "LHTMO"}, "no part for BOLT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+	_, err = query(stub, anonymous, "readPart", "LHTMO")
+	checkError(t, err, "Permission Denied")
+}
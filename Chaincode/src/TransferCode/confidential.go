@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/crypto/primitives"
+)
+
+//==============================================================================================================================
+//	 Confidential contracts - a contract created with a key in the metadata of the transaction keeps its parties,
+//							  pricing, document IDs and breaches encrypted on the ledger. The key is an AES-256 key of
+//							  the contract chosen by its creator, who wraps it off the ledger for each party with the
+//							  public key of the party's enrollment certificate; each party reads its own wrapped key
+//							  with getWrappedKey. Invokes and queries on the contract carry the key in their metadata.
+//							  Without it the contract reads sealed, its parties empty: only auditors and admins read
+//							  it, nobody changes it, and it is not listed under its parties or breaches. The wrapped
+//							  keys are listed by an HMAC of the enrollment ID of each party under a salt drawn for the
+//							  contract, and the events and history of the contract leave out who made each change.
+//							  Only the contract record is sealed. Its assets, documents, checkpoints, escrow and
+//							  postings stay in clear, so a deal that must not show its price is not paid through the
+//							  escrow. Every validating peer runs the transaction and must write the same ciphertext,
+//							  so the IV is derived from the key and the transaction ID rather than drawn at random as
+//							  primitives.CBCPKCS7Encrypt does.
+//==============================================================================================================================
+
+// ContractKeys struct - the metadata of a transaction on confidential contracts
+type ContractKeys struct {
+	Keys        map[string]string // base64 AES-256 key of each confidential contract the transaction uses, by contract ID
+	WrappedKeys map[string]string // the key of the contract initContract creates, wrapped for each of its parties by enrollment ID
+}
+
+// SealedFields struct - the encrypted part of a confidential contract
+type SealedFields struct {
+	Ciphertext  string            // base64 IV and AES-CBC encryption of the ConfidentialFields, empty once opened with the key
+	KeyCheck    string            // base64 HMAC-SHA256 of the contract ID under the key, tells a wrong key
+	WrappedKeys map[string]string // the key wrapped for each party, by the partyHash of its enrollment ID
+	Salt        string            // base64 salt of the partyHash of the contract, empty for a contract sealed before salts
+}
+
+// ConfidentialFields struct - the fields of a confidential contract kept in SealedFields
+type ConfidentialFields struct {
+	Buyer          string
+	Transporter    string
+	Seller         string
+	DocumentID     string
+	EvidenceID     string
+	Currency       string
+	Total          int64
+	TransporterFee int64
+	Prices         []LinePrice // by line item
+	Breaches       []Breach
+}
+
+// LinePrice struct - the pricing of a line item of a confidential contract
+type LinePrice struct {
+	UnitPrice int64
+	Amount    int64
+}
+
+// sealed reports whether the confidential fields of a contract are still encrypted
+func (sc SalesContractObject) sealed() bool {
+	return sc.Sealed != nil && sc.Sealed.Ciphertext != ""
+}
+
+// getContractKeys reads the keys of confidential contracts from the metadata of the transaction
+func getContractKeys(stub shim.ChaincodeStubInterface) (ContractKeys, error) {
+
+	var keys ContractKeys
+	metadata, err := stub.GetCallerMetadata()
+	if err != nil {
+		return keys, wrapError(ERR_INTERNAL, "Error reading the transaction metadata", err)
+	}
+	if len(metadata) == 0 {
+		return keys, nil
+	}
+	if err = json.Unmarshal(metadata, &keys); err != nil {
+		return keys, wrapError(ERR_INVALID_ARGUMENT, "the transaction metadata should be a JSON object", err)
+	}
+	return keys, nil
+}
+
+// get_sealing_key - Returns the key of a confidential contract carried by the transaction, nil when there is none
+func get_sealing_key(stub shim.ChaincodeStubInterface, contractID string) ([]byte, error) {
+
+	keys, err := getContractKeys(stub)
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := keys.Keys[contractID]
+	if !ok {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != primitives.AESKeyLength {
+		return nil, &ChaincodeError{ERR_INVALID_ARGUMENT, fmt.Sprintf("the key of contract %s should be %d bytes in base64", contractID, primitives.AESKeyLength), "Keys", contractID, nil}
+	}
+	return key, nil
+}
+
+// new_sealed_fields - Returns the sealed part of a contract created with a key, nil for a contract in clear
+func new_sealed_fields(stub shim.ChaincodeStubInterface, sc SalesContractObject) (*SealedFields, error) {
+
+	key, err := get_sealing_key(stub, sc.Contractid)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	keys, err := getContractKeys(stub)
+	if err != nil {
+		return nil, err
+	}
+	// the salt is derived from the key like the IV of seal_contract, so every validating peer writes the same one
+	salt := hmacSHA256(key, []byte("salt"+compositeKeySeparator+stub.GetTxID()+compositeKeySeparator+sc.Contractid))[:16]
+	sealed := &SealedFields{KeyCheck: keyCheck(key, sc.Contractid), WrappedKeys: make(map[string]string), Salt: base64.StdEncoding.EncodeToString(salt)}
+	for _, party := range []string{sc.Buyer, sc.Transporter, sc.Seller} {
+		wrapped, ok := keys.WrappedKeys[party]
+		if !ok || wrapped == "" {
+			return nil, &ChaincodeError{ERR_INVALID_ARGUMENT, "the key of contract " + sc.Contractid + " is not wrapped for " + party, "WrappedKeys", sc.Contractid, nil}
+		}
+		sealed.WrappedKeys[partyHash(sealed.Salt, party)] = wrapped
+	}
+	return sealed, nil
+}
+
+// seal_contract - Returns a confidential contract as it is stored, its confidential fields encrypted with the key
+// carried by the transaction. A contract in clear is returned as it is.
+func seal_contract(stub shim.ChaincodeStubInterface, sc SalesContractObject) (SalesContractObject, error) {
+
+	if sc.Sealed == nil {
+		return sc, nil
+	}
+	if sc.sealed() {
+		return sc, &ChaincodeError{ERR_PERMISSION_DENIED, "contract " + sc.Contractid + " is confidential, the transaction carries no key for it", "Keys", sc.Contractid, nil}
+	}
+	key, err := get_checked_key(stub, sc)
+	if err != nil {
+		return sc, err
+	}
+
+	fields := ConfidentialFields{sc.Buyer, sc.Transporter, sc.Seller, sc.DocumentID, sc.EvidenceID, sc.Currency, sc.Total, sc.TransporterFee, []LinePrice{}, sc.Breaches}
+	lines := make([]LineItem, len(sc.LineItems))
+	for i, line := range sc.LineItems {
+		fields.Prices = append(fields.Prices, LinePrice{line.UnitPrice, line.Amount})
+		line.UnitPrice, line.Amount = 0, 0
+		lines[i] = line
+	}
+	plain, err := json.Marshal(fields)
+	if err != nil {
+		return sc, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return sc, err
+	}
+	iv := hmacSHA256(key, []byte(stub.GetTxID()+compositeKeySeparator+sc.Contractid))[:aes.BlockSize]
+	padded := primitives.PKCS7Padding(plain)
+	ciphertext := make([]byte, aes.BlockSize+len(padded))
+	copy(ciphertext, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext[aes.BlockSize:], padded)
+
+	stored := sc
+	stored.Buyer, stored.Transporter, stored.Seller = "", "", ""
+	stored.DocumentID, stored.EvidenceID, stored.Currency = "", "", ""
+	stored.Total, stored.TransporterFee = 0, 0
+	stored.LineItems = lines
+	stored.Breaches = nil
+	sealed := *sc.Sealed
+	sealed.Ciphertext = base64.StdEncoding.EncodeToString(ciphertext)
+	stored.Sealed = &sealed
+	return stored, nil
+}
+
+// open_contract - Decrypts the confidential fields of a stored contract with the key carried by the transaction.
+// The contract stays sealed when the transaction carries no key for it.
+func open_contract(stub shim.ChaincodeStubInterface, sc SalesContractObject) (SalesContractObject, error) {
+
+	if !sc.sealed() {
+		return sc, nil
+	}
+	key, err := get_checked_key(stub, sc)
+	if err != nil || key == nil {
+		return sc, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sc.Sealed.Ciphertext)
+	if err != nil {
+		return sc, errors.New("invalid ciphertext of contract " + sc.Contractid)
+	}
+	plain, err := primitives.CBCPKCS7Decrypt(key, ciphertext)
+	if err != nil {
+		return sc, errors.New("invalid ciphertext of contract " + sc.Contractid + " : " + err.Error())
+	}
+	var fields ConfidentialFields
+	if err = json.Unmarshal(plain, &fields); err != nil || len(fields.Prices) != len(sc.LineItems) {
+		return sc, errors.New("invalid ciphertext of contract " + sc.Contractid)
+	}
+
+	sc.Buyer, sc.Transporter, sc.Seller = fields.Buyer, fields.Transporter, fields.Seller
+	sc.DocumentID, sc.EvidenceID, sc.Currency = fields.DocumentID, fields.EvidenceID, fields.Currency
+	sc.Total, sc.TransporterFee = fields.Total, fields.TransporterFee
+	lines := make([]LineItem, len(sc.LineItems))
+	for i, line := range sc.LineItems {
+		line.UnitPrice, line.Amount = fields.Prices[i].UnitPrice, fields.Prices[i].Amount
+		lines[i] = line
+	}
+	sc.LineItems = lines
+	sc.Breaches = fields.Breaches
+	opened := *sc.Sealed
+	opened.Ciphertext = ""
+	sc.Sealed = &opened
+	return sc, nil
+}
+
+// get_checked_key - Returns the key of a confidential contract carried by the transaction after checking it is the
+// key of the contract, nil when there is none
+func get_checked_key(stub shim.ChaincodeStubInterface, sc SalesContractObject) ([]byte, error) {
+
+	key, err := get_sealing_key(stub, sc.Contractid)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(keyCheck(key, sc.Contractid)), []byte(sc.Sealed.KeyCheck)) {
+		return nil, &ChaincodeError{ERR_PERMISSION_DENIED, "wrong key for contract " + sc.Contractid, "Keys", sc.Contractid, nil}
+	}
+	return key, nil
+}
+
+// check_open - Verifies the confidential fields of a contract about to be changed were decrypted
+func check_open(sc SalesContractObject) error {
+
+	if sc.sealed() {
+		return &ChaincodeError{ERR_PERMISSION_DENIED, "contract " + sc.Contractid + " is confidential, the transaction carries no key for it", "Keys", sc.Contractid, nil}
+	}
+	return nil
+}
+
+// getWrappedKey returns the key of a confidential contract wrapped for the caller, who unwraps it with the private key
+// of its enrollment certificate and passes it in the metadata of the transactions on the contract.
+// args: contractid
+func (t *SimpleChaincode) getWrappedKey(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("getWrappedKey", args, &req); err != nil {
+		return nil, err
+	}
+	contractID := req.Contractid
+	sc, err := getContractObject(stub, contractID)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if sc.Sealed == nil {
+		return nil, invalidArgument("", "getWrappedKey() : contract "+contractID+" is not confidential")
+	}
+	username, _, err := t.get_caller_data(stub)
+	wrapped, ok := sc.Sealed.WrappedKeys[partyHash(sc.Sealed.Salt, username)]
+	if err != nil || !ok {
+		logFor(stub, contractID).debug("no wrapped key for the caller")
+		return nil, permissionDenied("getWrappedKey")
+	}
+	return []byte(wrapped), nil
+}
+
+// keyCheck returns the value a contract stores to tell whether a key is its own
+func keyCheck(key []byte, contractID string) string {
+	return base64.StdEncoding.EncodeToString(hmacSHA256(key, []byte(contractID)))
+}
+
+// partyHash returns the name a wrapped key is stored under for a party, the hex HMAC-SHA256 of its enrollment ID
+// under the salt of the contract. A contract sealed without a salt lists its keys by the plain SHA-256.
+func partyHash(salt string, username string) string {
+	if salt == "" {
+		sum := sha256.Sum256([]byte(username))
+		return hex.EncodeToString(sum[:])
+	}
+	return hex.EncodeToString(hmacSHA256([]byte(salt), []byte(username)))
+}
+
+func hmacSHA256(key []byte, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
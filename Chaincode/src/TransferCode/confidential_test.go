@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+var sealingKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+var wrappedKeys = map[string]string{"lht": "a2V5IG9mIGJ1eWVy", "dhl": "a2V5IG9mIHRyYW5zcG9ydGVy", "bosch": "a2V5IG9mIHNlbGxlcg=="}
+
+const confidentialContract = `{"Contractid":"C7","Buyer":"lht","Transporter":"dhl","Seller":"bosch","DocumentID":"PO-7734","Currency":"EUR",
+	"LineItems":[{"AssetIDs":["1002"],"UnitPrice":424242}],"TransporterFee":1717}`
+
+// withKeys sets the caller of the following transactions along with the keys of confidential contracts
+func withKeys(stub *shim.MockStub, c caller, keys ContractKeys) {
+
+	as(stub, c)
+	metadata, _ := json.Marshal(keys)
+	stub.MockCaller(nil, metadata, map[string]string{ATTR_USERNAME: c.username, ATTR_ROLE: c.role})
+}
+
+func invokeWithKey(stub *shim.MockStub, c caller, function string, args ...string) ([]byte, error) {
+
+	txCount++
+	withKeys(stub, c, ContractKeys{Keys: map[string]string{"C7": sealingKey}})
+	return stub.MockInvoke(fmt.Sprintf("tx%06d", txCount), function, args)
+}
+
+func queryWithKey(stub *shim.MockStub, c caller, function string, args ...string) ([]byte, error) {
+
+	withKeys(stub, c, ContractKeys{Keys: map[string]string{"C7": sealingKey}})
+	return stub.MockQuery(function, args)
+}
+
+// newConfidentialStub returns the stub of newStub with the confidential contract C7 selling asset 1002 to lht
+func newConfidentialStub(t *testing.T) *shim.MockStub {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "500000")
+	txCount++
+	withKeys(stub, bosch, ContractKeys{map[string]string{"C7": sealingKey}, wrappedKeys})
+	if _, err := stub.MockInvoke(fmt.Sprintf("tx%06d", txCount), "initContract", []string{confidentialContract}); err != nil {
+		t.Fatalf("initContract failed: %s", err)
+	}
+	if _, err := invokeWithKey(stub, lht, "fundEscrow", "C7"); err != nil {
+		t.Fatalf("fundEscrow failed: %s", err)
+	}
+	return stub
+}
+
+// checkSealed verifies the stored contract shows none of its confidential fields
+func checkSealed(t *testing.T, stub *shim.MockStub) {
+
+	key, _ := getContractKey("C7")
+	stored := string(stub.State[key])
+	for _, secret := range []string{"lht", "dhl", "bosch", "PO-7734", "EUR", "424242", "1717", "PO-8812"} {
+		if strings.Contains(stored, secret) {
+			t.Fatalf("expected %s to be sealed, got %s", secret, stored)
+		}
+	}
+}
+
+func TestConfidentialContract(t *testing.T) {
+
+	stub := newConfidentialStub(t)
+	checkSealed(t, stub)
+
+	// a party reads the contract with the key, an auditor reads it sealed
+	got, err := queryWithKey(stub, lht, "readContract", "C7")
+	if err != nil {
+		t.Fatalf("readContract with the key failed: %s", err)
+	}
+	var sc SalesContractObject
+	json.Unmarshal(got, &sc)
+	if sc.Buyer != "lht" || sc.DocumentID != "PO-7734" || sc.Total != 424242 || sc.LineItems[0].UnitPrice != 424242 || sc.TransporterFee != 1717 {
+		t.Fatalf("unexpected contract %s", got)
+	}
+	_, err = query(stub, lht, "readContract", "C7")
+	checkError(t, err, "Permission Denied")
+	got, err = query(stub, auditor, "readContract", "C7")
+	if err != nil {
+		t.Fatalf("readContract as auditor failed: %s", err)
+	}
+	json.Unmarshal(got, &sc)
+	if sc.Buyer != "" || sc.Total != 0 || !sc.sealed() {
+		t.Fatalf("expected the auditor to read the contract sealed, got %s", got)
+	}
+
+	// a sealed contract is not listed under its parties
+	got, _ = query(stub, auditor, "listContractsByBuyer", "lht")
+	var listed []SalesContractObject
+	json.Unmarshal(got, &listed)
+	if len(listed) != 1 || listed[0].Contractid != "C1" {
+		t.Fatalf("expected lht to be listed as buyer of C1 only, got %s", got)
+	}
+
+	// each party reads its own wrapped key
+	for _, party := range []caller{lht, dhl, bosch} {
+		if got, err := query(stub, party, "getWrappedKey", "C7"); err != nil || string(got) != wrappedKeys[party.username] {
+			t.Fatalf("getWrappedKey as %s: got %s, %v", party.username, got, err)
+		}
+	}
+	_, err = query(stub, continental, "getWrappedKey", "C7")
+	checkError(t, err, "Permission Denied")
+	_, err = query(stub, lht, "getWrappedKey", "C1")
+	checkError(t, err, "is not confidential")
+
+	// changes need the key
+	_, err = invoke(stub, bosch, "readyForShipment", "C7", "PO-8812")
+	checkError(t, err, "transaction carries no key")
+	_, err = invoke(stub, bosch, "contractUpdation", "C7", "PO-8812", "0")
+	checkError(t, err, "transaction carries no key")
+	if _, err = invokeWithKey(stub, bosch, "readyForShipment", "C7", "PO-8812"); err != nil {
+		t.Fatalf("readyForShipment with the key failed: %s", err)
+	}
+	var event ContractEvent
+	checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &event)
+	if event.DocumentID != "" || event.Actor != "" {
+		t.Fatalf("expected the event to leave out the document and the actor, got %+v", event)
+	}
+	for _, step := range []struct {
+		caller caller
+		action string
+	}{{dhl, "inTransit"}, {dhl, "shipmentReached"}, {lht, "shipmentDelivered"}} {
+		if _, err = invokeWithKey(stub, step.caller, step.action, "C7"); err != nil {
+			t.Fatalf("%s with the key failed: %s", step.action, err)
+		}
+	}
+	checkSealed(t, stub)
+	if ast := getAsset(t, stub, "1002"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("expected asset 1002 to be handed to lht, got %+v", ast)
+	}
+	checkBalances(t, stub, map[string]int64{"lht": 500000 - 424242 - 1717, "bosch": 424242, "dhl": 1717})
+	got, _ = queryWithKey(stub, lht, "readContract", "C7")
+	json.Unmarshal(got, &sc)
+	if sc.Stage != STATE_SHIPMENT_DELIVERED || sc.DocumentID != "PO-8812" || !sc.delivered() {
+		t.Fatalf("unexpected contract %s", got)
+	}
+
+	// the history doesn't tell who changed the contract
+	got, err = query(stub, auditor, "getHistory", "contract", "C7")
+	if err != nil {
+		t.Fatalf("getHistory failed: %s", err)
+	}
+	var page HistoryPage
+	json.Unmarshal(got, &page)
+	for _, record := range page.Records {
+		if record.Actor != "" {
+			t.Fatalf("expected the history to leave out the actor, got %+v", record)
+		}
+	}
+}
+
+func TestWrappedKeysAreSalted(t *testing.T) {
+
+	// the wrapped keys can't be matched against the hashes of the enrollment IDs
+	stub := newConfidentialStub(t)
+	got, _ := query(stub, auditor, "readContract", "C7")
+	var sc SalesContractObject
+	json.Unmarshal(got, &sc)
+	if sc.Sealed.Salt == "" || len(sc.Sealed.WrappedKeys) != 3 {
+		t.Fatalf("expected the wrapped keys listed under a salt, got %+v", sc.Sealed)
+	}
+	for _, party := range []string{"lht", "dhl", "bosch"} {
+		if _, ok := sc.Sealed.WrappedKeys[partyHash("", party)]; ok {
+			t.Fatalf("expected the wrapped key of %s not to be listed by its plain hash", party)
+		}
+	}
+	// a contract sealed before salts keeps its keys listed by the plain hash
+	key, _ := getContractKey("C7")
+	sc.Sealed.Salt = ""
+	sc.Sealed.WrappedKeys = map[string]string{partyHash("", "lht"): wrappedKeys["lht"]}
+	stub.MockTransactionStart("legacy")
+	buff, _ := json.Marshal(sc)
+	stub.PutState(key, buff)
+	stub.MockTransactionEnd("legacy")
+	if got, err := query(stub, lht, "getWrappedKey", "C7"); err != nil || string(got) != wrappedKeys["lht"] {
+		t.Fatalf("getWrappedKey of a contract sealed without a salt: got %s, %v", got, err)
+	}
+}
+
+func TestConfidentialContractRejected(t *testing.T) {
+
+	stub := newStub(t)
+	badKey := base64.StdEncoding.EncodeToString([]byte("short"))
+	tests := []struct {
+		name    string
+		keys    ContractKeys
+		wantErr string
+	}{
+		{"key not wrapped for a party", ContractKeys{map[string]string{"C7": sealingKey}, map[string]string{"lht": "w", "bosch": "w"}}, "not wrapped for dhl"},
+		{"short key", ContractKeys{map[string]string{"C7": badKey}, wrappedKeys}, "should be 32 bytes"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withKeys(stub, bosch, test.keys)
+			_, err := stub.MockInvoke("tx-confidential", "initContract", []string{confidentialContract})
+			checkError(t, err, test.wantErr)
+		})
+	}
+	stub.MockCaller(nil, []byte("not json"), map[string]string{ATTR_USERNAME: "bosch", ATTR_ROLE: SELLER})
+	_, err := stub.MockInvoke("tx-confidential", "initContract", []string{confidentialContract})
+	checkError(t, err, "metadata should be a JSON object")
+	if ast := getAsset(t, stub, "1002"); ast.Contractid != "" {
+		t.Fatalf("expected asset 1002 to stay unlocked, got %+v", ast)
+	}
+
+	// a wrong key does not open the contract
+	stub = newConfidentialStub(t)
+	withKeys(stub, lht, ContractKeys{Keys: map[string]string{"C7": base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))}})
+	_, err = stub.MockQuery("readContract", []string{"C7"})
+	checkError(t, err, "wrong key for contract C7")
+}
+
+func TestSealingIsDeterministic(t *testing.T) {
+
+	// every validating peer writes the same ciphertext
+	var peers []*shim.MockStub
+	for i := 0; i < 2; i++ {
+		txCount = 0
+		peers = append(peers, newConfidentialStub(t))
+	}
+	key, _ := getContractKey("C7")
+	if first, second := peers[0].State[key], peers[1].State[key]; string(first) != string(second) {
+		t.Fatalf("expected the same record on both peers, got %s and %s", first, second)
+	}
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Peer chaincodes - the asset registry and the settlement of payments can each be deployed as a chaincode of
+//					   their own, this chaincode deployed under another name. Init takes the names of the peers,
+//					   e.g. assetRegistry=<name> settlement=<name>, and the contracts then read, lock and release
+//					   their assets through the registry and pay through the settlement with InvokeChaincode and
+//					   QueryChaincode. A part left unnamed stays in this chaincode. The registry is deployed with
+//					   serve=assetRegistry and answers readState, lockAsset and releaseAsset, the settlement is
+//					   deployed with serve=settlement and answers lockEscrow, settleEscrow and getAccount. A
+//					   chaincode not deployed to serve a part does not take the invokes of the peer of that part.
+//					   A peer runs in the transaction of the chaincode calling it and sees the certificate of its
+//					   caller, it can't tell which chaincode calls it. The registry therefore only lets the owner
+//					   of an asset lock it for a contract, and a party of that contract release it to the other
+//					   one: the seller to the buyer, the buyer back to the seller. The assets a party is to
+//					   receive wait until the other party hands them over with handOverAssets. The settlement
+//					   only lets the buyer lock its money in an escrow, and a party pay out of the escrow against
+//					   its own interest: the buyer to the seller and the transporter, the seller back to the
+//					   buyer. An arbiter pays out either way.
+//==============================================================================================================================
+const INIT_ASSET_REGISTRY = "assetRegistry"
+const INIT_SETTLEMENT = "settlement"
+const INIT_SERVE = "serve"
+
+// ChaincodeConfig struct - names of the peer chaincodes, empty for a part kept in this chaincode, and the parts
+// this chaincode serves to others
+type ChaincodeConfig struct {
+	AssetRegistry      string
+	Settlement         string
+	ServeAssetRegistry bool
+	ServeSettlement    bool
+}
+
+// InitRequest struct - the arguments of Init, a peer left nil is not changed
+type InitRequest struct {
+	AssetRegistry *string
+	Settlement    *string
+	Serve         *string // the parts served to other chaincodes, comma separated, e.g. assetRegistry,settlement
+	LogLevel      string
+}
+
+// AssetLock struct - the contract of a peer chaincode an asset of the registry is committed to
+type AssetLock struct {
+	Serialno   string
+	Contractid string
+	Seller     string
+	Buyer      string
+}
+
+// configure records the names of the peer chaincodes given to Init, the parts this chaincode serves, and sets
+// the log level. The peers are set once, an Init without them keeps them.
+// args: [assetRegistry=<name>] [settlement=<name>] [serve=assetRegistry,settlement] [logLevel=<level>] or
+// {"AssetRegistry":"<name>",...}
+func (t *SimpleChaincode) configure(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req InitRequest
+	if namedForm(args) {
+		if err := decodeRequest("Init", args, &req); err != nil {
+			return nil, err
+		}
+		args = nil
+	}
+	for _, arg := range args {
+		name, value := arg, ""
+		if i := strings.Index(arg, "="); i >= 0 {
+			name, value = arg[:i], arg[i+1:]
+		}
+		switch name {
+		case INIT_ASSET_REGISTRY:
+			req.AssetRegistry = &value
+		case INIT_SETTLEMENT:
+			req.Settlement = &value
+		case INIT_SERVE:
+			req.Serve = &value
+		case INIT_LOG_LEVEL:
+			req.LogLevel = value
+		default:
+			return nil, invalidArgument("", "Init() : unknown argument "+arg+". Expecting "+INIT_ASSET_REGISTRY+"=<name>, "+INIT_SETTLEMENT+"=<name>, "+INIT_SERVE+"=<parts> or "+INIT_LOG_LEVEL+"=<level>")
+		}
+	}
+	if req.LogLevel != "" {
+		if err := set_log_level(req.LogLevel); err != nil {
+			return nil, err
+		}
+		logFor(stub, "").info("log level set", "level", strings.ToUpper(req.LogLevel))
+	}
+	if req.AssetRegistry == nil && req.Settlement == nil && req.Serve == nil {
+		return nil, nil
+	}
+	var config ChaincodeConfig
+	if req.AssetRegistry != nil {
+		config.AssetRegistry = *req.AssetRegistry
+	}
+	if req.Settlement != nil {
+		config.Settlement = *req.Settlement
+	}
+	if req.Serve != nil {
+		for _, part := range strings.Split(*req.Serve, ",") {
+			switch part {
+			case INIT_ASSET_REGISTRY:
+				config.ServeAssetRegistry = true
+			case INIT_SETTLEMENT:
+				config.ServeSettlement = true
+			default:
+				return nil, invalidArgument("Serve", "Init() : unknown part "+part+". Expecting "+INIT_ASSET_REGISTRY+" or "+INIT_SETTLEMENT)
+			}
+		}
+	}
+	if config.ServeAssetRegistry && config.AssetRegistry != "" {
+		return nil, invalidArgument("Serve", "Init() : a chaincode serving the asset registry keeps its assets, it can't use the registry "+config.AssetRegistry)
+	}
+	if config.ServeSettlement && config.Settlement != "" {
+		return nil, invalidArgument("Serve", "Init() : a chaincode serving the settlement keeps its accounts, it can't use the settlement "+config.Settlement)
+	}
+
+	current, err := getConfig(stub)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Init()", err)
+	}
+	if current != (ChaincodeConfig{}) && current != config {
+		logFor(stub, "").debug("peer chaincodes are already set", "assetRegistry", current.AssetRegistry, "settlement", current.Settlement)
+		return nil, conflict("", "Init() : the peer chaincodes are already set")
+	}
+	configKey, err := getConfigKey()
+	if err != nil {
+		return nil, err
+	}
+	buff, err := json.Marshal(config)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Init() : Cannot create config record", err)
+	}
+	if err = stub.PutState(configKey, buff); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Init() : write error while inserting record", err)
+	}
+	logFor(stub, "").info("peer chaincodes set", "assetRegistry", config.AssetRegistry, "settlement", config.Settlement, "serveAssetRegistry", config.ServeAssetRegistry, "serveSettlement", config.ServeSettlement)
+	return nil, nil
+}
+
+// getConfig returns the names of the peer chaincodes, none are set for a chaincode holding every part
+func getConfig(stub shim.ChaincodeStubInterface) (ChaincodeConfig, error) {
+
+	var config ChaincodeConfig
+	configKey, err := getConfigKey()
+	if err != nil {
+		return config, err
+	}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, errors.New("Failed to get config")
+	}
+	if configAsBytes == nil {
+		return config, nil
+	}
+	if err = json.Unmarshal(configAsBytes, &config); err != nil {
+		return config, wrapError(ERR_INTERNAL, "invalid config record", err)
+	}
+	return config, nil
+}
+
+// run_peer_invoke - Runs an invoke a peer chaincode calls on a part this chaincode serves, a chaincode that
+//					 doesn't serve the part doesn't know the function
+func (t *SimpleChaincode) run_peer_invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, function+"()", err)
+	}
+	switch {
+	case function == "lockAsset" && config.ServeAssetRegistry:
+		return t.lockAsset(stub, args)
+	case function == "releaseAsset" && config.ServeAssetRegistry:
+		return t.releaseAsset(stub, args)
+	case function == "lockEscrow" && config.ServeSettlement:
+		return t.lockEscrow(stub, args)
+	case function == "settleEscrow" && config.ServeSettlement:
+		return t.settleEscrow(stub, args)
+	}
+	return nil, unknownFunction("Received unknown function invocation: " + function + ", this chaincode does not serve it")
+}
+
+// peerArgs returns the arguments of a call to a peer chaincode
+func peerArgs(function string, args ...string) [][]byte {
+
+	buff := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		buff = append(buff, []byte(arg))
+	}
+	return buff
+}
+
+//	 Asset registry
+
+// LockAssetRequest struct - the arguments of lockAsset
+type LockAssetRequest struct {
+	Serialno   string `validate:"required"`
+	Contractid string `validate:"required"`
+	Seller     string `validate:"required"`
+	Buyer      string `validate:"required"`
+}
+
+// ReleaseAssetRequest struct - the arguments of releaseAsset
+type ReleaseAssetRequest struct {
+	Serialno   string `validate:"required"`
+	Contractid string `validate:"required"`
+	Owner      string `validate:"required"`
+}
+
+// lockAsset commits an asset of the seller to a contract of a peer chaincode between the seller and a buyer and
+// returns the asset. Only the seller, the owner of the asset, locks it.
+// args: serialno, contractid, seller, buyer
+func (t *SimpleChaincode) lockAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req LockAssetRequest
+	if err := decodeRequest("lockAsset", args, &req); err != nil {
+		return nil, err
+	}
+	if err := t.check_caller(stub, req.Seller, SELLER); err != nil {
+		logFor(stub, req.Serialno).debug("caller may not lock the asset", "reason", err)
+		return nil, permissionDenied("lockAsset")
+	}
+	asset, err := getAssetObject(stub, req.Serialno)
+	if err != nil {
+		return nil, err
+	}
+	if err = check_lockable(asset, req.Seller); err != nil {
+		return nil, err
+	}
+	asset.Contractid = req.Contractid
+	if _, err = t.save_asset(stub, asset); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "lockAsset() : write error while locking asset", err)
+	}
+	if err = save_asset_lock(stub, AssetLock{asset.Serialno, req.Contractid, req.Seller, req.Buyer}); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "lockAsset() : write error while locking asset", err)
+	}
+	logFor(stub, asset.Serialno).info("asset locked", "contract", asset.Contractid, "buyer", req.Buyer)
+	return ARtoJSON(asset)
+}
+
+// releaseAsset releases the lock a contract of a peer chaincode holds on an asset and hands it to the seller or
+// the buyer of the contract, against the interest of the caller: the seller to the buyer, the buyer back to the
+// seller. An arbiter hands it to either. args: serialno, contractid, owner
+func (t *SimpleChaincode) releaseAsset(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ReleaseAssetRequest
+	if err := decodeRequest("releaseAsset", args, &req); err != nil {
+		return nil, err
+	}
+	lock, err := getAssetLock(stub, req.Serialno)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "releaseAsset()", err)
+	}
+	if lock.Contractid != req.Contractid {
+		return nil, conflict(req.Serialno, "releaseAsset() : asset "+req.Serialno+" is not locked by contract "+req.Contractid)
+	}
+	if req.Owner != lock.Seller && req.Owner != lock.Buyer {
+		return nil, invalidArgument("Owner", "releaseAsset() : Owner should be the seller or the buyer of contract "+lock.Contractid)
+	}
+	if err = t.check_release_caller(stub, lock.Seller, lock.Buyer, req.Owner); err != nil {
+		logFor(stub, req.Serialno).debug("caller may not release the asset", "contract", lock.Contractid, "reason", err)
+		return nil, permissionDenied("releaseAsset")
+	}
+	if _, err = t.unlock_asset(stub, req.Contractid, req.Serialno, req.Owner); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "releaseAsset()", err)
+	}
+	lockKey, err := createCompositeKey(ASSET_LOCK_OBJECT, []string{req.Serialno})
+	if err != nil {
+		return nil, err
+	}
+	if err = stub.DelState(lockKey); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "releaseAsset() : write error while releasing asset", err)
+	}
+	logFor(stub, req.Serialno).info("asset released", "contract", req.Contractid, "owner", req.Owner)
+	return nil, nil
+}
+
+// check_release_caller - Verifies that the caller hands an asset over against its own interest: the seller to
+//						  the buyer, the buyer back to the seller. An arbiter hands it over either way.
+func (t *SimpleChaincode) check_release_caller(stub shim.ChaincodeStubInterface, seller string, buyer string, owner string) error {
+
+	if _, err := t.check_role(stub, ARBITER); err == nil {
+		return nil
+	}
+	if owner == buyer && t.check_caller(stub, seller, SELLER) == nil {
+		return nil
+	}
+	if owner == seller && t.check_caller(stub, buyer, BUYER) == nil {
+		return nil
+	}
+	return errors.New("the caller can only hand the asset over to the other party of the contract")
+}
+
+// getAssetLock returns the contract an asset of the registry is locked by. An asset locked without a record,
+// or not locked, is taken to be locked by its owner alone.
+func getAssetLock(stub shim.ChaincodeStubInterface, serialNo string) (AssetLock, error) {
+
+	asset, err := getAssetObject(stub, serialNo)
+	if err != nil {
+		return AssetLock{}, err
+	}
+	lock := AssetLock{Serialno: serialNo, Contractid: asset.Contractid, Seller: asset.Owner}
+	lockKey, err := createCompositeKey(ASSET_LOCK_OBJECT, []string{serialNo})
+	if err != nil {
+		return lock, err
+	}
+	lockAsBytes, err := stub.GetState(lockKey)
+	if err != nil {
+		return lock, errors.New("Failed to get asset lock")
+	}
+	if lockAsBytes == nil {
+		return lock, nil
+	}
+	if err = json.Unmarshal(lockAsBytes, &lock); err != nil {
+		return lock, wrapError(ERR_INTERNAL, "invalid asset lock record", err)
+	}
+	return lock, nil
+}
+
+// save_asset_lock - Writes the contract an asset of the registry is locked by
+func save_asset_lock(stub shim.ChaincodeStubInterface, lock AssetLock) error {
+
+	lockKey, err := createCompositeKey(ASSET_LOCK_OBJECT, []string{lock.Serialno})
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(lockKey, buff)
+}
+
+// check_lockable - Verifies that an asset belongs to the seller, may be sold and is not committed to a contract
+func check_lockable(asset AssetObject, seller string) error {
+
+	if asset.Owner != seller {
+		return conflict(asset.Serialno, "asset "+asset.Serialno+" is not owned by "+seller)
+	}
+	if ASSET_UNSALEABLE[asset.Status] {
+		return conflict(asset.Serialno, "asset "+asset.Serialno+" is "+asset.Status)
+	}
+	if asset.Contractid != "" {
+		return conflict(asset.Serialno, "asset "+asset.Serialno+" is locked by contract "+asset.Contractid)
+	}
+	return nil
+}
+
+// get_registry_asset - Reads an asset from the asset registry
+func get_registry_asset(stub shim.ChaincodeStubInterface, serialNo string) (AssetObject, error) {
+
+	var ast AssetObject
+	config, err := getConfig(stub)
+	if err != nil {
+		return ast, err
+	}
+	if config.AssetRegistry == "" {
+		return getAssetObject(stub, serialNo)
+	}
+	logFor(stub, serialNo).debug("querying the asset registry", "chaincode", config.AssetRegistry)
+	assetAsBytes, err := stub.QueryChaincode(config.AssetRegistry, peerArgs("readState", serialNo))
+	if err != nil {
+		return ast, wrapError(ERR_INTERNAL, "Failed to get asset from "+config.AssetRegistry, err)
+	}
+	if assetAsBytes == nil {
+		return ast, notFound(serialNo, "no asset for "+serialNo)
+	}
+	if err = json.Unmarshal(assetAsBytes, &ast); err != nil {
+		return ast, wrapError(ERR_INTERNAL, "Failed to convert to object", err)
+	}
+	return ast, nil
+}
+
+// get_registry_asset_status - Reads the lifecycle status of an asset from the asset registry
+func get_registry_asset_status(stub shim.ChaincodeStubInterface, serialNo string) (AssetStatus, error) {
+
+	var status AssetStatus
+	config, err := getConfig(stub)
+	if err != nil {
+		return status, err
+	}
+	if config.AssetRegistry == "" {
+		ast, err := getAssetObject(stub, serialNo)
+		return AssetStatus{ast.Serialno, ast.Status, ast.RecallID}, err
+	}
+	statusAsBytes, err := stub.QueryChaincode(config.AssetRegistry, peerArgs("getAssetStatus", serialNo))
+	if err != nil {
+		return status, wrapError(ERR_INTERNAL, "Failed to get asset status from "+config.AssetRegistry, err)
+	}
+	if err = json.Unmarshal(statusAsBytes, &status); err != nil {
+		return status, wrapError(ERR_INTERNAL, "Failed to convert to object", err)
+	}
+	return status, nil
+}
+
+// lock_registry_asset - Commits an asset of the seller to a contract with the buyer in the asset registry
+func (t *SimpleChaincode) lock_registry_asset(stub shim.ChaincodeStubInterface, asset AssetObject, contractID string, seller string, buyer string) error {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.AssetRegistry == "" {
+		asset.Contractid = contractID
+		_, err = t.save_asset(stub, asset)
+		return err
+	}
+	logFor(stub, asset.Serialno).debug("locking the asset in the registry", "chaincode", config.AssetRegistry, "contract", contractID)
+	if _, err = stub.InvokeChaincode(config.AssetRegistry, peerArgs("lockAsset", asset.Serialno, contractID, seller, buyer)); err != nil {
+		return wrapError(ERR_INTERNAL, config.AssetRegistry, err)
+	}
+	return nil
+}
+
+// unlock_registry_asset - Releases the lock of a contract on an asset in the asset registry and hands the
+//						   asset to owner
+func (t *SimpleChaincode) unlock_registry_asset(stub shim.ChaincodeStubInterface, contractID string, assetID string, owner string) error {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.AssetRegistry == "" {
+		_, err = t.unlock_asset(stub, contractID, assetID, owner)
+		return err
+	}
+	logFor(stub, assetID).debug("releasing the asset in the registry", "chaincode", config.AssetRegistry, "contract", contractID)
+	if _, err = stub.InvokeChaincode(config.AssetRegistry, peerArgs("releaseAsset", assetID, contractID, owner)); err != nil {
+		return wrapError(ERR_INTERNAL, config.AssetRegistry, err)
+	}
+	return nil
+}
+
+// hand_over_line - Releases the assets of a line item in the asset registry to owner. The registry only takes
+//					a release against the interest of the caller, a line the caller is to receive waits for the
+//					other party to hand it over with handOverAssets.
+func (t *SimpleChaincode) hand_over_line(stub shim.ChaincodeStubInterface, sc SalesContractObject, line *LineItem, owner string) error {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.AssetRegistry != "" && len(line.AssetIDs) > 0 && t.check_release_caller(stub, sc.Seller, sc.Buyer, owner) != nil {
+		logFor(stub, sc.Contractid).debug("line waits to be handed over", "line", line.LineNo, "owner", owner)
+		line.HandOverTo = owner
+		return nil
+	}
+	for _, assetID := range line.AssetIDs {
+		if err = t.unlock_registry_asset(stub, sc.Contractid, assetID, owner); err != nil {
+			return err
+		}
+	}
+	line.HandOverTo = ""
+	return nil
+}
+
+// handOverAssets hands the assets of the settled line items of a contract waiting for the caller over in the
+// asset registry: the seller hands the lines delivered to the buyer, the buyer those given back to the seller.
+// args: contractid
+func (t *SimpleChaincode) handOverAssets(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("handOverAssets", args, &req); err != nil {
+		return nil, err
+	}
+	sc, err := getContractObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if err = check_open(sc); err != nil {
+		return nil, err
+	}
+	if t.check_caller(stub, sc.Seller, SELLER) != nil && t.check_caller(stub, sc.Buyer, BUYER) != nil {
+		if _, err = t.check_role(stub, ARBITER); err != nil {
+			logFor(stub, sc.Contractid).debug("caller may not hand over assets", "reason", err)
+			return nil, permissionDenied("handOverAssets")
+		}
+	}
+
+	before := sc
+	sc.LineItems = append([]LineItem{}, sc.LineItems...)
+	var lineNos []int
+	for i := range sc.LineItems {
+		line := &sc.LineItems[i]
+		if line.HandOverTo == "" || t.check_release_caller(stub, sc.Seller, sc.Buyer, line.HandOverTo) != nil {
+			continue
+		}
+		if err = t.hand_over_line(stub, sc, line, line.HandOverTo); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "handOverAssets()", err)
+		}
+		lineNos = append(lineNos, line.LineNo)
+	}
+	if len(lineNos) == 0 {
+		return nil, conflict(sc.Contractid, "handOverAssets() : contract "+sc.Contractid+" has no assets for the caller to hand over")
+	}
+	if _, err = t.save_changes(stub, &sc); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error saving changes", err)
+	}
+	if err = emitContractEvent(stub, EVENT_CONTRACT_UPDATED, &before, sc); err != nil {
+		return nil, err
+	}
+	logFor(stub, sc.Contractid).info("assets handed over", "lines", lineNos)
+	return nil, nil
+}
+
+//	 Settlement
+
+// LockEscrowRequest struct - the arguments of lockEscrow
+type LockEscrowRequest struct {
+	Contractid  string `validate:"required"`
+	Buyer       string `validate:"required"`
+	Seller      string `validate:"required"`
+	Transporter string `validate:"required"`
+	Currency    string `validate:"required,pattern=currency"`
+	Amount      int64  `validate:"required,min=1"` // in the minor unit of the currency
+}
+
+// SettleEscrowRequest struct - the arguments of settleEscrow
+type SettleEscrowRequest struct {
+	Contractid string `validate:"required"`
+	Released   int64  `validate:"required,min=0"` // to the seller
+	Fee        int64  `validate:"required,min=0"` // to the transporter
+	Refund     int64  `validate:"required,min=0"` // to the buyer
+}
+
+// lockEscrow moves the price and fee of a contract of a peer chaincode from the account of the buyer into
+// the escrow of the contract. The caller must be the buyer.
+// args: contractid, buyer, seller, transporter, currency, amount in the minor unit of the currency
+func (t *SimpleChaincode) lockEscrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req LockEscrowRequest
+	if err := decodeRequest("lockEscrow", args, &req); err != nil {
+		return nil, err
+	}
+	escrow := EscrowObject{Contractid: req.Contractid, Buyer: req.Buyer, Seller: req.Seller, Transporter: req.Transporter, Currency: req.Currency, Locked: req.Amount, Status: ESCROW_HELD}
+	if err := t.check_caller(stub, escrow.Buyer, BUYER); err != nil {
+		logFor(stub, escrow.Contractid).debug("caller may not lock the escrow", "reason", err)
+		return nil, permissionDenied("lockEscrow")
+	}
+	if err := lock_escrow(stub, escrow); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "lockEscrow()", err)
+	}
+	return nil, nil
+}
+
+// settleEscrow pays out of the escrow of a contract of a peer chaincode. The buyer pays the seller and the
+// transporter, the seller refunds the buyer, an arbiter pays either way.
+// args: contractid, released to the seller, fee to the transporter, refund to the buyer
+func (t *SimpleChaincode) settleEscrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req SettleEscrowRequest
+	if err := decodeRequest("settleEscrow", args, &req); err != nil {
+		return nil, err
+	}
+	escrow, err := getEscrowObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "settleEscrow()", err)
+	}
+	if escrow == nil {
+		return nil, notFound(req.Contractid, "settleEscrow() : no escrow for "+req.Contractid)
+	}
+	if err = t.check_settle_caller(stub, *escrow, req); err != nil {
+		logFor(stub, escrow.Contractid).debug("caller may not settle the escrow", "reason", err)
+		return nil, permissionDenied("settleEscrow")
+	}
+	if err = settle_escrow(stub, escrow.Contractid, req.Released, req.Fee, req.Refund); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "settleEscrow()", err)
+	}
+	return nil, nil
+}
+
+// check_settle_caller - Verifies that the caller pays out of the escrow against its own interest: the buyer to
+//						 the seller and the transporter, the seller to the buyer. An arbiter pays either way.
+func (t *SimpleChaincode) check_settle_caller(stub shim.ChaincodeStubInterface, escrow EscrowObject, req SettleEscrowRequest) error {
+
+	if _, err := t.check_role(stub, ARBITER); err == nil {
+		return nil
+	}
+	if t.check_caller(stub, escrow.Buyer, BUYER) == nil {
+		if req.Refund != 0 {
+			return errors.New("the buyer can't refund itself")
+		}
+		return nil
+	}
+	if t.check_caller(stub, escrow.Seller, SELLER) == nil {
+		if req.Released != 0 || req.Fee != 0 {
+			return errors.New("the seller can only refund the buyer")
+		}
+		return nil
+	}
+	return errors.New("the caller is neither the buyer nor the seller of the escrow")
+}
+
+// lock_contract_escrow - Locks the escrow of a contract through the settlement
+func lock_contract_escrow(stub shim.ChaincodeStubInterface, escrow EscrowObject) error {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.Settlement == "" || escrow.Locked == 0 {
+		return lock_escrow(stub, escrow)
+	}
+	args := peerArgs("lockEscrow", escrow.Contractid, escrow.Buyer, escrow.Seller, escrow.Transporter, escrow.Currency, strconv.FormatInt(escrow.Locked, 10))
+	logFor(stub, escrow.Contractid).debug("locking the escrow in the settlement", "chaincode", config.Settlement)
+	if _, err = stub.InvokeChaincode(config.Settlement, args); err != nil {
+		return wrapError(ERR_INTERNAL, config.Settlement, err)
+	}
+	return nil
+}
+
+// settle_contract_escrow - Pays out of the escrow of a contract through the settlement
+func settle_contract_escrow(stub shim.ChaincodeStubInterface, contractID string, released int64, fee int64, refund int64) error {
+
+	config, err := getConfig(stub)
+	if err != nil {
+		return err
+	}
+	if config.Settlement == "" || released+fee+refund == 0 {
+		return settle_escrow(stub, contractID, released, fee, refund)
+	}
+	args := peerArgs("settleEscrow", contractID, strconv.FormatInt(released, 10), strconv.FormatInt(fee, 10), strconv.FormatInt(refund, 10))
+	logFor(stub, contractID).debug("settling the escrow in the settlement", "chaincode", config.Settlement, "released", released, "fee", fee, "refund", refund)
+	if _, err = stub.InvokeChaincode(config.Settlement, args); err != nil {
+		return wrapError(ERR_INTERNAL, config.Settlement, err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newPeerStubs returns a contract chaincode using the asset registry and the settlement chaincodes returned
+// with it. The registry holds assets 3001 and 3002 of bosch, the settlement 10000 EUR of lht.
+func newPeerStubs(t *testing.T) (*shim.MockStub, *shim.MockStub, *shim.MockStub) {
+
+	registry := newCatalogStub(t, "registry")
+	if _, err := registry.MockInit("deploy", "init", []string{"serve=assetRegistry"}); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	mustInvoke(t, registry, bosch, "initAssset", "3001", "LHTMO", "bosch")
+	mustInvoke(t, registry, bosch, "initAssset", "3002", "A320", "bosch")
+
+	settlement := shim.NewMockStub("settlement", new(SimpleChaincode))
+	settlement.MockTxTimestamp(testTime)
+	if _, err := settlement.MockInit("deploy", "init", []string{"serve=settlement"}); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	mustInvoke(t, settlement, bank, "depositFunds", "lht", "EUR", "10000")
+
+	contracts := shim.NewMockStub("contracts", new(SimpleChaincode))
+	contracts.MockTxTimestamp(testTime)
+	if _, err := contracts.MockInit("deploy", "init", []string{"assetRegistry=registry", "settlement=settlement"}); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	contracts.MockPeerChaincode("registry", registry)
+	contracts.MockPeerChaincode("settlement", settlement)
+	return contracts, registry, settlement
+}
+
+const peerContract = `{"Contractid":"C3","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"EUR",
+	"LineItems":[{"AssetIDs":["3001"],"UnitPrice":6000},{"AssetIDs":["3002"],"UnitPrice":3000}],"TransporterFee":500}`
+
+func TestPeerChaincodeDelivery(t *testing.T) {
+
+	contracts, registry, settlement := newPeerStubs(t)
+	mustInvoke(t, contracts, bosch, "initContract", peerContract)
+	mustInvoke(t, contracts, lht, "fundEscrow", "C3")
+
+	for _, serialNo := range []string{"3001", "3002"} {
+		if ast := getAsset(t, registry, serialNo); ast.Owner != "bosch" || ast.Contractid != "C3" {
+			t.Fatalf("expected asset %s to be locked by C3 in the registry, got %+v", serialNo, ast)
+		}
+	}
+	if got, _ := query(contracts, lht, "readState", "3001"); got != nil {
+		t.Fatalf("expected no asset in the contract chaincode, got %s", got)
+	}
+	checkBalances(t, settlement, map[string]int64{"lht": 500})
+	if escrow := getEscrowStatement(t, settlement, "C3").Escrow; escrow.Locked != 9500 || escrow.Seller != "bosch" || escrow.Transporter != "dhl" {
+		t.Fatalf("unexpected escrow %+v", escrow)
+	}
+
+	mustInvoke(t, contracts, bosch, "readyForShipment", "C3", "D1")
+	mustInvoke(t, contracts, dhl, "inTransit", "C3")
+	mustInvoke(t, contracts, lht, "deliverLineItems", "C3", "[2]")
+
+	// the registry only lets the seller hand the assets the buyer received over
+	if ast := getAsset(t, registry, "3002"); ast.Owner != "bosch" || ast.Contractid != "C3" {
+		t.Fatalf("expected asset 3002 to wait for bosch, got %+v", ast)
+	}
+	if line := getContract(t, contracts, "C3").LineItems[1]; line.HandOverTo != "lht" || !line.Delivered {
+		t.Fatalf("expected line 2 to wait to be handed to lht, got %+v", line)
+	}
+	_, err := invoke(contracts, lht, "handOverAssets", "C3")
+	checkCode(t, err, ERR_CONFLICT, "", "C3")
+	_, err = invoke(contracts, dhl, "handOverAssets", "C3")
+	checkError(t, err, "Permission Denied")
+	mustInvoke(t, contracts, bosch, "handOverAssets", "C3")
+	if ast := getAsset(t, registry, "3002"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("expected asset 3002 to be handed to lht, got %+v", ast)
+	}
+	if line := getContract(t, contracts, "C3").LineItems[1]; line.HandOverTo != "" {
+		t.Fatalf("expected line 2 to be handed over, got %+v", line)
+	}
+
+	mustInvoke(t, contracts, dhl, "shipmentReached", "C3")
+	mustInvoke(t, contracts, lht, "shipmentDelivered", "C3")
+	mustInvoke(t, contracts, bosch, "handOverAssets", "C3")
+
+	if ast := getAsset(t, registry, "3001"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("expected asset 3001 to be handed to lht, got %+v", ast)
+	}
+	checkBalances(t, settlement, map[string]int64{"lht": 500, "bosch": 9000, "dhl": 500})
+	if escrow := getEscrowStatement(t, settlement, "C3").Escrow; escrow.Status != ESCROW_SETTLED {
+		t.Fatalf("unexpected escrow %+v", escrow)
+	}
+	if sc := getContract(t, contracts, "C3"); sc.Stage != STATE_SHIPMENT_DELIVERED || !sc.delivered() {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+}
+
+func TestPeerChaincodeCancellation(t *testing.T) {
+
+	contracts, registry, settlement := newPeerStubs(t)
+	mustInvoke(t, contracts, bosch, "initContract", peerContract)
+	mustInvoke(t, contracts, lht, "fundEscrow", "C3")
+	mustInvoke(t, contracts, bosch, "cancelContract", "C3", "out of stock", "")
+
+	// the buyer hands the assets back to the seller
+	if ast := getAsset(t, registry, "3001"); ast.Contractid != "C3" {
+		t.Fatalf("expected asset 3001 to wait for lht, got %+v", ast)
+	}
+	_, err := invoke(contracts, bosch, "handOverAssets", "C3")
+	checkCode(t, err, ERR_CONFLICT, "", "C3")
+	mustInvoke(t, contracts, lht, "handOverAssets", "C3")
+	for _, serialNo := range []string{"3001", "3002"} {
+		if ast := getAsset(t, registry, serialNo); ast.Owner != "bosch" || ast.Contractid != "" {
+			t.Fatalf("expected asset %s to stay with bosch, got %+v", serialNo, ast)
+		}
+	}
+	checkBalances(t, settlement, map[string]int64{"lht": 10000, "bosch": 0, "dhl": 0})
+}
+
+func TestRegistryLocks(t *testing.T) {
+
+	contracts, registry, _ := newPeerStubs(t)
+	_, err := invoke(contracts, bosch, "lockAsset", "3001", "C9", "bosch", "lht")
+	checkError(t, err, "unknown function")
+
+	// only the owner locks an asset
+	_, err = invoke(registry, continental, "lockAsset", "3001", "C9", "bosch", "lht")
+	checkError(t, err, "Permission Denied")
+	_, err = invoke(registry, continental, "lockAsset", "3001", "C9", "continental", "lht")
+	checkError(t, err, "not owned by continental")
+
+	// a party of the contract only releases an asset to the other one, an arbiter to either
+	mustInvoke(t, contracts, bosch, "initContract", peerContract)
+	_, err = invoke(registry, lht, "releaseAsset", "3001", "C9", "lht")
+	checkError(t, err, "not locked by contract C9")
+	for _, c := range []caller{dhl, continental} {
+		_, err = invoke(registry, c, "releaseAsset", "3001", "C3", "lht")
+		checkError(t, err, "Permission Denied")
+	}
+	_, err = invoke(registry, lht, "releaseAsset", "3001", "C3", "dhl")
+	checkError(t, err, "Owner should be the seller or the buyer of contract C3")
+	_, err = invoke(registry, lht, "releaseAsset", "3001", "C3", "lht")
+	checkError(t, err, "Permission Denied")
+	_, err = invoke(registry, bosch, "releaseAsset", "3001", "C3", "bosch")
+	checkError(t, err, "Permission Denied")
+	mustInvoke(t, registry, lht, "releaseAsset", "3001", "C3", "bosch")
+	if ast := getAsset(t, registry, "3001"); ast.Owner != "bosch" || ast.Contractid != "" {
+		t.Fatalf("expected asset 3001 to be released to bosch, got %+v", ast)
+	}
+	_, err = invoke(registry, lht, "releaseAsset", "3001", "C3", "bosch")
+	checkError(t, err, "not locked by contract C3")
+	mustInvoke(t, registry, arbiter, "releaseAsset", "3002", "C3", "lht")
+	if ast := getAsset(t, registry, "3002"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("expected asset 3002 to be released to lht, got %+v", ast)
+	}
+
+	// a registry keeps its assets
+	_, err = registry.MockInit("redeploy", "init", []string{"serve=assetRegistry", "assetRegistry=other"})
+	checkError(t, err, "can't use the registry other")
+	_, err = registry.MockInit("redeploy", "init", []string{"serve=everything"})
+	checkError(t, err, "unknown part everything")
+}
+
+func TestPeerChaincodeRejected(t *testing.T) {
+
+	contracts, registry, settlement := newPeerStubs(t)
+	mustInvoke(t, registry, admin, "initAssset", "3003", "LHTMO", "continental")
+
+	tests := []struct {
+		name     string
+		contract string
+		wantErr  string
+	}{
+		{"unknown asset", `{"Contractid":"C4","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["3009"]}]}`, "no asset for 3009"},
+		{"not the seller's", `{"Contractid":"C4","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["3003"]}]}`, "registry : Permission Denied. readState"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(contracts, bosch, "initContract", test.contract)
+			checkError(t, err, test.wantErr)
+		})
+	}
+	if ast := getAsset(t, registry, "3001"); ast.Contractid != "" {
+		t.Fatalf("expected asset 3001 to stay unlocked, got %+v", ast)
+	}
+	checkBalances(t, settlement, map[string]int64{"lht": 10000})
+
+	// the settlement only lets the buyer lock its money, and a party pay out against its own interest
+	mustInvoke(t, contracts, bosch, "initContract", peerContract)
+	mustInvoke(t, contracts, lht, "fundEscrow", "C3")
+	_, err := invoke(contracts, lht, "lockEscrow", "C5", "lht", "bosch", "dhl", "EUR", "100")
+	checkError(t, err, "unknown function")
+	for _, c := range []caller{anonymous, bosch, dhl} {
+		_, err = invoke(settlement, c, "lockEscrow", "C5", "lht", "bosch", "dhl", "EUR", "100")
+		checkError(t, err, "Permission Denied")
+	}
+	_, err = invoke(settlement, lht, "lockEscrow", "C3", "lht", "bosch", "dhl", "EUR", "100")
+	checkError(t, err, "escrow of C3 already exists")
+	payouts := []struct {
+		caller  caller
+		args    []string
+		wantErr string
+	}{
+		{continental, []string{"C3", "9000", "0", "0"}, "Permission Denied"},
+		{dhl, []string{"C3", "0", "500", "0"}, "Permission Denied"},
+		{bosch, []string{"C3", "9000", "0", "0"}, "Permission Denied"},
+		{lht, []string{"C3", "0", "0", "9500"}, "Permission Denied"},
+		{bosch, []string{"C3", "0", "0", "100"}, ""},
+		{arbiter, []string{"C3", "0", "0", "100"}, ""},
+	}
+	for _, payout := range payouts {
+		_, err = invoke(settlement, payout.caller, "settleEscrow", payout.args...)
+		checkError(t, err, payout.wantErr)
+	}
+	checkBalances(t, settlement, map[string]int64{"lht": 700, "bosch": 0})
+
+	// a peer that is not deployed
+	unlinked := shim.NewMockStub("contracts", new(SimpleChaincode))
+	unlinked.MockTxTimestamp(testTime)
+	unlinked.MockInit("deploy", "init", []string{"assetRegistry=registry"})
+	_, err = invoke(unlinked, bosch, "initContract", "C4", "0", "lht", "dhl", "bosch", "3001", "D1", "")
+	checkError(t, err, "Could not find peer chaincode")
+}
+
+func TestInitPeerChaincodes(t *testing.T) {
+
+	contracts, _, _ := newPeerStubs(t)
+	if _, err := contracts.MockInit("redeploy", "init", nil); err != nil {
+		t.Fatalf("expected an Init without arguments to keep the peers, got %s", err)
+	}
+	_, err := invoke(contracts, arbiter, "init", "assetRegistry=other")
+	checkError(t, err, "Permission Denied")
+	_, err = invoke(contracts, admin, "init", "assetRegistry=other")
+	checkError(t, err, "already set")
+	_, err = invoke(contracts, admin, "init", "registry=other")
+	checkError(t, err, "unknown argument registry=other")
+	if config, _ := getConfig(contracts); config != (ChaincodeConfig{AssetRegistry: "registry", Settlement: "settlement"}) {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Documents - the parties of a contract attach the documents of a shipment to it. The ledger keeps the SHA-256
+//				 hash of each document, never its content, along with who attached it, when and in which stage of
+//				 the contract. Attachments are never overwritten, so every bill of lading or invoice a contract went
+//				 through stays on record, and a copy held off the ledger can be checked with verifyDocument.
+//==============================================================================================================================
+const DOC_INVOICE = "invoice"
+const DOC_BILL_OF_LADING = "billOfLading"
+const DOC_PROOF_OF_DELIVERY = "proofOfDelivery"
+
+var DOCUMENT_TYPES = map[string]bool{DOC_INVOICE: true, DOC_BILL_OF_LADING: true, DOC_PROOF_OF_DELIVERY: true}
+
+var sha256Hex = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+
+// DocumentRecord struct
+type DocumentRecord struct {
+	Contractid   string
+	DocumentID   string
+	DocType      string
+	Hash         string // SHA-256 of the content, lower case hex
+	Uploader     string
+	UploaderRole string
+	Stage        int    // stage of the contract when the document was attached
+	TimeStamp    string // transaction time in RFC 3339
+	TxID         string
+}
+
+// DocumentVerification struct - the response of verifyDocument
+type DocumentVerification struct {
+	Contractid string
+	DocumentID string
+	Hash       string // the hash checked
+	Verified   bool   // whether the hash is the one on the ledger
+	Document   DocumentRecord
+}
+
+// AttachDocumentRequest struct - the arguments of attachDocument
+type AttachDocumentRequest struct {
+	Contractid string `validate:"required"`
+	DocumentID string `validate:"required"`
+	DocType    string `validate:"required,enum=docType"`
+	Hash       string `validate:"required,pattern=sha256"`
+}
+
+// VerifyDocumentRequest struct - the arguments of verifyDocument
+type VerifyDocumentRequest struct {
+	Contractid string `validate:"required"`
+	DocumentID string `validate:"required"`
+	Hash       string `validate:"required,pattern=sha256"`
+}
+
+// attachDocument records a document of a contract. Only the parties of the contract attach documents.
+// args: contractid, documentID, docType (invoice|billOfLading|proofOfDelivery), SHA-256 hash as hex
+func (t *SimpleChaincode) attachDocument(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req AttachDocumentRequest
+	if err := decodeRequest("attachDocument", args, &req); err != nil {
+		return nil, err
+	}
+	documentID := req.DocumentID
+	docType := req.DocType
+	hash := strings.ToLower(req.Hash)
+
+	sc, err := getContractObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	role, err := t.check_party(stub, sc)
+	if err != nil {
+		logFor(stub, sc.Contractid).debug("caller may not attach documents", "reason", err)
+		return nil, permissionDenied("attachDocument")
+	}
+
+	documentKey, err := getDocumentKey(sc.Contractid, documentID)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "attachDocument()", err)
+	}
+	existing, err := stub.GetState(documentKey)
+	if err != nil {
+		return nil, errors.New("Failed to get document")
+	}
+	if existing != nil {
+		return nil, alreadyExists(documentID, "document "+documentID+" is already attached to "+sc.Contractid)
+	}
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	doc := DocumentRecord{sc.Contractid, documentID, docType, hash, getActor(stub), role, sc.Stage, txTime.Format(TIME_FORMAT), stub.GetTxID()}
+	buff, err := json.Marshal(doc)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "attachDocument() : Cannot create document record", err)
+	}
+	if err = stub.PutState(documentKey, buff); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "attachDocument() : write error while inserting record", err)
+	}
+	if err = emitDocumentEvent(stub, doc); err != nil {
+		return nil, err
+	}
+	logFor(stub, sc.Contractid).info("document attached", "document", documentID, "docType", docType, "role", role)
+	return nil, nil
+}
+
+// listDocuments returns the documents attached to a contract, oldest first. args: contractid
+func (t *SimpleChaincode) listDocuments(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("listDocuments", args, &req); err != nil {
+		return nil, err
+	}
+	if _, err := t.check_contract_reader(stub, "listDocuments", req.Contractid); err != nil {
+		return nil, err
+	}
+	startKey, endKey, err := compositeKeyRange(DOCUMENT_OBJECT, []string{req.Contractid})
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listDocuments()", err)
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listDocuments() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	docs := []DocumentRecord{}
+	for keysIter.HasNext() {
+		_, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "listDocuments() : Error accessing state", iterErr)
+		}
+		var doc DocumentRecord
+		if err = json.Unmarshal(value, &doc); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "listDocuments() : invalid document record", err)
+		}
+		docs = append(docs, doc)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		if docs[i].TimeStamp != docs[j].TimeStamp {
+			return docs[i].TimeStamp < docs[j].TimeStamp
+		}
+		return docs[i].DocumentID < docs[j].DocumentID
+	})
+	return json.Marshal(docs)
+}
+
+// verifyDocument checks the SHA-256 hash of a copy of a document against the hash attached to the contract.
+// A hash that doesn't match is not an error, the response tells whether it was verified.
+// args: contractid, documentID, SHA-256 hash as hex
+func (t *SimpleChaincode) verifyDocument(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req VerifyDocumentRequest
+	if err := decodeRequest("verifyDocument", args, &req); err != nil {
+		return nil, err
+	}
+	hash := strings.ToLower(req.Hash)
+	if _, err := t.check_contract_reader(stub, "verifyDocument", req.Contractid); err != nil {
+		return nil, err
+	}
+	documentKey, err := getDocumentKey(req.Contractid, req.DocumentID)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "verifyDocument()", err)
+	}
+	docAsBytes, err := stub.GetState(documentKey)
+	if err != nil {
+		return nil, errors.New("Failed to get document")
+	}
+	if docAsBytes == nil {
+		return nil, notFound(req.DocumentID, "no document "+req.DocumentID+" attached to "+req.Contractid)
+	}
+	var doc DocumentRecord
+	if err = json.Unmarshal(docAsBytes, &doc); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "verifyDocument() : invalid document record", err)
+	}
+	return json.Marshal(DocumentVerification{doc.Contractid, doc.DocumentID, hash, doc.Hash == hash, doc})
+}
+
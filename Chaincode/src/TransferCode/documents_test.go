@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hashOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAttachDocument(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "attachDocument", "C1", "INV-1", DOC_INVOICE, hashOf("invoice 1"))
+	var ev DocumentEvent
+	checkEvent(t, stub, EVENT_DOCUMENT_ATTACHED, &ev)
+	if ev.Document.DocumentID != "INV-1" || ev.Document.Uploader != "bosch" || ev.Document.UploaderRole != SELLER || ev.Document.Stage != STATE_OPEN {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// a later bill of lading does not replace the invoice, upper case hex is accepted
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "BL-1")
+	stub.MockTxTimestamp(testTime.Add(time.Hour))
+	mustInvoke(t, stub, dhl, "attachDocument", "C1", "BL-1", DOC_BILL_OF_LADING, strings.ToUpper(hashOf("bill of lading 1")))
+
+	got, err := query(stub, lht, "listDocuments", "C1")
+	if err != nil {
+		t.Fatalf("listDocuments failed: %s", err)
+	}
+	var docs []DocumentRecord
+	json.Unmarshal(got, &docs)
+	if len(docs) != 2 || docs[0].DocumentID != "INV-1" || docs[1].DocumentID != "BL-1" {
+		t.Fatalf("expected both documents oldest first, got %s", got)
+	}
+	if bl := docs[1]; bl.DocType != DOC_BILL_OF_LADING || bl.Hash != hashOf("bill of lading 1") || bl.Uploader != "dhl" ||
+		bl.Stage != STATE_READYFORSHIPMENT || bl.TimeStamp != testTime.Add(time.Hour).Format(TIME_FORMAT) {
+		t.Fatalf("unexpected document %+v", bl)
+	}
+
+	_, err = query(stub, lht, "listDocuments", "C9")
+	checkError(t, err, "Failed to get contract object")
+	_, err = query(stub, continental, "listDocuments", "C1")
+	checkError(t, err, "Permission Denied")
+}
+
+func TestAttachDocumentRejected(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		caller  caller
+		args    []string
+		wantErr string
+	}{
+		{"not a party", continental, []string{"C1", "INV-2", DOC_INVOICE, hashOf("x")}, "Permission Denied"},
+		{"arbiter", arbiter, []string{"C1", "INV-2", DOC_INVOICE, hashOf("x")}, "Permission Denied"},
+		{"no attributes", anonymous, []string{"C1", "INV-2", DOC_INVOICE, hashOf("x")}, "Permission Denied"},
+		{"unknown contract", bosch, []string{"C9", "INV-2", DOC_INVOICE, hashOf("x")}, "Failed to get contract object"},
+		{"unknown type", bosch, []string{"C1", "INV-2", "receipt", hashOf("x")}, "DocType should be one of"},
+		{"short hash", bosch, []string{"C1", "INV-2", DOC_INVOICE, "abc"}, "SHA-256 digest"},
+		{"no document ID", bosch, []string{"C1", "", DOC_INVOICE, hashOf("x")}, "DocumentID is required"},
+		{"already attached", lht, []string{"C1", "INV-1", DOC_INVOICE, hashOf("x")}, "INV-1 is already attached to C1"},
+		{"wrong arguments", bosch, []string{"C1", "INV-2", DOC_INVOICE}, "Hash is required"},
+	}
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "attachDocument", "C1", "INV-1", DOC_INVOICE, hashOf("invoice 1"))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, "attachDocument", test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+
+	got, _ := query(stub, lht, "verifyDocument", "C1", "INV-1", hashOf("invoice 1"))
+	if !strings.Contains(string(got), `"Verified":true`) {
+		t.Fatalf("expected the original invoice to be kept, got %s", got)
+	}
+}
+
+func TestVerifyDocument(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, lht, "attachDocument", "C1", "POD-1", DOC_PROOF_OF_DELIVERY, hashOf("signed"))
+
+	tests := []struct {
+		name     string
+		args     []string
+		verified bool
+		wantErr  string
+	}{
+		{"same content", []string{"C1", "POD-1", hashOf("signed")}, true, ""},
+		{"altered content", []string{"C1", "POD-1", hashOf("signed twice")}, false, ""},
+		{"unknown document", []string{"C1", "POD-2", hashOf("signed")}, false, "no document POD-2 attached to C1"},
+		{"other contract", []string{"C2", "POD-1", hashOf("signed")}, false, "Failed to get contract object"},
+		{"not a hash", []string{"C1", "POD-1", "signed"}, false, "SHA-256 digest"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := query(stub, dhl, "verifyDocument", test.args...)
+			checkError(t, err, test.wantErr)
+			if err != nil {
+				return
+			}
+			var result DocumentVerification
+			json.Unmarshal(got, &result)
+			if result.Verified != test.verified || result.Hash != test.args[2] || result.Document.Hash != hashOf("signed") || result.Document.UploaderRole != BUYER {
+				t.Fatalf("unexpected verification %s", got)
+			}
+		})
+	}
+	_, err := query(stub, continental, "verifyDocument", "C1", "POD-1", hashOf("signed"))
+	checkError(t, err, "Permission Denied")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Errors - Init, Invoke and Query return every error as a ChaincodeError serialized in JSON, whatever function
+//			  failed. Its Code tells a client what went wrong without matching the message, Field names the argument
+//			  or field at fault and EntityID the asset, contract, document or account. Errors that are not typed
+//			  where they are raised are INTERNAL. The REST Invoke and Query handlers of core/rest map the code to an
+//			  HTTP status, e.g. PERMISSION_DENIED to 403 and an unknown code to 500.
+//==============================================================================================================================
+const ERR_INVALID_ARGUMENT = "INVALID_ARGUMENT"   // the arguments or metadata are malformed or fail validation
+const ERR_NOT_FOUND = "NOT_FOUND"                 // the asset, contract or record does not exist
+const ERR_ALREADY_EXISTS = "ALREADY_EXISTS"       // the asset, contract or record to create exists
+const ERR_PERMISSION_DENIED = "PERMISSION_DENIED" // the caller may not perform the function
+const ERR_CONFLICT = "CONFLICT"                   // the ledger does not allow the change: a stage, a lock or a balance
+const ERR_UNKNOWN_FUNCTION = "UNKNOWN_FUNCTION"   // the chaincode has no such function
+const ERR_INTERNAL = "INTERNAL"                   // the ledger could not be read or written, or holds a corrupt record
+
+// ChaincodeError struct - an error returned to the client
+type ChaincodeError struct {
+	Code       string
+	Message    string
+	Field      string      // the argument or field at fault, may be empty
+	EntityID   string      // the object at fault, may be empty
+	Violations []Violation `json:",omitempty"` // every rule an invalid request breaks, see decodeRequest
+}
+
+// Error returns the error in JSON
+func (e *ChaincodeError) Error() string {
+
+	buff, err := json.Marshal(e)
+	if err != nil {
+		return e.Message
+	}
+	return string(buff)
+}
+
+func invalidArgument(field string, message string) error {
+	return &ChaincodeError{ERR_INVALID_ARGUMENT, message, field, "", nil}
+}
+
+func notFound(entityID string, message string) error {
+	return &ChaincodeError{ERR_NOT_FOUND, message, "", entityID, nil}
+}
+
+func alreadyExists(entityID string, message string) error {
+	return &ChaincodeError{ERR_ALREADY_EXISTS, message, "", entityID, nil}
+}
+
+func conflict(entityID string, message string) error {
+	return &ChaincodeError{ERR_CONFLICT, message, "", entityID, nil}
+}
+
+// permissionDenied returns the error of a caller that may not perform a function
+func permissionDenied(function string) error {
+	return &ChaincodeError{ERR_PERMISSION_DENIED, "Permission Denied. " + function, "", "", nil}
+}
+
+func unknownFunction(message string) error {
+	return &ChaincodeError{ERR_UNKNOWN_FUNCTION, message, "function", "", nil}
+}
+
+// respond returns the result of a function, its error as a ChaincodeError. The error is logged, as an error when
+// it is internal and as a warning otherwise.
+func respond(stub shim.ChaincodeStubInterface, payload []byte, err error) ([]byte, error) {
+
+	if err == nil {
+		return payload, nil
+	}
+	ce := asChaincodeError(err)
+	keyvals := []interface{}{"code", ce.Code}
+	if ce.Field != "" {
+		keyvals = append(keyvals, "field", ce.Field)
+	}
+	if ce.Code == ERR_INTERNAL {
+		logFor(stub, ce.EntityID).error(ce.Message, keyvals...)
+	} else {
+		logFor(stub, ce.EntityID).warning(ce.Message, keyvals...)
+	}
+	return nil, ce
+}
+
+// wrapError prefixes the message of err, keeping its code, field and entity. An untyped err gets the given code.
+func wrapError(code string, prefix string, err error) error {
+
+	ce, ok := parseError(err)
+	if !ok {
+		ce = &ChaincodeError{Code: code, Message: err.Error()}
+	}
+	wrapped := *ce
+	wrapped.Message = prefix + " : " + ce.Message
+	return &wrapped
+}
+
+// asChaincodeError returns err as a ChaincodeError, an untyped err is internal
+func asChaincodeError(err error) *ChaincodeError {
+
+	if ce, ok := parseError(err); ok {
+		return ce
+	}
+	return &ChaincodeError{Code: ERR_INTERNAL, Message: err.Error()}
+}
+
+// parseError returns the ChaincodeError err is, or carries in its message when it was returned by a peer chaincode
+func parseError(err error) (*ChaincodeError, bool) {
+
+	if ce, ok := err.(*ChaincodeError); ok {
+		return ce, true
+	}
+	message := err.Error()
+	start := strings.Index(message, `{"Code":`)
+	if start < 0 {
+		return nil, false
+	}
+	var ce ChaincodeError
+	if json.NewDecoder(strings.NewReader(message[start:])).Decode(&ce) != nil || ce.Code == "" {
+		return nil, false
+	}
+	return &ce, true
+}
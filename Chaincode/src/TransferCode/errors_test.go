@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// checkCode verifies err is a ChaincodeError in JSON with the given code, field and entity
+func checkCode(t *testing.T, err error, code string, field string, entityID string) {
+
+	if err == nil {
+		t.Fatalf("expected a %s error, got none", code)
+	}
+	ce, ok := parseError(err)
+	if !ok {
+		t.Fatalf("expected a %s error in JSON, got %s", code, err)
+	}
+	if ce.Code != code || ce.Field != field || ce.EntityID != entityID || ce.Message == "" {
+		t.Fatalf("expected a %s error on field %q of %q, got %s", code, field, entityID, err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "100")
+	tests := []struct {
+		name     string
+		query    bool
+		caller   caller
+		function string
+		args     []string
+		code     string
+		field    string
+		entityID string
+	}{
+		{"unknown invoke", false, bosch, "CreateAssetbject", nil, ERR_UNKNOWN_FUNCTION, "function", ""},
+		{"unknown query", true, bosch, "readAll", nil, ERR_UNKNOWN_FUNCTION, "function", ""},
+		{"arguments", false, bosch, "initAssset", []string{"1003"}, ERR_INVALID_ARGUMENT, "", ""},
+		{"asset exists", false, bosch, "initAssset", []string{"1002", "LHTMO", "bosch"}, ERR_ALREADY_EXISTS, "", "1002"},
+		{"contract exists", false, bosch, "initContract", []string{"C1", "0", "lht", "dhl", "bosch", "1002", "D1", ""}, ERR_ALREADY_EXISTS, "", "C1"},
+		{"asset locked", false, bosch, "initContract", []string{"C2", "0", "lht", "dhl", "bosch", "1001", "D1", ""}, ERR_CONFLICT, "", "1001"},
+		{"no contract", false, lht, "shipmentDelivered", []string{"C9"}, ERR_NOT_FOUND, "", "C9"},
+		{"not a party", true, continental, "readContract", []string{"C1"}, ERR_PERMISSION_DENIED, "", ""},
+		{"terms field", false, bosch, "initContract", []string{`{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"euro","LineItems":[{"AssetIDs":["1002"]}]}`}, ERR_INVALID_ARGUMENT, "Currency", ""},
+		{"time stamp", false, bosch, "initContract", []string{"C2", "0", "lht", "dhl", "bosch", "1002", "D1", "20161101103000"}, ERR_INVALID_ARGUMENT, "TimeStamp", ""},
+		{"terms JSON", false, bosch, "initContract", []string{"{"}, ERR_INVALID_ARGUMENT, "", ""},
+		{"unknown action", false, bosch, "transition", []string{"C1", "ship"}, ERR_INVALID_ARGUMENT, "Action", ""},
+		{"required field", false, bosch, "transition", []string{"C1", "cancelContract", "{}"}, ERR_INVALID_ARGUMENT, FIELD_REASON, ""},
+		{"page size", true, auditor, "listContracts", []string{"", "0"}, ERR_INVALID_ARGUMENT, "PageSize", ""},
+		{"no escrow", true, auditor, "getEscrow", []string{"C1"}, ERR_NOT_FOUND, "", "C1"},
+		{"funds", false, bank, "depositFunds", []string{"lht", "EUR", "-1"}, ERR_INVALID_ARGUMENT, "Amount", ""},
+		{"no document", true, lht, "verifyDocument", []string{"C1", "D1", "0000000000000000000000000000000000000000000000000000000000000000"}, ERR_NOT_FOUND, "", "D1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if test.query {
+				_, err = query(stub, test.caller, test.function, test.args...)
+			} else {
+				_, err = invoke(stub, test.caller, test.function, test.args...)
+			}
+			checkCode(t, err, test.code, test.field, test.entityID)
+		})
+	}
+}
+
+func TestUntypedErrorsAreInternal(t *testing.T) {
+
+	err := asChaincodeError(errors.New("Error storing asset"))
+	if err.Code != ERR_INTERNAL || err.Message != "Error storing asset" {
+		t.Fatalf("unexpected error %+v", err)
+	}
+	// a prefix keeps the code of a typed error and types an untyped one
+	checkCode(t, wrapError(ERR_INTERNAL, "lockAsset()", notFound("1001", "no asset")), ERR_NOT_FOUND, "", "1001")
+	checkCode(t, wrapError(ERR_INVALID_ARGUMENT, "filter", errors.New("bad JSON")), ERR_INVALID_ARGUMENT, "", "")
+}
+
+func TestPeerErrorCodes(t *testing.T) {
+
+	// the code of an error returned by a peer chaincode reaches the client
+	contracts, registry, _ := newPeerStubs(t)
+	mustInvoke(t, registry, admin, "initAssset", "3003", "LHTMO", "continental")
+	_, err := invoke(contracts, bosch, "initContract", `{"Contractid":"C4","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["3003"]}]}`)
+	checkCode(t, err, ERR_PERMISSION_DENIED, "", "")
+	_, err = invoke(contracts, bosch, "initContract", `{"Contractid":"C4","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["3009"]}]}`)
+	checkCode(t, err, ERR_NOT_FOUND, "", "3009")
+	mustInvoke(t, contracts, bosch, "initContract", `{"Contractid":"C4","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"EUR","LineItems":[{"AssetIDs":["3001"],"UnitPrice":10001}]}`)
+	_, err = invoke(contracts, lht, "fundEscrow", "C4")
+	checkCode(t, err, ERR_CONFLICT, "", "lht")
+}
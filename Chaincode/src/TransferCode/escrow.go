@@ -0,0 +1,523 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Escrow - parties hold balances, one account per party and currency, in the minor unit of the currency. The
+//			  treasury credits the money paid in off the ledger. Once the seller created a priced contract, its buyer
+//			  moves the price and the fee of the transporter from its account into the escrow of the contract with
+//			  fundEscrow, the seller can't ready the shipment before. The escrow pays the seller for each line item
+//			  delivered and the transporter once every line is, and refunds the buyer for whatever is not delivered
+//			  when the contract is cancelled or returned. Every movement is a Posting from one account to another, so
+//			  balances can be traced back entry by entry, as in chaincode_example02.
+//==============================================================================================================================
+const TREASURY = "treasury"
+
+// Accounts postings are made between besides those of the parties
+const ACCOUNT_TREASURY = "treasury"
+const ACCOUNT_ESCROW = "escrow/" // followed by the contract ID
+
+const POSTING_DEPOSIT = "deposit"
+const POSTING_LOCK = "lock"
+const POSTING_RELEASE = "release"
+const POSTING_FEE = "fee"
+const POSTING_REFUND = "refund"
+
+const ESCROW_HELD = "held"
+const ESCROW_SETTLED = "settled"
+
+// AccountObject struct
+type AccountObject struct {
+	Owner    string
+	Currency string
+	Balance  int64
+}
+
+// EscrowObject struct - the money a contract holds
+type EscrowObject struct {
+	Contractid  string
+	Currency    string
+	Buyer       string
+	Seller      string
+	Transporter string
+	Locked      int64 // price and fee locked by fundEscrow
+	Released    int64 // paid to the seller
+	FeePaid     int64 // paid to the transporter
+	Refunded    int64 // paid back to the buyer
+	Status      string
+}
+
+// Posting struct - money moved from one account to another
+type Posting struct {
+	PostingID  string
+	TxID       string
+	TimeStamp  string
+	Contractid string // empty for a deposit
+	Currency   string
+	From       string
+	To         string
+	Amount     int64
+	Kind       string // POSTING_DEPOSIT, POSTING_LOCK, POSTING_RELEASE, POSTING_FEE or POSTING_REFUND
+}
+
+// AccountStatement struct - the response of getAccount
+type AccountStatement struct {
+	Owner    string
+	Balances []AccountObject
+	Postings []Posting
+}
+
+// EscrowStatement struct - the response of getEscrow
+type EscrowStatement struct {
+	Escrow   EscrowObject
+	Postings []Posting
+}
+
+// held returns the money the escrow still holds
+func (e EscrowObject) held() int64 {
+	return e.Locked - e.Released - e.FeePaid - e.Refunded
+}
+
+// escrowAccount returns the account ID of the escrow of a contract
+func escrowAccount(contractID string) string {
+	return ACCOUNT_ESCROW + contractID
+}
+
+// DepositRequest struct - the arguments of depositFunds
+type DepositRequest struct {
+	Owner    string `validate:"required"`
+	Currency string `validate:"required,pattern=currency"`
+	Amount   int64  `validate:"required,min=1"` // in the minor unit of the currency
+}
+
+// OwnerRequest struct - the arguments of getAccount
+type OwnerRequest struct {
+	Owner string `validate:"required"`
+}
+
+// depositFunds credits money paid in to the account of a party. Only the treasury deposits.
+// args: owner, currency, amount in the minor unit of the currency
+func (t *SimpleChaincode) depositFunds(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req DepositRequest
+	if err := decodeRequest("depositFunds", args, &req); err != nil {
+		return nil, err
+	}
+	if _, err := t.check_role(stub, TREASURY); err != nil {
+		logFor(stub, req.Owner).debug("caller may not deposit funds", "reason", err)
+		return nil, permissionDenied("depositFunds")
+	}
+	owner, currency, amount := req.Owner, req.Currency, req.Amount
+	if owner == ACCOUNT_TREASURY {
+		return nil, invalidArgument("Owner", "depositFunds() : Owner should be a party, not the treasury")
+	}
+
+	account, err := getAccountObject(stub, owner, currency)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "depositFunds()", err)
+	}
+	if account.Balance > math.MaxInt64-amount {
+		return nil, conflict(owner, "depositFunds() : the balance would be too large")
+	}
+	account.Balance += amount
+	if err = save_account(stub, account); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "depositFunds()", err)
+	}
+	posting, err := post(stub, "", currency, ACCOUNT_TREASURY, owner, amount, POSTING_DEPOSIT)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "depositFunds()", err)
+	}
+	if err = emitPostingEvent(stub, EVENT_FUNDS_DEPOSITED, []Posting{posting}); err != nil {
+		return nil, err
+	}
+	logFor(stub, owner).info("funds deposited", "currency", currency, "amount", amount)
+	return nil, nil
+}
+
+// fundEscrow moves the price and the fee of the transporter of an open contract from the account of the buyer
+// into the escrow of the contract. Only the buyer funds the escrow, it is the account debited.
+// args: contractid
+func (t *SimpleChaincode) fundEscrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("fundEscrow", args, &req); err != nil {
+		return nil, err
+	}
+	sc, err := getContractObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if err = check_open(sc); err != nil {
+		return nil, err
+	}
+	if err = t.check_caller(stub, sc.Buyer, BUYER); err != nil {
+		logFor(stub, sc.Contractid).debug("caller may not fund the escrow", "reason", err)
+		return nil, permissionDenied("fundEscrow")
+	}
+	if sc.Stage != STATE_OPEN {
+		return nil, conflict(sc.Contractid, fmt.Sprintf("fundEscrow() : contract %s is no longer open, it is at stage %d", sc.Contractid, sc.Stage))
+	}
+	if sc.FundedAt != "" {
+		return nil, alreadyExists(sc.Contractid, "fundEscrow() : escrow of "+sc.Contractid+" is already funded")
+	}
+	escrow := newEscrow(sc)
+	if escrow.Locked == 0 {
+		return nil, conflict(sc.Contractid, "fundEscrow() : contract "+sc.Contractid+" has no price or fee to pay")
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lock_contract_escrow(stub, escrow); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "fundEscrow() : cannot lock the escrow", err)
+	}
+	before := sc
+	sc.FundedAt = txTime.Format(TIME_FORMAT)
+	if _, err = t.save_changes(stub, &sc); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "fundEscrow() : write error while inserting record", err)
+	}
+	if err = emitContractEvent(stub, EVENT_ESCROW_FUNDED, &before, sc); err != nil {
+		return nil, err
+	}
+	logFor(stub, sc.Contractid).info("escrow funded", "currency", escrow.Currency, "amount", escrow.Locked)
+	return nil, nil
+}
+
+// check_funded - Verifies that the buyer paid into the escrow of a priced contract. It is checked before the
+//				  seller readies the shipment.
+func check_funded(sc SalesContractObject) error {
+
+	if sc.FundedAt == "" && sc.Total+sc.TransporterFee != 0 {
+		return conflict(sc.Contractid, "contract "+sc.Contractid+" waits for the buyer to fund the escrow")
+	}
+	return nil
+}
+
+// newEscrow returns the escrow of a contract before anything is paid into it, holding the price and the fee of
+// its transporter
+func newEscrow(sc SalesContractObject) EscrowObject {
+	return EscrowObject{Contractid: sc.Contractid, Currency: sc.Currency, Buyer: sc.Buyer, Seller: sc.Seller, Transporter: sc.Transporter,
+		Locked: sc.Total + sc.TransporterFee, Status: ESCROW_HELD}
+}
+
+// lock_escrow - Moves the money the escrow of a contract is to hold from the account of the buyer into the
+//				 escrow. Nothing is written when the buyer can't pay. A contract without a price or fee has
+//				 no escrow.
+func lock_escrow(stub shim.ChaincodeStubInterface, escrow EscrowObject) error {
+
+	amount := escrow.Locked
+	if amount == 0 {
+		return nil
+	}
+	existing, err := getEscrowObject(stub, escrow.Contractid)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return alreadyExists(escrow.Contractid, "escrow of "+escrow.Contractid+" already exists")
+	}
+	account, err := getAccountObject(stub, escrow.Buyer, escrow.Currency)
+	if err != nil {
+		return err
+	}
+	if account.Balance < amount {
+		return conflict(escrow.Buyer, fmt.Sprintf("buyer %s holds %d %s, the contract needs %d", escrow.Buyer, account.Balance, escrow.Currency, amount))
+	}
+	account.Balance -= amount
+	if err = save_account(stub, account); err != nil {
+		return err
+	}
+	if err = save_escrow(stub, escrow); err != nil {
+		return err
+	}
+	_, err = post(stub, escrow.Contractid, escrow.Currency, escrow.Buyer, escrowAccount(escrow.Contractid), amount, POSTING_LOCK)
+	return err
+}
+
+// settle_escrow - Pays out of the escrow of a contract: released to the seller, fee to the transporter and
+//				   refund to the buyer. The escrow is settled once it holds nothing.
+func settle_escrow(stub shim.ChaincodeStubInterface, contractID string, released int64, fee int64, refund int64) error {
+
+	if released+fee+refund == 0 {
+		return nil
+	}
+	escrow, err := getEscrowObject(stub, contractID)
+	if err != nil {
+		return err
+	}
+	if escrow == nil {
+		return nil
+	}
+	if released+fee+refund > escrow.held() {
+		return conflict(contractID, fmt.Sprintf("escrow of %s holds %d %s, %d is due", contractID, escrow.held(), escrow.Currency, released+fee+refund))
+	}
+
+	payments := []struct {
+		to     string
+		amount int64
+		kind   string
+	}{
+		{escrow.Seller, released, POSTING_RELEASE},
+		{escrow.Transporter, fee, POSTING_FEE},
+		{escrow.Buyer, refund, POSTING_REFUND},
+	}
+	for _, payment := range payments {
+		if payment.amount == 0 {
+			continue
+		}
+		account, err := getAccountObject(stub, payment.to, escrow.Currency)
+		if err != nil {
+			return err
+		}
+		if account.Balance > math.MaxInt64-payment.amount {
+			return conflict(payment.to, "the balance of "+payment.to+" would be too large")
+		}
+		account.Balance += payment.amount
+		if err = save_account(stub, account); err != nil {
+			return err
+		}
+		if _, err = post(stub, contractID, escrow.Currency, escrowAccount(contractID), payment.to, payment.amount, payment.kind); err != nil {
+			return err
+		}
+	}
+	escrow.Released += released
+	escrow.FeePaid += fee
+	escrow.Refunded += refund
+	if escrow.held() == 0 {
+		escrow.Status = ESCROW_SETTLED
+	}
+	return save_escrow(stub, *escrow)
+}
+
+// getAccount returns the balances of a party and the postings of its accounts, oldest first. args: owner
+func (t *SimpleChaincode) getAccount(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req OwnerRequest
+	if err := decodeRequest("getAccount", args, &req); err != nil {
+		return nil, err
+	}
+	owner := req.Owner
+	if !t.get_reader(stub).canReadAccount(owner) {
+		logFor(stub, owner).debug("caller may not read the accounts")
+		return nil, permissionDenied("getAccount")
+	}
+	statement := AccountStatement{Owner: owner, Balances: []AccountObject{}}
+
+	startKey, endKey, err := compositeKeyRange(ACCOUNT_OBJECT, []string{owner})
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getAccount()", err)
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getAccount() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+	for keysIter.HasNext() {
+		_, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "getAccount() : Error accessing state", iterErr)
+		}
+		var account AccountObject
+		if err = json.Unmarshal(value, &account); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "getAccount() : invalid account record", err)
+		}
+		statement.Balances = append(statement.Balances, account)
+	}
+	sort.Slice(statement.Balances, func(i, j int) bool { return statement.Balances[i].Currency < statement.Balances[j].Currency })
+
+	if statement.Postings, err = getPostings(stub, INDEX_POSTING_ACCOUNT, owner); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getAccount()", err)
+	}
+	return json.Marshal(statement)
+}
+
+// getEscrow returns the escrow of a contract and its postings, oldest first. args: contractid
+func (t *SimpleChaincode) getEscrow(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("getEscrow", args, &req); err != nil {
+		return nil, err
+	}
+	contractID := req.Contractid
+	escrow, err := getEscrowObject(stub, contractID)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getEscrow()", err)
+	}
+	if escrow == nil {
+		return nil, notFound(contractID, "no escrow for "+contractID)
+	}
+	// the settlement of a peer chaincode only knows the parties of the escrow
+	sc, err := getContractObject(stub, escrow.Contractid)
+	if err != nil {
+		sc = SalesContractObject{Buyer: escrow.Buyer, Seller: escrow.Seller, Transporter: escrow.Transporter}
+	}
+	if !t.get_reader(stub).canReadContract(sc) {
+		logFor(stub, contractID).debug("caller may not read the escrow")
+		return nil, permissionDenied("getEscrow")
+	}
+	statement := EscrowStatement{Escrow: *escrow}
+	if statement.Postings, err = getPostings(stub, INDEX_POSTING_CONTRACT, contractID); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getEscrow()", err)
+	}
+	return json.Marshal(statement)
+}
+
+// getAccountObject returns the account of a party in a currency, with a zero balance when it was never used
+func getAccountObject(stub shim.ChaincodeStubInterface, owner string, currency string) (AccountObject, error) {
+
+	account := AccountObject{Owner: owner, Currency: currency}
+	accountKey, err := createCompositeKey(ACCOUNT_OBJECT, []string{owner, currency})
+	if err != nil {
+		return account, err
+	}
+	accountAsBytes, err := stub.GetState(accountKey)
+	if err != nil {
+		return account, errors.New("Failed to get account")
+	}
+	if accountAsBytes == nil {
+		return account, nil
+	}
+	if err = json.Unmarshal(accountAsBytes, &account); err != nil {
+		return account, wrapError(ERR_INTERNAL, "invalid account record", err)
+	}
+	return account, nil
+}
+
+// save_account - Writes the balance of an account
+func save_account(stub shim.ChaincodeStubInterface, account AccountObject) error {
+
+	accountKey, err := createCompositeKey(ACCOUNT_OBJECT, []string{account.Owner, account.Currency})
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(account)
+	if err != nil {
+		return wrapError(ERR_INTERNAL, "Error converting account", err)
+	}
+	if err = stub.PutState(accountKey, buff); err != nil {
+		return wrapError(ERR_INTERNAL, "Error storing account", err)
+	}
+	return nil
+}
+
+// getEscrowObject returns the escrow of a contract, nil when the contract has none
+func getEscrowObject(stub shim.ChaincodeStubInterface, contractID string) (*EscrowObject, error) {
+
+	escrowKey, err := createCompositeKey(ESCROW_OBJECT, []string{contractID})
+	if err != nil {
+		return nil, err
+	}
+	escrowAsBytes, err := stub.GetState(escrowKey)
+	if err != nil {
+		return nil, errors.New("Failed to get escrow")
+	}
+	if escrowAsBytes == nil {
+		return nil, nil
+	}
+	var escrow EscrowObject
+	if err = json.Unmarshal(escrowAsBytes, &escrow); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "invalid escrow record", err)
+	}
+	return &escrow, nil
+}
+
+// save_escrow - Writes the escrow of a contract
+func save_escrow(stub shim.ChaincodeStubInterface, escrow EscrowObject) error {
+
+	escrowKey, err := createCompositeKey(ESCROW_OBJECT, []string{escrow.Contractid})
+	if err != nil {
+		return err
+	}
+	buff, err := json.Marshal(escrow)
+	if err != nil {
+		return wrapError(ERR_INTERNAL, "Error converting escrow", err)
+	}
+	if err = stub.PutState(escrowKey, buff); err != nil {
+		return wrapError(ERR_INTERNAL, "Error storing escrow", err)
+	}
+	return nil
+}
+
+// post - Records money moved from one account to another. The posting is listed under both accounts and the
+//		  contract. Its ID is made of the transaction, the contract, or the account credited by a deposit, and
+//		  the number of postings the transaction made for it before, so a transaction can post any number of
+//		  times.
+func post(stub shim.ChaincodeStubInterface, contractID string, currency string, from string, to string, amount int64, kind string) (Posting, error) {
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return Posting{}, err
+	}
+	owner := contractID
+	if owner == "" {
+		owner = to
+	}
+	// posting IDs sort in time order, then in the order of the transaction
+	var postingID, postingKey string
+	for n := 0; postingKey == ""; n++ {
+		postingID = fmt.Sprintf("%020d-%s-%s-%04d", txTime.UnixNano(), stub.GetTxID(), owner, n)
+		key, err := createCompositeKey(POSTING_OBJECT, []string{postingID})
+		if err != nil {
+			return Posting{}, err
+		}
+		postingAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return Posting{}, wrapError(ERR_INTERNAL, "Failed to get posting "+postingID, err)
+		}
+		if postingAsBytes == nil {
+			postingKey = key
+		}
+	}
+	posting := Posting{postingID, stub.GetTxID(), txTime.Format(TIME_FORMAT), contractID, currency, from, to, amount, kind}
+
+	buff, err := json.Marshal(posting)
+	if err != nil {
+		return posting, wrapError(ERR_INTERNAL, "Error converting posting", err)
+	}
+	if err = stub.PutState(postingKey, buff); err != nil {
+		return posting, wrapError(ERR_INTERNAL, "Error storing posting", err)
+	}
+	entries := []indexEntry{{INDEX_POSTING_ACCOUNT, from}, {INDEX_POSTING_ACCOUNT, to}}
+	if contractID != "" {
+		entries = append(entries, indexEntry{INDEX_POSTING_CONTRACT, contractID})
+	}
+	if err = updateIndexes(stub, posting.PostingID, nil, entries); err != nil {
+		return posting, wrapError(ERR_INTERNAL, "Error storing posting indexes", err)
+	}
+	return posting, nil
+}
+
+// getPostings returns the postings listed in an index under the given value, oldest first
+func getPostings(stub shim.ChaincodeStubInterface, indexName string, value string) ([]Posting, error) {
+
+	ids, err := getIndexedIDs(stub, indexName, value)
+	if err != nil {
+		return nil, err
+	}
+	postings := []Posting{}
+	for _, postingID := range ids {
+		postingKey, err := createCompositeKey(POSTING_OBJECT, []string{postingID})
+		if err != nil {
+			return nil, err
+		}
+		postingAsBytes, err := stub.GetState(postingKey)
+		if err != nil || postingAsBytes == nil {
+			return nil, errors.New("Failed to get posting " + postingID)
+		}
+		var posting Posting
+		if err = json.Unmarshal(postingAsBytes, &posting); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "invalid posting record", err)
+		}
+		postings = append(postings, posting)
+	}
+	return postings, nil
+}
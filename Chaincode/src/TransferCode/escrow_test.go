@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// C2 of newLineItemStub holds the price of its three lines and the fee of dhl
+const (
+	line1Amount = 300000
+	line2Amount = 990000
+	line3Amount = 1250
+	c2Fee       = 5000
+	c2Escrow    = line1Amount + line2Amount + line3Amount + c2Fee
+)
+
+func getBalance(t *testing.T, stub *shim.MockStub, owner string) int64 {
+
+	got, err := query(stub, auditor, "getAccount", owner)
+	if err != nil {
+		t.Fatalf("getAccount failed: %s", err)
+	}
+	var statement AccountStatement
+	json.Unmarshal(got, &statement)
+	for _, account := range statement.Balances {
+		if account.Currency == "EUR" {
+			return account.Balance
+		}
+	}
+	return 0
+}
+
+func getEscrowStatement(t *testing.T, stub *shim.MockStub, contractID string) EscrowStatement {
+
+	got, err := query(stub, auditor, "getEscrow", contractID)
+	if err != nil {
+		t.Fatalf("getEscrow failed: %s", err)
+	}
+	var statement EscrowStatement
+	json.Unmarshal(got, &statement)
+	return statement
+}
+
+func checkBalances(t *testing.T, stub *shim.MockStub, want map[string]int64) {
+
+	for owner, balance := range want {
+		if got := getBalance(t, stub, owner); got != balance {
+			t.Fatalf("expected %s to hold %d, got %d", owner, balance, got)
+		}
+	}
+}
+
+func TestDepositFunds(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "1000")
+	var ev PostingEvent
+	checkEvent(t, stub, EVENT_FUNDS_DEPOSITED, &ev)
+	if len(ev.Postings) != 1 || ev.Postings[0].From != ACCOUNT_TREASURY || ev.Postings[0].To != "lht" || ev.Postings[0].Amount != 1000 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "USD", "5")
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "500")
+
+	got, err := query(stub, lht, "getAccount", "lht")
+	if err != nil {
+		t.Fatalf("getAccount failed: %s", err)
+	}
+	var statement AccountStatement
+	json.Unmarshal(got, &statement)
+	if len(statement.Balances) != 2 || statement.Balances[0] != (AccountObject{"lht", "EUR", 1500}) || statement.Balances[1] != (AccountObject{"lht", "USD", 5}) {
+		t.Fatalf("unexpected balances %s", got)
+	}
+	if len(statement.Postings) != 3 || statement.Postings[2].Currency != "EUR" || statement.Postings[2].Kind != POSTING_DEPOSIT {
+		t.Fatalf("expected the postings oldest first, got %s", got)
+	}
+
+	tests := []struct {
+		name    string
+		caller  caller
+		args    []string
+		wantErr string
+	}{
+		{"not the treasury", lht, []string{"lht", "EUR", "1000"}, "Permission Denied"},
+		{"arbiter", arbiter, []string{"lht", "EUR", "1000"}, "Permission Denied"},
+		{"bad currency", bank, []string{"lht", "eur", "1000"}, "ISO 4217"},
+		{"negative amount", bank, []string{"lht", "EUR", "-1"}, "Amount should be at least 1"},
+		{"overflow", bank, []string{"lht", "EUR", "9223372036854775807"}, "too large"},
+		{"treasury account", bank, []string{ACCOUNT_TREASURY, "EUR", "1"}, "not the treasury"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, "depositFunds", test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+	checkBalances(t, stub, map[string]int64{"lht": 1500})
+}
+
+func TestEscrowDelivery(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	checkBalances(t, stub, map[string]int64{"lht": 2000000 - c2Escrow, "bosch": 0, "dhl": 0})
+	if escrow := getEscrowStatement(t, stub, "C2").Escrow; escrow.Locked != c2Escrow || escrow.Status != ESCROW_HELD || escrow.held() != c2Escrow {
+		t.Fatalf("unexpected escrow %+v", escrow)
+	}
+
+	// the seller is paid for each line the buyer receives, the transporter once the last one is received
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+	mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[1,3]")
+	checkBalances(t, stub, map[string]int64{"bosch": line1Amount + line3Amount, "dhl": 0})
+
+	mustInvoke(t, stub, dhl, "shipmentReached", "C2")
+	mustInvoke(t, stub, lht, "shipmentDelivered", "C2")
+	checkBalances(t, stub, map[string]int64{"lht": 2000000 - c2Escrow, "bosch": line1Amount + line2Amount + line3Amount, "dhl": c2Fee})
+
+	statement := getEscrowStatement(t, stub, "C2")
+	if escrow := statement.Escrow; escrow.Status != ESCROW_SETTLED || escrow.held() != 0 || escrow.FeePaid != c2Fee || escrow.Refunded != 0 {
+		t.Fatalf("unexpected escrow %+v", escrow)
+	}
+	kinds := []string{}
+	for _, posting := range statement.Postings {
+		kinds = append(kinds, posting.Kind)
+	}
+	// the postings of a transaction sort in the order they are made
+	if !reflect.DeepEqual(kinds, []string{POSTING_LOCK, POSTING_RELEASE, POSTING_RELEASE, POSTING_FEE}) {
+		t.Fatalf("unexpected postings %+v", statement.Postings)
+	}
+}
+
+func TestEscrowRefund(t *testing.T) {
+
+	t.Run("cancelled", func(t *testing.T) {
+		stub := newLineItemStub(t)
+		mustInvoke(t, stub, bosch, "cancelContract", "C2", "out of stock", "")
+		checkBalances(t, stub, map[string]int64{"lht": 2000000, "bosch": 0, "dhl": 0})
+		if escrow := getEscrowStatement(t, stub, "C2").Escrow; escrow.Status != ESCROW_SETTLED || escrow.Refunded != c2Escrow {
+			t.Fatalf("unexpected escrow %+v", escrow)
+		}
+	})
+
+	t.Run("returned after a partial delivery", func(t *testing.T) {
+		stub := newLineItemStub(t)
+		mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+		mustInvoke(t, stub, dhl, "inTransit", "C2")
+		mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[1]")
+		mustInvoke(t, stub, dhl, "shipmentReached", "C2")
+		mustInvoke(t, stub, lht, "rejectShipment", "C2", "damaged", "D3")
+		mustInvoke(t, stub, bosch, "returnedToSeller", "C2", "D4")
+		checkBalances(t, stub, map[string]int64{"lht": 2000000 - line1Amount, "bosch": line1Amount, "dhl": 0})
+		if ast := getAsset(t, stub, "2003"); ast.Owner != "bosch" || ast.Contractid != "" {
+			t.Fatalf("unexpected asset %+v", ast)
+		}
+	})
+}
+
+func TestPostingsOfOneTransaction(t *testing.T) {
+
+	// a transaction paying out of an escrow twice keeps both postings
+	stub := newLineItemStub(t)
+	stub.MockTxTimestamp(testTime.Add(time.Hour))
+	stub.MockTransactionStart("settle")
+	for _, released := range []int64{100, 200} {
+		if err := settle_escrow(stub, "C2", released, 0, 0); err != nil {
+			t.Fatalf("settle_escrow failed: %s", err)
+		}
+	}
+	stub.MockTransactionEnd("settle")
+
+	postings := getEscrowStatement(t, stub, "C2").Postings
+	if len(postings) != 3 || postings[1].Amount != 100 || postings[2].Amount != 200 || postings[1].PostingID == postings[2].PostingID {
+		t.Fatalf("expected both releases posted in order, got %+v", postings)
+	}
+	checkBalances(t, stub, map[string]int64{"bosch": 300})
+}
+
+func TestFundEscrow(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "5000")
+	_, err := invoke(stub, dhl, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"EUR","LineItems":[{"AssetIDs":["1002"],"UnitPrice":1000}]}`)
+	checkError(t, err, "Permission Denied")
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"EUR","LineItems":[{"AssetIDs":["1002"],"UnitPrice":1000}]}`)
+	checkBalances(t, stub, map[string]int64{"lht": 5000})
+
+	// the seller waits for the buyer to pay in
+	_, err = invoke(stub, bosch, "readyForShipment", "C2", "D2")
+	checkCode(t, err, ERR_CONFLICT, "", "C2")
+	for _, c := range []caller{bosch, dhl, continental, anonymous} {
+		_, err = invoke(stub, c, "fundEscrow", "C2")
+		checkError(t, err, "Permission Denied")
+	}
+	mustInvoke(t, stub, lht, "fundEscrow", "C2")
+	checkBalances(t, stub, map[string]int64{"lht": 4000})
+	_, err = invoke(stub, lht, "fundEscrow", "C2")
+	checkCode(t, err, ERR_ALREADY_EXISTS, "", "C2")
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+
+	// a contract without a price has nothing to fund
+	_, err = invoke(stub, lht, "fundEscrow", "C1")
+	checkCode(t, err, ERR_CONFLICT, "", "C1")
+}
+
+func TestEscrowInsufficientFunds(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "999")
+	_, err := invoke(stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],"TransporterFee":10}`)
+	checkError(t, err, "Currency is required")
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"EUR","LineItems":[{"AssetIDs":["1002"],"UnitPrice":1000}]}`)
+	_, err = invoke(stub, lht, "fundEscrow", "C2")
+	checkError(t, err, "buyer lht holds 999 EUR, the contract needs 1000")
+	if sc := getContract(t, stub, "C2"); sc.FundedAt != "" {
+		t.Fatalf("expected C2 to stay unfunded, got %+v", sc)
+	}
+	checkBalances(t, stub, map[string]int64{"lht": 999})
+	_, err = query(stub, arbiter, "getEscrow", "C2")
+	checkError(t, err, "no escrow for C2")
+}
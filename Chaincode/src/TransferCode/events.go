@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Events - every invoke that changes an asset or a contract sets one chaincode event with a JSON payload. The
+//			  fabric only delivers the last event set by a transaction, so each invoke sets a single event that
+//			  describes the whole change (e.g. a delivery also hands the asset over to the buyer). Clients subscribe
+//			  to an event name, or to "" for all of them, through events/consumer.EventsClient.
+//==============================================================================================================================
+const EVENT_ASSET_CREATED = "AssetCreated"
+const EVENT_ASSET_OWNER_CHANGED = "AssetOwnerChanged"
+const EVENT_CONTRACT_CREATED = "ContractCreated"
+const EVENT_CONTRACT_UPDATED = "ContractUpdated"
+const EVENT_CONTRACT_STAGE_CHANGED = "ContractStageChanged"
+const EVENT_RECORDS_MIGRATED = "RecordsMigrated"
+const EVENT_ASSETS_CREATED = "AssetsCreated"
+const EVENT_ASSETS_TRANSFERRED = "AssetsTransferred"
+const EVENT_DOCUMENT_ATTACHED = "DocumentAttached"
+const EVENT_CHECKPOINT_RECORDED = "CheckpointRecorded"
+const EVENT_FUNDS_DEPOSITED = "FundsDeposited"
+const EVENT_ESCROW_FUNDED = "EscrowFunded"
+const EVENT_ASSET_RECORDED = "AssetRecorded"
+const EVENT_ASSET_STATUS_CHANGED = "AssetStatusChanged"
+const EVENT_PART_REGISTERED = "PartRegistered"
+const EVENT_PART_SUPERSEDED = "PartSuperseded"
+const EVENT_RECALL_LAUNCHED = "RecallLaunched"
+
+// AssetEvent struct - payload of the asset events
+type AssetEvent struct {
+	TxID       string
+	Function   string
+	Actor      string
+	Serialno   string
+	Partno     string
+	OldOwner   string // empty when the asset was created
+	NewOwner   string
+	Contractid string
+}
+
+// ContractEvent struct - payload of the contract events
+type ContractEvent struct {
+	TxID       string
+	Function   string
+	Actor      string
+	Contractid string
+	AssetIDs   []string
+	OldStage   *int // null when the contract was created
+	NewStage   int
+	DocumentID string   // empty for a confidential contract
+	LineNos    []int    // the line items the change delivered
+	Breaches   []Breach // the deadlines the change missed, empty for a confidential contract
+}
+
+// AssetBatchEvent struct - payload of the batch events, one AssetEvent per asset of the batch
+type AssetBatchEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Assets   []AssetEvent
+}
+
+// MigrationEvent struct - payload of the event set by migrateRecords
+type MigrationEvent struct {
+	TxID       string
+	Function   string
+	Actor      string
+	ObjectType string
+	ObjectIDs  []string // the records rewritten in the current schema
+}
+
+// DocumentEvent struct - payload of the event set by attachDocument
+type DocumentEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Document DocumentRecord
+}
+
+// CheckpointEvent struct - payload of the event set by recordCheckpoint
+type CheckpointEvent struct {
+	TxID       string
+	Function   string
+	Actor      string
+	Checkpoint Checkpoint
+}
+
+// AssetRecordEvent struct - payload of the events set by the maintenance, inspection and status changes of an asset
+type AssetRecordEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Record   AssetRecord
+}
+
+// PartEvent struct - payload of the events set by the changes of the part catalog
+type PartEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Part     PartObject
+}
+
+// RecallEvent struct - payload of the event set by launchRecall
+type RecallEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Campaign RecallCampaign
+}
+
+// PostingEvent struct - payload of the events moving money
+type PostingEvent struct {
+	TxID     string
+	Function string
+	Actor    string
+	Postings []Posting
+}
+
+// emitAssetEvent sets the event describing a change of an asset from oldOwner to its current state
+func emitAssetEvent(stub shim.ChaincodeStubInterface, name string, oldOwner string, ast AssetObject) error {
+
+	return setEvent(stub, name, newAssetEvent(stub, oldOwner, ast))
+}
+
+// newAssetEvent returns the payload describing a change of an asset from oldOwner to its current state
+func newAssetEvent(stub shim.ChaincodeStubInterface, oldOwner string, ast AssetObject) AssetEvent {
+	return AssetEvent{stub.GetTxID(), getFunction(stub), getActor(stub), ast.Serialno, ast.Partno, oldOwner, ast.Owner, ast.Contractid}
+}
+
+// emitAssetBatchEvent sets the event describing the changes of the assets of a batch
+func emitAssetBatchEvent(stub shim.ChaincodeStubInterface, name string, assets []AssetEvent) error {
+
+	payload := AssetBatchEvent{stub.GetTxID(), getFunction(stub), getActor(stub), assets}
+	return setEvent(stub, name, payload)
+}
+
+// emitContractEvent sets the event describing a change of a contract. before is nil for a new contract.
+func emitContractEvent(stub shim.ChaincodeStubInterface, name string, before *SalesContractObject, sc SalesContractObject) error {
+
+	payload := ContractEvent{stub.GetTxID(), getFunction(stub), getActor(stub), sc.Contractid, sc.assetIDs(), nil, sc.Stage, sc.DocumentID, []int{}, []Breach{}}
+	if sc.Sealed != nil {
+		payload.Actor, payload.DocumentID = "", "" // events are read by every subscriber, see confidential.go
+	}
+	if before != nil {
+		oldStage := before.Stage
+		payload.OldStage = &oldStage
+		for i, line := range sc.LineItems {
+			if line.Delivered && i < len(before.LineItems) && !before.LineItems[i].Delivered {
+				payload.LineNos = append(payload.LineNos, line.LineNo)
+			}
+		}
+		if len(sc.Breaches) > len(before.Breaches) && sc.Sealed == nil {
+			payload.Breaches = sc.Breaches[len(before.Breaches):]
+		}
+	}
+	return setEvent(stub, name, payload)
+}
+
+func setEvent(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+
+	buff, err := json.Marshal(payload)
+	if err != nil {
+		return wrapError(ERR_INTERNAL, "setEvent() : Cannot create event payload", err)
+	}
+	if err = stub.SetEvent(name, buff); err != nil {
+		return errors.New("setEvent() : Cannot set event " + name + " : " + err.Error())
+	}
+	return nil
+}
+
+// emitMigrationEvent sets the event listing the records a migration rewrote
+func emitMigrationEvent(stub shim.ChaincodeStubInterface, objectType string, objectIDs []string) error {
+
+	payload := MigrationEvent{stub.GetTxID(), getFunction(stub), getActor(stub), objectType, objectIDs}
+	return setEvent(stub, EVENT_RECORDS_MIGRATED, payload)
+}
+
+// emitDocumentEvent sets the event describing a document attached to a contract
+func emitDocumentEvent(stub shim.ChaincodeStubInterface, doc DocumentRecord) error {
+
+	payload := DocumentEvent{stub.GetTxID(), getFunction(stub), getActor(stub), doc}
+	return setEvent(stub, EVENT_DOCUMENT_ATTACHED, payload)
+}
+
+// emitCheckpointEvent sets the event describing a checkpoint of a shipment
+func emitCheckpointEvent(stub shim.ChaincodeStubInterface, cp Checkpoint) error {
+
+	payload := CheckpointEvent{stub.GetTxID(), getFunction(stub), getActor(stub), cp}
+	return setEvent(stub, EVENT_CHECKPOINT_RECORDED, payload)
+}
+
+// emitPostingEvent sets the event listing the money a transaction moved
+func emitPostingEvent(stub shim.ChaincodeStubInterface, name string, postings []Posting) error {
+
+	payload := PostingEvent{stub.GetTxID(), getFunction(stub), getActor(stub), postings}
+	return setEvent(stub, name, payload)
+}
+
+// emitAssetRecordEvent sets the event describing a record added to an asset
+func emitAssetRecordEvent(stub shim.ChaincodeStubInterface, name string, rec AssetRecord) error {
+
+	payload := AssetRecordEvent{stub.GetTxID(), getFunction(stub), getActor(stub), rec}
+	return setEvent(stub, name, payload)
+}
+
+// emitPartEvent sets the event describing a change of a part of the catalog
+func emitPartEvent(stub shim.ChaincodeStubInterface, name string, part PartObject) error {
+
+	payload := PartEvent{stub.GetTxID(), getFunction(stub), getActor(stub), part}
+	return setEvent(stub, name, payload)
+}
+
+// emitRecallEvent sets the event describing a recall campaign and the assets it flagged
+func emitRecallEvent(stub shim.ChaincodeStubInterface, campaign RecallCampaign) error {
+
+	payload := RecallEvent{stub.GetTxID(), getFunction(stub), getActor(stub), campaign}
+	return setEvent(stub, EVENT_RECALL_LAUNCHED, payload)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 History - every change to an asset or a contract appends a HistoryRecord to the ledger. Records are never
+//			   overwritten, they are keyed by object type, object ID, transaction time and transaction ID.
+//==============================================================================================================================
+const HISTORY_ASSET = "asset"
+const HISTORY_CONTRACT = "contract"
+
+const HISTORY_PAGE_SIZE = 50
+const HISTORY_MAX_PAGE_SIZE = 500
+
+// HistoryRecord struct
+type HistoryRecord struct {
+	ObjectType string
+	ObjectID   string
+	TxID       string
+	TimeStamp  string // transaction time in RFC 3339
+	Actor      string
+	Function   string
+	Before     json.RawMessage // null when the object was created
+	After      json.RawMessage
+}
+
+// HistoryPage struct - the response of getHistory
+type HistoryPage struct {
+	Records  []HistoryRecord
+	Bookmark string // pass back to getHistory to read the next page, empty on the last page
+}
+
+// writeHistory appends a record of the change of an object from before to after, made by actor
+func writeHistory(stub shim.ChaincodeStubInterface, objectType string, objectID string, actor string, before []byte, after []byte) error {
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+
+	record := HistoryRecord{objectType, objectID, stub.GetTxID(), txTime.Format(TIME_FORMAT), actor, getFunction(stub), before, after}
+	if record.Before == nil {
+		record.Before = json.RawMessage("null")
+	}
+
+	buff, err := json.Marshal(record)
+	if err != nil {
+		return wrapError(ERR_INTERNAL, "writeHistory() : Cannot create history record", err)
+	}
+
+	// a transaction may change the same object more than once, keep every change
+	txSeq := fmt.Sprintf("%020d", txTime.UnixNano())
+	for seq := 0; ; seq++ {
+		key, err := createCompositeKey(HISTORY_OBJECT, []string{objectType, objectID, txSeq, stub.GetTxID(), fmt.Sprintf("%04d", seq)})
+		if err != nil {
+			return wrapError(ERR_INTERNAL, "writeHistory() : Cannot create history key", err)
+		}
+		existing, err := stub.GetState(key)
+		if err != nil {
+			return wrapError(ERR_INTERNAL, "writeHistory() : Failed to read history", err)
+		}
+		if existing != nil {
+			continue
+		}
+		if err = stub.PutState(key, buff); err != nil {
+			return wrapError(ERR_INTERNAL, "writeHistory() : write error while inserting record", err)
+		}
+		return nil
+	}
+}
+
+// HistoryRequest struct - the arguments of getHistory
+type HistoryRequest struct {
+	ObjectType string `validate:"required,enum=objectType"`
+	ObjectID   string `validate:"required"`
+	PageSize   int    `validate:"min=1,max=500"` // HISTORY_PAGE_SIZE when left out, at most HISTORY_MAX_PAGE_SIZE
+	Bookmark   string
+}
+
+// getHistory returns the change history of an asset or a contract, oldest change first.
+// args: objectType (asset|contract), objectID, [pageSize], [bookmark]
+func (t *SimpleChaincode) getHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req HistoryRequest
+	if len(args) > 0 && !namedForm(args) { // the positional form takes the object type in any case
+		args = append([]string{strings.ToLower(args[0])}, args[1:]...)
+	}
+	if err := decodeRequest("getHistory", args, &req); err != nil {
+		return nil, err
+	}
+	objectType, objectID := req.ObjectType, req.ObjectID
+	if err := t.check_history_reader(stub, objectType, objectID); err != nil {
+		return nil, err
+	}
+
+	pageSize := HISTORY_PAGE_SIZE
+	if req.PageSize != 0 {
+		pageSize = req.PageSize
+	}
+
+	startKey, endKey, err := compositeKeyRange(HISTORY_OBJECT, []string{objectType, objectID})
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getHistory()", err)
+	}
+
+	var after string
+	if req.Bookmark != "" {
+		after, err = decodeBookmark(req.Bookmark)
+		if err != nil || after < startKey || after > endKey {
+			return nil, invalidArgument("Bookmark", "getHistory() : invalid bookmark")
+		}
+		startKey = after
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getHistory() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	// the iterator returns the keys in order, which is the order of the changes. The page stops at the record
+	// after its last one, that record tells there is a next page.
+	var page HistoryPage
+	page.Records = []HistoryRecord{}
+	var last string
+	for keysIter.HasNext() {
+		key, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "getHistory() : Error accessing state", iterErr)
+		}
+		if key <= after {
+			continue
+		}
+		if len(page.Records) == pageSize {
+			page.Bookmark = encodeBookmark(last)
+			break
+		}
+		var record HistoryRecord
+		if err = json.Unmarshal(value, &record); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "getHistory() : Failed to decode history record", err)
+		}
+		page.Records = append(page.Records, record)
+		last = key
+	}
+
+	return json.Marshal(page)
+}
+
+// encodeBookmark turns a ledger key into an opaque token that can be handed to clients
+func encodeBookmark(key string) string {
+	return base64.URLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeBookmark returns the ledger key behind a token created by encodeBookmark
+func decodeBookmark(bookmark string) (string, error) {
+	key, err := base64.URLEncoding.DecodeString(bookmark)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
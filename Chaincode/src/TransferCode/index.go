@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Indexes - secondary indexes are composite keys made of the index name, the indexed value and the ID of the
+//			   object, stored with an empty value. They are kept up to date by save_asset and save_changes. Time
+//			   indexes hold RFC 3339 UTC times, which sort in time order, so a time window is a single key range.
+//==============================================================================================================================
+const INDEX_ASSET_OWNER = "AssetByOwner"
+const INDEX_ASSET_PARTNO = "AssetByPartno"
+const INDEX_CONTRACT_BUYER = "ContractByBuyer"
+const INDEX_CONTRACT_SELLER = "ContractBySeller"
+const INDEX_CONTRACT_TRANSPORTER = "ContractByTransporter"
+const INDEX_CONTRACT_STAGE = "ContractByStage"
+const INDEX_CONTRACT_ASSET = "ContractByAsset"
+const INDEX_CONTRACT_CREATED = "ContractByCreated"
+const INDEX_CONTRACT_UPDATED = "ContractByUpdated"
+const INDEX_CONTRACT_BREACH = "ContractByBreachParty"
+const INDEX_POSTING_ACCOUNT = "PostingByAccount"
+const INDEX_POSTING_CONTRACT = "PostingByContract"
+const INDEX_CONTRACT_STAGE_TIME = "ContractByStageTime" // followed by the stage number, one index per stage
+
+// Times listContractsByTime can filter on, besides a stage number
+const TIME_CREATED = "created"
+const TIME_UPDATED = "updated"
+
+var indexValue = []byte{0x00}
+
+// indexEntry struct - an object is listed in the index under the value
+type indexEntry struct {
+	indexName string
+	value     string
+}
+
+// assetIndexEntries returns every index entry of an asset
+func assetIndexEntries(ast AssetObject) []indexEntry {
+	return []indexEntry{
+		{INDEX_ASSET_OWNER, ast.Owner},
+		{INDEX_ASSET_PARTNO, ast.Partno},
+	}
+}
+
+// contractIndexEntries returns every index entry of a contract, which is listed under each of its assets
+func contractIndexEntries(sc SalesContractObject) []indexEntry {
+
+	entries := []indexEntry{
+		{INDEX_CONTRACT_STAGE, strconv.Itoa(sc.Stage)},
+		{INDEX_CONTRACT_CREATED, sc.CreatedAt},
+		{INDEX_CONTRACT_UPDATED, sc.UpdatedAt},
+	}
+	// a confidential contract is not listed under its parties, see confidential.go
+	if sc.Sealed == nil {
+		entries = append(entries,
+			indexEntry{INDEX_CONTRACT_BUYER, sc.Buyer},
+			indexEntry{INDEX_CONTRACT_SELLER, sc.Seller},
+			indexEntry{INDEX_CONTRACT_TRANSPORTER, sc.Transporter})
+		entries = append(entries, breachIndexEntries(sc)...)
+	}
+	for _, assetID := range sc.assetIDs() {
+		entries = append(entries, indexEntry{INDEX_CONTRACT_ASSET, assetID})
+	}
+	stages := []int{}
+	for stage := range sc.StageTimes {
+		stages = append(stages, stage)
+	}
+	sort.Ints(stages)
+	for _, stage := range stages {
+		entries = append(entries, indexEntry{stageTimeIndex(stage), sc.StageTimes[stage]})
+	}
+	return entries
+}
+
+// stageTimeIndex returns the name of the index holding the time contracts entered the given stage
+func stageTimeIndex(stage int) string {
+	return INDEX_CONTRACT_STAGE_TIME + strconv.Itoa(stage)
+}
+
+// updateIndexes removes the index entries an object no longer has and adds its new ones.
+// before is nil for an object that is saved for the first time.
+func updateIndexes(stub shim.ChaincodeStubInterface, objectID string, before []indexEntry, after []indexEntry) error {
+
+	kept := make(map[indexEntry]bool)
+	for _, entry := range after {
+		kept[entry] = true
+	}
+	listed := make(map[indexEntry]bool)
+	for _, entry := range before {
+		listed[entry] = true
+		if kept[entry] {
+			continue
+		}
+		oldKey, err := createCompositeKey(entry.indexName, []string{entry.value, objectID})
+		if err != nil {
+			return err
+		}
+		if err = stub.DelState(oldKey); err != nil {
+			return wrapError(ERR_INTERNAL, "updateIndexes() : Error removing index entry", err)
+		}
+	}
+	for _, entry := range after {
+		if listed[entry] {
+			continue
+		}
+		listed[entry] = true
+		newKey, err := createCompositeKey(entry.indexName, []string{entry.value, objectID})
+		if err != nil {
+			return err
+		}
+		if err = stub.PutState(newKey, indexValue); err != nil {
+			return wrapError(ERR_INTERNAL, "updateIndexes() : Error storing index entry", err)
+		}
+	}
+	return nil
+}
+
+// getIndexedIDs returns the sorted IDs of the objects listed in an index under the given value
+func getIndexedIDs(stub shim.ChaincodeStubInterface, indexName string, value string) ([]string, error) {
+
+	startKey, endKey, err := compositeKeyRange(indexName, []string{value})
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getIndexedIDs() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	ids := []string{}
+	for keysIter.HasNext() {
+		key, _, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "getIndexedIDs() : Error accessing state", iterErr)
+		}
+		_, attributes, err := splitCompositeKey(key)
+		if err != nil || len(attributes) != 2 || attributes[0] != value {
+			continue
+		}
+		ids = append(ids, attributes[1])
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// getIndexedIDsBetween returns the IDs of the objects listed in an index under a value between from and to,
+// both inclusive, ordered by value. An empty bound leaves that end of the range open.
+func getIndexedIDsBetween(stub shim.ChaincodeStubInterface, indexName string, from string, to string) ([]string, error) {
+
+	startKey, endKey, err := windowRange(indexName, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getIndexedIDsBetween() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	var keys []string
+	for keysIter.HasNext() {
+		key, _, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "getIndexedIDsBetween() : Error accessing state", iterErr)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	ids := []string{}
+	for _, key := range keys {
+		_, attributes, err := splitCompositeKey(key)
+		if err != nil || len(attributes) != 2 || attributes[0] == "" {
+			continue
+		}
+		ids = append(ids, attributes[1])
+	}
+	return ids, nil
+}
+
+// windowRange returns the start and end key (both inclusive) of the entries of an index listed under a value
+// between from and to. An empty bound leaves that end of the range open.
+func windowRange(indexName string, from string, to string) (string, string, error) {
+
+	startKey, err := createCompositeKey(indexName, []string{from})
+	if err != nil {
+		return "", "", err
+	}
+	var endKey string
+	if to == "" {
+		_, endKey, err = compositeKeyRange(indexName, nil)
+	} else {
+		_, endKey, err = compositeKeyRange(indexName, []string{to})
+	}
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, endKey, nil
+}
+
+// IndexRequest struct - the arguments of the lookups of an index, e.g. listAssetsByOwner
+type IndexRequest struct {
+	Value string `validate:"required"` // the owner, part number, party, stage or asset to look up
+}
+
+// TimeWindowRequest struct - the arguments of listContractsByTime
+type TimeWindowRequest struct {
+	Filter string `validate:"required"` // TIME_CREATED, TIME_UPDATED or a stage number
+	From   string // RFC 3339, empty for a window open at the start
+	To     string // RFC 3339, empty for a window open at the end
+}
+
+// listAssetsByIndex returns every asset the caller may read listed in the index under the value passed in args
+// args: value or {"Value":"bosch"}
+func (t *SimpleChaincode) listAssetsByIndex(stub shim.ChaincodeStubInterface, indexName string, args []string) ([]byte, error) {
+
+	var req IndexRequest
+	if err := decodeRequest(getFunction(stub), args, &req); err != nil {
+		return nil, err
+	}
+
+	serialNos, err := getIndexedIDs(stub, indexName, req.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	r := t.get_reader(stub)
+	assets := []AssetObject{}
+	for _, serialNo := range serialNos {
+		ast, err := getAssetObject(stub, serialNo)
+		if err != nil {
+			return nil, err
+		}
+		if r.canReadAsset(stub, ast) {
+			assets = append(assets, ast)
+		}
+	}
+	return json.Marshal(assets)
+}
+
+// listContractsByIndex returns every contract the caller may read listed in the index under the value passed in args
+// args: value or {"Value":"lht"}
+func (t *SimpleChaincode) listContractsByIndex(stub shim.ChaincodeStubInterface, indexName string, args []string) ([]byte, error) {
+
+	var req IndexRequest
+	if err := decodeRequest(getFunction(stub), args, &req); err != nil {
+		return nil, err
+	}
+
+	contractIDs, err := getIndexedIDs(stub, indexName, req.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	r := t.get_reader(stub)
+	contracts := []SalesContractObject{}
+	for _, contractID := range contractIDs {
+		sc, err := getContractObject(stub, contractID)
+		if err != nil {
+			return nil, err
+		}
+		if r.canReadContract(sc) {
+			contracts = append(contracts, sc)
+		}
+	}
+	return json.Marshal(contracts)
+}
+
+// listContractsByTime returns the contracts the caller may read created, last updated or entering a stage within
+// a time window, oldest first.
+// args: created|updated|stage number, [from], [to] - times in RFC 3339, an empty time leaves the window open
+func (t *SimpleChaincode) listContractsByTime(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req TimeWindowRequest
+	if err := decodeRequest("listContractsByTime", args, &req); err != nil {
+		return nil, err
+	}
+
+	var indexName string
+	switch req.Filter {
+	case TIME_CREATED:
+		indexName = INDEX_CONTRACT_CREATED
+	case TIME_UPDATED:
+		indexName = INDEX_CONTRACT_UPDATED
+	default:
+		stage, err := strconv.Atoi(req.Filter)
+		if err != nil || stage < STATE_OPEN || stage > STATE_RETURNED_TO_SELLER {
+			return nil, invalidArgument("Filter", "listContractsByTime() : should filter on "+TIME_CREATED+", "+TIME_UPDATED+" or a stage, got "+req.Filter)
+		}
+		indexName = stageTimeIndex(stage)
+	}
+
+	var window []string
+	for _, arg := range []string{req.From, req.To} {
+		bound, err := parseTime(arg)
+		if err != nil {
+			return nil, wrapError(ERR_INVALID_ARGUMENT, "listContractsByTime()", err)
+		}
+		window = append(window, bound)
+	}
+
+	contractIDs, err := getIndexedIDsBetween(stub, indexName, window[0], window[1])
+	if err != nil {
+		return nil, err
+	}
+
+	r := t.get_reader(stub)
+	contracts := []SalesContractObject{}
+	for _, contractID := range contractIDs {
+		sc, err := getContractObject(stub, contractID)
+		if err != nil {
+			return nil, err
+		}
+		if r.canReadContract(sc) {
+			contracts = append(contracts, sc)
+		}
+	}
+	return json.Marshal(contracts)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//==============================================================================================================================
+//	 Composite keys - a key is made of an object type followed by its attributes, each one terminated by the
+//					  minimum unicode rune. All keys sharing an object type (and leading attributes) therefore sit in
+//					  one contiguous range that can be scanned with RangeQueryState.
+//==============================================================================================================================
+const minUnicodeRuneValue = 0
+const maxUnicodeRuneValue = utf8.MaxRune
+
+const compositeKeySeparator = string(rune(minUnicodeRuneValue))
+
+// Object types used as the first component of composite keys, each entity kind has its own namespace
+const ASSET_OBJECT = "Asset"
+const CONTRACT_OBJECT = "Contract"
+const HISTORY_OBJECT = "History"
+const DOCUMENT_OBJECT = "Document"
+const CHECKPOINT_OBJECT = "Checkpoint"
+const ACCOUNT_OBJECT = "Account"
+const ESCROW_OBJECT = "Escrow"
+const POSTING_OBJECT = "Posting"
+const CONFIG_OBJECT = "Config"
+const ASSET_RECORD_OBJECT = "AssetRecord"
+const PART_OBJECT = "Part"
+const RECALL_OBJECT = "Recall"
+const ASSET_LOCK_OBJECT = "AssetLock"
+
+// createCompositeKey builds a composite key from an object type and its attributes
+func createCompositeKey(objectType string, attributes []string) (string, error) {
+
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	key := objectType + compositeKeySeparator
+	for _, att := range attributes {
+		if err := validateCompositeKeyAttribute(att); err != nil {
+			return "", err
+		}
+		key += att + compositeKeySeparator
+	}
+	return key, nil
+}
+
+// getAssetKey returns the ledger key of the asset with the given serial number
+func getAssetKey(serialNo string) (string, error) {
+	return createCompositeKey(ASSET_OBJECT, []string{serialNo})
+}
+
+// getContractKey returns the ledger key of the contract with the given ID
+func getContractKey(contractID string) (string, error) {
+	return createCompositeKey(CONTRACT_OBJECT, []string{contractID})
+}
+
+// getDocumentKey returns the ledger key of a document attached to a contract
+func getDocumentKey(contractID string, documentID string) (string, error) {
+	return createCompositeKey(DOCUMENT_OBJECT, []string{contractID, documentID})
+}
+
+// getCheckpointKey returns the ledger key of a checkpoint of a contract, zero padded so keys sort by sequence
+func getCheckpointKey(contractID string, seq int) (string, error) {
+	return createCompositeKey(CHECKPOINT_OBJECT, []string{contractID, fmt.Sprintf("%010d", seq)})
+}
+
+// getAssetRecordKey returns the ledger key of a maintenance, inspection or status record of an asset, zero
+// padded so keys sort by sequence
+func getAssetRecordKey(serialNo string, seq int) (string, error) {
+	return createCompositeKey(ASSET_RECORD_OBJECT, []string{serialNo, fmt.Sprintf("%010d", seq)})
+}
+
+// getPartKey returns the ledger key of a part number of the catalog
+func getPartKey(partno string) (string, error) {
+	return createCompositeKey(PART_OBJECT, []string{partno})
+}
+
+// getRecallKey returns the ledger key of a recall campaign
+func getRecallKey(campaignID string) (string, error) {
+	return createCompositeKey(RECALL_OBJECT, []string{campaignID})
+}
+
+// getConfigKey returns the ledger key of the names of the peer chaincodes, see crosschain.go
+func getConfigKey() (string, error) {
+	return createCompositeKey(CONFIG_OBJECT, []string{"chaincodes"})
+}
+
+// splitCompositeKey returns the object type and the attributes a composite key was built from
+func splitCompositeKey(compositeKey string) (string, []string, error) {
+
+	if !strings.HasSuffix(compositeKey, compositeKeySeparator) {
+		return "", nil, errors.New("splitCompositeKey() : not a composite key : " + compositeKey)
+	}
+	components := strings.Split(strings.TrimSuffix(compositeKey, compositeKeySeparator), compositeKeySeparator)
+	return components[0], components[1:], nil
+}
+
+// compositeKeyRange returns the start and end key (both inclusive) of the range holding every composite key
+// that begins with the given object type and attributes
+func compositeKeyRange(objectType string, attributes []string) (string, string, error) {
+
+	startKey, err := createCompositeKey(objectType, attributes)
+	if err != nil {
+		return "", "", err
+	}
+	return startKey, startKey + string(rune(maxUnicodeRuneValue)), nil
+}
+
+func validateCompositeKeyAttribute(str string) error {
+
+	if !utf8.ValidString(str) {
+		return invalidArgument("", "not a valid utf8 string : "+str)
+	}
+	if strings.Contains(str, compositeKeySeparator) {
+		return invalidArgument("", "key component must not contain the key separator : "+str)
+	}
+	return nil
+}
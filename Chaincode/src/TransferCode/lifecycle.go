@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Asset lifecycle - besides its owner an asset has a lifecycle status. A part is active in stock, in service once
+//					   fitted, quarantined when an inspection finds a fault, recalled by an inspector and scrapped at
+//					   the end of its life. Certified inspectors record the maintenance and inspections of an asset,
+//					   a failed inspection quarantines it and a passed one releases it from quarantine. Records are
+//					   numbered per asset and never overwritten, each one names the inspector whose certificate
+//					   signed the transaction, so a buyer reads the provenance of a part with getAssetRecords before
+//					   accepting it. An asset that is quarantined, recalled or scrapped can't be sold.
+//==============================================================================================================================
+const INSPECTOR = "inspector"
+
+// OWNER allows the owner of the asset to make a status change, whatever its role
+const OWNER = "owner"
+
+const ASSET_ACTIVE = "active"
+const ASSET_IN_SERVICE = "inService"
+const ASSET_QUARANTINED = "quarantined"
+const ASSET_RECALLED = "recalled"
+const ASSET_SCRAPPED = "scrapped"
+
+var ASSET_STATUSES = map[string]bool{ASSET_ACTIVE: true, ASSET_IN_SERVICE: true, ASSET_QUARANTINED: true, ASSET_RECALLED: true, ASSET_SCRAPPED: true}
+
+// Statuses an asset can't be committed to a contract in
+var ASSET_UNSALEABLE = map[string]bool{ASSET_QUARANTINED: true, ASSET_RECALLED: true, ASSET_SCRAPPED: true}
+
+const RECORD_MAINTENANCE = "maintenance"
+const RECORD_INSPECTION = "inspection"
+const RECORD_STATUS = "statusChange"
+const RECORD_RECALL = "recall"
+
+const INSPECTION_PASSED = "passed"
+const INSPECTION_FAILED = "failed"
+
+// AssetRecord struct
+type AssetRecord struct {
+	Serialno     string
+	Seq          int    // 1 for the first record of the asset
+	Kind         string // RECORD_MAINTENANCE, RECORD_INSPECTION, RECORD_STATUS or RECORD_RECALL
+	Result       string // INSPECTION_PASSED or INSPECTION_FAILED, set on an inspection
+	Description  string // the work done, the findings of an inspection or the reason of a status change
+	WorkOrder    string // reference of the job off the ledger
+	DocumentHash string // SHA-256 of the report, lower case hex
+	OldStatus    string
+	NewStatus    string // OldStatus when the record left the status alone
+	CampaignID   string // the recall campaign of a recall record, see recall.go
+	PerformedAt  string // time the work was done on site, in RFC 3339
+	TimeStamp    string // transaction time in RFC 3339
+	Recorder     string
+	RecorderRole string
+	TxID         string
+}
+
+// AssetStatusChange struct - a row of the status changes setAssetStatus makes
+type AssetStatusChange struct {
+	From []string
+	To   string
+	Role string // INSPECTOR or OWNER
+}
+
+var assetStatusChanges = []AssetStatusChange{
+	{From: []string{ASSET_ACTIVE}, To: ASSET_IN_SERVICE, Role: OWNER},
+	{From: []string{ASSET_IN_SERVICE}, To: ASSET_ACTIVE, Role: OWNER},
+	{From: []string{ASSET_ACTIVE, ASSET_IN_SERVICE}, To: ASSET_QUARANTINED, Role: INSPECTOR},
+	{From: []string{ASSET_QUARANTINED, ASSET_RECALLED}, To: ASSET_ACTIVE, Role: INSPECTOR},
+	{From: []string{ASSET_ACTIVE, ASSET_IN_SERVICE, ASSET_QUARANTINED}, To: ASSET_RECALLED, Role: INSPECTOR},
+	{From: []string{ASSET_ACTIVE, ASSET_IN_SERVICE, ASSET_QUARANTINED, ASSET_RECALLED}, To: ASSET_SCRAPPED, Role: INSPECTOR},
+}
+
+// AssetProvenance struct - the response of getAssetRecords
+type AssetProvenance struct {
+	Serialno string
+	Partno   string
+	Owner    string
+	Status   string
+	Records  []AssetRecord
+}
+
+// MaintenanceRequest struct - the arguments of recordMaintenance
+type MaintenanceRequest struct {
+	Serialno     string `validate:"required"`
+	Description  string `validate:"required,length=1-1000"`
+	WorkOrder    string
+	DocumentHash string `validate:"pattern=sha256"`
+	PerformedAt  string
+}
+
+// InspectionRequest struct - the arguments of recordInspection, the findings are required when it failed
+type InspectionRequest struct {
+	Serialno     string `validate:"required"`
+	Result       string `validate:"required,enum=inspectionResult"`
+	Description  string `validate:"length=0-1000"`
+	WorkOrder    string
+	DocumentHash string `validate:"pattern=sha256"`
+	PerformedAt  string
+}
+
+// AssetStatusRequest struct - the arguments of setAssetStatus
+type AssetStatusRequest struct {
+	Serialno string `validate:"required"`
+	Status   string `validate:"required,enum=assetStatus"`
+	Reason   string `validate:"required,length=1-1000"`
+}
+
+// recordMaintenance records work done on an asset. Only inspectors record maintenance.
+// args: serialno, description, [workOrder], [SHA-256 of the report], [performedAt in RFC 3339]
+func (t *SimpleChaincode) recordMaintenance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req MaintenanceRequest
+	if err := decodeRequest("recordMaintenance", args, &req); err != nil {
+		return nil, err
+	}
+	rec := AssetRecord{Kind: RECORD_MAINTENANCE, Description: req.Description, WorkOrder: req.WorkOrder, DocumentHash: req.DocumentHash, PerformedAt: req.PerformedAt}
+	return nil, t.inspect_asset(stub, "recordMaintenance", req.Serialno, rec)
+}
+
+// recordInspection records the outcome of an inspection of an asset. A failed inspection quarantines an active
+// or in service asset, a passed one releases a quarantined asset. Only inspectors record inspections.
+// args: serialno, result (passed|failed), [findings], [workOrder], [SHA-256 of the report], [performedAt in RFC 3339]
+func (t *SimpleChaincode) recordInspection(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req InspectionRequest
+	if err := decodeRequest("recordInspection", args, &req); err != nil {
+		return nil, err
+	}
+	if req.Result == INSPECTION_FAILED {
+		if err := validateRequest("recordInspection", &req, "Description"); err != nil {
+			return nil, err
+		}
+	}
+	rec := AssetRecord{Kind: RECORD_INSPECTION, Result: req.Result, Description: req.Description, WorkOrder: req.WorkOrder, DocumentHash: req.DocumentHash, PerformedAt: req.PerformedAt}
+	return nil, t.inspect_asset(stub, "recordInspection", req.Serialno, rec)
+}
+
+// inspect_asset - Checks the caller is an inspector and adds a maintenance or inspection record to an asset that
+//				   was not scrapped
+func (t *SimpleChaincode) inspect_asset(stub shim.ChaincodeStubInterface, function string, serialNo string, rec AssetRecord) error {
+
+	performedAt, err := parseTime(rec.PerformedAt)
+	if err != nil {
+		return invalidArgument("PerformedAt", function+"() : "+err.Error())
+	}
+	rec.PerformedAt = performedAt
+
+	ast, err := getAssetObject(stub, serialNo)
+	if err != nil {
+		return err
+	}
+	if _, err = t.check_role(stub, INSPECTOR); err != nil {
+		logFor(stub, serialNo).debug("caller may not inspect the asset", "reason", err)
+		return permissionDenied(function)
+	}
+	if ast.Status == ASSET_SCRAPPED {
+		return conflict(serialNo, "asset "+serialNo+" is scrapped")
+	}
+
+	status := ast.Status
+	if rec.Result == INSPECTION_FAILED && (status == ASSET_ACTIVE || status == ASSET_IN_SERVICE) {
+		status = ASSET_QUARANTINED
+	} else if rec.Result == INSPECTION_PASSED && status == ASSET_QUARANTINED {
+		status = ASSET_ACTIVE
+	}
+	return t.add_asset_record(stub, ast, rec, status)
+}
+
+// setAssetStatus changes the lifecycle status of an asset along a row of assetStatusChanges. The owner puts an
+// asset in and out of service, inspectors quarantine, recall, release and scrap it. A locked asset can't be scrapped.
+// args: serialno, status (active|inService|quarantined|recalled|scrapped), reason
+func (t *SimpleChaincode) setAssetStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req AssetStatusRequest
+	if err := decodeRequest("setAssetStatus", args, &req); err != nil {
+		return nil, err
+	}
+
+	ast, err := getAssetObject(stub, req.Serialno)
+	if err != nil {
+		return nil, err
+	}
+	change, ok := getAssetStatusChange(ast.Status, req.Status)
+	if !ok {
+		return nil, conflict(ast.Serialno, "asset "+ast.Serialno+" can't go from "+ast.Status+" to "+req.Status)
+	}
+	if err = t.check_status_caller(stub, change, ast); err != nil {
+		logFor(stub, ast.Serialno).debug("caller may not change the status", "reason", err)
+		return nil, permissionDenied("setAssetStatus")
+	}
+	if req.Status == ASSET_SCRAPPED && ast.Contractid != "" {
+		return nil, conflict(ast.Serialno, "asset "+ast.Serialno+" is locked by contract "+ast.Contractid)
+	}
+
+	rec := AssetRecord{Kind: RECORD_STATUS, Description: req.Reason}
+	return nil, t.add_asset_record(stub, ast, rec, req.Status)
+}
+
+// getAssetStatusChange returns the row of assetStatusChanges leading from one status to another
+func getAssetStatusChange(from string, to string) (AssetStatusChange, bool) {
+	for _, change := range assetStatusChanges {
+		if change.To == to && contains(change.From, from) {
+			return change, true
+		}
+	}
+	return AssetStatusChange{}, false
+}
+
+// check_status_caller - Verifies the caller holds the role a status change requires on the asset
+func (t *SimpleChaincode) check_status_caller(stub shim.ChaincodeStubInterface, change AssetStatusChange, ast AssetObject) error {
+
+	if change.Role == OWNER {
+		caller, _, err := t.get_caller_data(stub)
+		if err != nil {
+			return err
+		}
+		if caller != ast.Owner {
+			return errors.New("caller " + caller + " is not the owner of the asset " + ast.Serialno)
+		}
+		return nil
+	}
+	_, err := t.check_role(stub, change.Role)
+	return err
+}
+
+// add_asset_record - Writes the next record of an asset and sets the event describing it
+func (t *SimpleChaincode) add_asset_record(stub shim.ChaincodeStubInterface, ast AssetObject, rec AssetRecord, status string) error {
+
+	rec, err := t.put_asset_record(stub, ast, rec, status)
+	if err != nil {
+		return err
+	}
+	name := EVENT_ASSET_RECORDED
+	if rec.NewStatus != rec.OldStatus {
+		name = EVENT_ASSET_STATUS_CHANGED
+	}
+	if err = emitAssetRecordEvent(stub, name, rec); err != nil {
+		return err
+	}
+	logFor(stub, ast.Serialno).info("asset record added", "kind", rec.Kind, "seq", rec.Seq, "from", rec.OldStatus, "to", rec.NewStatus)
+	return nil
+}
+
+// put_asset_record - Writes the next record of an asset, signed by the caller, and saves the asset in its new
+//					  status when the record changed it. An asset leaving the recalled status is cleared of its
+//					  recall campaign.
+func (t *SimpleChaincode) put_asset_record(stub shim.ChaincodeStubInterface, ast AssetObject, rec AssetRecord, status string) (AssetRecord, error) {
+
+	records, err := getAssetRecords(stub, ast.Serialno)
+	if err != nil {
+		return rec, wrapError(ERR_INTERNAL, "put_asset_record()", err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return rec, err
+	}
+	_, role, err := t.get_caller_data(stub)
+	if err != nil {
+		return rec, wrapError(ERR_INTERNAL, "put_asset_record()", err)
+	}
+
+	rec.Serialno = ast.Serialno
+	rec.Seq = len(records) + 1
+	rec.DocumentHash = strings.ToLower(rec.DocumentHash)
+	rec.OldStatus = ast.Status
+	rec.NewStatus = status
+	rec.TimeStamp = txTime.Format(TIME_FORMAT)
+	rec.Recorder = getActor(stub)
+	rec.RecorderRole = role
+	rec.TxID = stub.GetTxID()
+
+	recordKey, err := getAssetRecordKey(rec.Serialno, rec.Seq)
+	if err != nil {
+		return rec, wrapError(ERR_INTERNAL, "put_asset_record()", err)
+	}
+	buff, err := json.Marshal(rec)
+	if err != nil {
+		return rec, wrapError(ERR_INTERNAL, "put_asset_record() : Cannot create asset record", err)
+	}
+	if err = stub.PutState(recordKey, buff); err != nil {
+		return rec, wrapError(ERR_INTERNAL, "put_asset_record() : write error while inserting record", err)
+	}
+
+	before := ast
+	ast.Status = status
+	if status != ASSET_RECALLED {
+		ast.RecallID = ""
+	} else if rec.CampaignID != "" {
+		ast.RecallID = rec.CampaignID
+	}
+	if ast != before {
+		if _, err = t.save_asset(stub, ast); err != nil {
+			return rec, wrapError(ERR_INTERNAL, "put_asset_record() : write error while updating asset", err)
+		}
+	}
+	return rec, nil
+}
+
+// getAssetRecords returns the status of an asset and its maintenance, inspection and status records in order.
+// args: serialno
+func (t *SimpleChaincode) getAssetRecords(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req SerialnoRequest
+	if err := decodeRequest("getAssetRecords", args, &req); err != nil {
+		return nil, err
+	}
+	ast, err := getAssetObject(stub, req.Serialno)
+	if err != nil {
+		return nil, err
+	}
+	if !t.get_reader(stub).canReadAsset(stub, ast) {
+		logFor(stub, ast.Serialno).debug("caller may not read the asset")
+		return nil, permissionDenied("getAssetRecords")
+	}
+	records, err := getAssetRecords(stub, ast.Serialno)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getAssetRecords()", err)
+	}
+	return json.Marshal(AssetProvenance{ast.Serialno, ast.Partno, ast.Owner, ast.Status, records})
+}
+
+// getAssetRecords reads the records of an asset, in sequence order
+func getAssetRecords(stub shim.ChaincodeStubInterface, serialNo string) ([]AssetRecord, error) {
+
+	startKey, endKey, err := compositeKeyRange(ASSET_RECORD_OBJECT, []string{serialNo})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	records := []AssetRecord{}
+	for keysIter.HasNext() {
+		_, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "Error accessing state", iterErr)
+		}
+		var rec AssetRecord
+		if err = json.Unmarshal(value, &rec); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "invalid asset record", err)
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+	return records, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func getProvenance(t *testing.T, stub *shim.MockStub, c caller, serialNo string) AssetProvenance {
+
+	got, err := query(stub, c, "getAssetRecords", serialNo)
+	if err != nil {
+		t.Fatalf("getAssetRecords failed: %s", err)
+	}
+	var provenance AssetProvenance
+	if err = json.Unmarshal(got, &provenance); err != nil {
+		t.Fatalf("cannot decode provenance %s: %s", got, err)
+	}
+	return provenance
+}
+
+func TestAssetRecords(t *testing.T) {
+
+	stub := newStub(t)
+	hash := strings.Repeat("AB", 32)
+	mustInvoke(t, stub, inspector, "recordMaintenance", "1002", "bearing replaced", "WO-17", hash, "2016-11-01T09:00:00+01:00")
+	var ev AssetRecordEvent
+	checkEvent(t, stub, EVENT_ASSET_RECORDED, &ev)
+	if ev.Record.Seq != 1 || ev.Record.Kind != RECORD_MAINTENANCE || ev.Record.NewStatus != ASSET_ACTIVE || ev.Record.PerformedAt != "2016-11-01T08:00:00Z" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// a failed inspection quarantines the asset, a passed one releases it
+	mustInvoke(t, stub, inspector, "recordInspection", `{"Serialno":"1002","Result":"failed","Description":"crack in the housing"}`)
+	checkEvent(t, stub, EVENT_ASSET_STATUS_CHANGED, &ev)
+	if ast := getAsset(t, stub, "1002"); ast.Status != ASSET_QUARANTINED {
+		t.Fatalf("expected the asset quarantined, got %+v", ast)
+	}
+	_, err := invoke(stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D1")
+	checkCode(t, err, ERR_CONFLICT, "", "1002")
+	checkError(t, err, "asset 1002 is quarantined")
+
+	mustInvoke(t, stub, inspector, "recordInspection", "1002", "passed")
+	if ast := getAsset(t, stub, "1002"); ast.Status != ASSET_ACTIVE {
+		t.Fatalf("expected the asset released, got %+v", ast)
+	}
+	mustInvoke(t, stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D1")
+
+	// the buyer reads the provenance of an asset sold to it
+	provenance := getProvenance(t, stub, lht, "1002")
+	if provenance.Status != ASSET_ACTIVE || len(provenance.Records) != 3 {
+		t.Fatalf("unexpected provenance %+v", provenance)
+	}
+	first, second := provenance.Records[0], provenance.Records[1]
+	if first.DocumentHash != strings.ToLower(hash) || first.Recorder != "tuev" || first.RecorderRole != INSPECTOR || first.TimeStamp != testTime.Format(TIME_FORMAT) {
+		t.Fatalf("unexpected record %+v", first)
+	}
+	if second.Kind != RECORD_INSPECTION || second.Result != INSPECTION_FAILED || second.OldStatus != ASSET_ACTIVE || second.NewStatus != ASSET_QUARANTINED {
+		t.Fatalf("unexpected record %+v", second)
+	}
+	_, err = query(stub, continental, "getAssetRecords", "1002")
+	checkError(t, err, "Permission Denied")
+}
+
+func TestSetAssetStatus(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "setAssetStatus", "1002", ASSET_IN_SERVICE, "fitted to D-AIMA")
+	var ev AssetRecordEvent
+	checkEvent(t, stub, EVENT_ASSET_STATUS_CHANGED, &ev)
+	if ev.Record.Kind != RECORD_STATUS || ev.Record.OldStatus != ASSET_ACTIVE || ev.Record.NewStatus != ASSET_IN_SERVICE || ev.Record.RecorderRole != SELLER {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	mustInvoke(t, stub, inspector, "setAssetStatus", "1002", ASSET_RECALLED, "service bulletin SB-42")
+	_, err := invoke(stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D1")
+	checkError(t, err, "asset 1002 is recalled")
+	mustInvoke(t, stub, inspector, "setAssetStatus", "1002", ASSET_SCRAPPED, "beyond repair")
+
+	tests := []struct {
+		name     string
+		caller   caller
+		serialno string
+		status   string
+		wantErr  string
+	}{
+		{"owner quarantines", bosch, "1001", ASSET_QUARANTINED, "Permission Denied"},
+		{"not the owner", continental, "1001", ASSET_IN_SERVICE, "Permission Denied"},
+		{"inspector puts in service", inspector, "1001", ASSET_IN_SERVICE, "Permission Denied"},
+		{"same status", inspector, "1001", ASSET_ACTIVE, "can't go from active to active"},
+		{"scrapped for good", inspector, "1002", ASSET_ACTIVE, "can't go from scrapped to active"},
+		{"locked", inspector, "1001", ASSET_SCRAPPED, "locked by contract C1"},
+		{"unknown status", inspector, "1001", "lost", "Status should be one of"},
+		{"unknown asset", inspector, "9999", ASSET_RECALLED, "no asset for 9999"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, "setAssetStatus", test.serialno, test.status, "reason")
+			checkError(t, err, test.wantErr)
+		})
+	}
+
+	// a scrapped asset takes no more records
+	_, err = invoke(stub, inspector, "recordMaintenance", "1002", "overhaul")
+	checkCode(t, err, ERR_CONFLICT, "", "1002")
+}
+
+func TestAssetRecordsRejected(t *testing.T) {
+
+	stub := newStub(t)
+	tests := []struct {
+		name     string
+		caller   caller
+		function string
+		args     []string
+		wantErr  string
+	}{
+		{"maintenance by the owner", bosch, "recordMaintenance", []string{"1002", "bearing replaced"}, "Permission Denied"},
+		{"inspection by the owner", bosch, "recordInspection", []string{"1002", "passed"}, "Permission Denied"},
+		{"no description", inspector, "recordMaintenance", []string{"1002", ""}, "Description is required"},
+		{"failed without findings", inspector, "recordInspection", []string{"1002", "failed"}, "Description is required"},
+		{"unknown result", inspector, "recordInspection", []string{"1002", "ok"}, "Result should be one of failed, passed"},
+		{"bad hash", inspector, "recordMaintenance", []string{"1002", "bearing replaced", "", "abc"}, "DocumentHash should be a SHA-256"},
+		{"bad time", inspector, "recordMaintenance", []string{"1002", "bearing replaced", "", "", "yesterday"}, "RFC 3339"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+	if provenance := getProvenance(t, stub, inspector, "1002"); len(provenance.Records) != 0 {
+		t.Fatalf("expected no records, got %+v", provenance.Records)
+	}
+}
+
+func TestLegacyAssetStatus(t *testing.T) {
+
+	// assets written before the lifecycle status are active
+	ast, err := decodeAsset([]byte(`{"Serialno":"1","Owner":"bosch","SchemaVersion":1}`))
+	if err != nil || ast.Status != ASSET_ACTIVE || ast.SchemaVersion != ASSET_SCHEMA_VERSION {
+		t.Fatalf("unexpected asset %+v, %v", ast, err)
+	}
+
+	stub := newStub(t)
+	got, err := query(stub, bosch, "listAssets", `{"Owner":"bosch","Status":"active"}`)
+	if err != nil {
+		t.Fatalf("listAssets failed: %s", err)
+	}
+	var page AssetPage
+	json.Unmarshal(got, &page)
+	if len(page.Assets) != 2 {
+		t.Fatalf("unexpected page %s", got)
+	}
+}
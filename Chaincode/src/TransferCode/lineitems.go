@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Line items - a contract ships one or more line items. A line item is a quantity of a part number at a unit
+//				  price and names the serial numbers of the assets shipped under it, one per unit, when the parts
+//				  are serialized. Prices and amounts are integers in the minor unit of the contract currency, e.g.
+//				  cents for EUR. Each line item is settled on its own: the assets of a line change hands when the
+//				  buyer receives it, the lines not received yet stay pending.
+//==============================================================================================================================
+const MAX_LINE_QUANTITY = 1000000
+
+// Incoterms 2010 rules a contract can be agreed under
+var INCOTERMS = map[string]bool{
+	"EXW": true, "FCA": true, "CPT": true, "CIP": true, "DAT": true, "DAP": true, "DDP": true,
+	"FAS": true, "FOB": true, "CFR": true, "CIF": true,
+}
+
+var currencyCode = regexp.MustCompile("^[A-Z]{3}$")
+
+// LineItem struct
+type LineItem struct {
+	LineNo      int
+	Partno      string
+	AssetIDs    []string // serial numbers of the assets shipped under the line, empty for parts that are not serialized
+	Quantity    int
+	UnitPrice   int64 // in the minor unit of the contract currency
+	Amount      int64 // Quantity * UnitPrice
+	Delivered   bool
+	DeliveredAt string // transaction time the buyer received the line
+	HandOverTo  string // party the asset registry is still to hand the assets of the line to, see handOverAssets
+}
+
+// ContractTerms struct - the JSON object initContract takes to create a contract with line items
+type ContractTerms struct {
+	Contractid     string `validate:"required"`
+	Buyer          string `validate:"required"`
+	Transporter    string `validate:"required"`
+	Seller         string `validate:"required"`
+	DocumentID     string
+	Currency       string `validate:"pattern=currency"` // ISO 4217 code
+	Incoterms      string `validate:"enum=incoterms"`
+	Deadlines      Deadlines
+	TransporterFee int64      `validate:"min=0"`
+	LineItems      []LineItem `validate:"required,length=1-500"` // LineNo, Amount and the delivery fields are set by the chaincode
+}
+
+// CreateContractFromTerms creates an open contract from the terms passed to initContract, once decodeRequest has
+// validated them
+func CreateContractFromTerms(ct ContractTerms) (SalesContractObject, error) {
+
+	if err := ct.Deadlines.normalize(); err != nil {
+		return SalesContractObject{}, wrapError(ERR_INVALID_ARGUMENT, "CreateContractFromTerms()", err)
+	}
+
+	sc := SalesContractObject{
+		Contractid:     ct.Contractid,
+		Stage:          STATE_OPEN,
+		Buyer:          ct.Buyer,
+		Transporter:    ct.Transporter,
+		Seller:         ct.Seller,
+		DocumentID:     ct.DocumentID,
+		Currency:       ct.Currency,
+		Incoterms:      ct.Incoterms,
+		Deadlines:      ct.Deadlines,
+		TransporterFee: ct.TransporterFee,
+		SchemaVersion:  CONTRACT_SCHEMA_VERSION,
+	}
+	for i, line := range ct.LineItems {
+		item, err := newLineItem(i+1, line.Partno, line.AssetIDs, line.Quantity, line.UnitPrice)
+		if err != nil {
+			return SalesContractObject{}, wrapError(ERR_INVALID_ARGUMENT, "CreateContractFromTerms()", err)
+		}
+		if sc.Total > math.MaxInt64-item.Amount {
+			return SalesContractObject{}, invalidArgument("LineItems", "CreateContractFromTerms(): the contract total is too large")
+		}
+		sc.Total += item.Amount
+		sc.LineItems = append(sc.LineItems, item)
+	}
+	if sc.Total > math.MaxInt64-ct.TransporterFee {
+		return SalesContractObject{}, invalidArgument("TransporterFee", "CreateContractFromTerms(): TransporterFee should leave the contract total in range")
+	}
+	if sc.Currency == "" && sc.Total+sc.TransporterFee != 0 {
+		return SalesContractObject{}, invalidArgument("Currency", "CreateContractFromTerms(): Currency is required for a priced contract")
+	}
+	return sc, nil
+}
+
+// newLineItem validates the figures of a line item and computes its amount. A line of serialized parts has a
+// quantity of one per asset, the quantity may be left out.
+func newLineItem(lineNo int, partno string, assetIDs []string, quantity int, unitPrice int64) (LineItem, error) {
+
+	if len(assetIDs) > 0 && quantity == 0 {
+		quantity = len(assetIDs)
+	}
+	if quantity <= 0 || quantity > MAX_LINE_QUANTITY {
+		return LineItem{}, fmt.Errorf("line %d : Quantity should be between 1 and %d", lineNo, MAX_LINE_QUANTITY)
+	}
+	if len(assetIDs) > 0 && len(assetIDs) != quantity {
+		return LineItem{}, fmt.Errorf("line %d : Quantity %d does not match the %d assets of the line", lineNo, quantity, len(assetIDs))
+	}
+	if len(assetIDs) == 0 && partno == "" {
+		return LineItem{}, fmt.Errorf("line %d : Partno is required for a line without assets", lineNo)
+	}
+	if unitPrice < 0 || unitPrice > math.MaxInt64/int64(quantity) {
+		return LineItem{}, fmt.Errorf("line %d : UnitPrice should be between 0 and %d", lineNo, math.MaxInt64/int64(quantity))
+	}
+	return LineItem{LineNo: lineNo, Partno: partno, AssetIDs: assetIDs, Quantity: quantity, UnitPrice: unitPrice, Amount: int64(quantity) * unitPrice}, nil
+}
+
+// assetIDs returns the serial numbers of every asset of the contract, in line order
+func (sc SalesContractObject) assetIDs() []string {
+
+	ids := []string{}
+	for _, line := range sc.LineItems {
+		ids = append(ids, line.AssetIDs...)
+	}
+	return ids
+}
+
+// delivered reports whether every line item of the contract was received by the buyer
+func (sc SalesContractObject) delivered() bool {
+
+	for _, line := range sc.LineItems {
+		if !line.Delivered {
+			return false
+		}
+	}
+	return true
+}
+
+// DeliverLinesRequest struct - the arguments of deliverLineItems
+type DeliverLinesRequest struct {
+	Contractid string `validate:"required"`
+	LineNos    []int  `validate:"required,length=1-500"`
+}
+
+// deliverLineItems - the buyer receives part of a shipment. The assets of the line items received are handed
+// over to the buyer, the other line items stay pending, not delivered, and the contract keeps its stage. Once
+// every line item is received the contract is delivered. args: contractid, JSON array of line numbers, e.g. [1,3]
+func (t *SimpleChaincode) deliverLineItems(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req DeliverLinesRequest
+	if err := decodeRequest("deliverLineItems", args, &req); err != nil {
+		return nil, err
+	}
+	lineNos := req.LineNos
+
+	sc, err := getContractObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if err = check_open(sc); err != nil {
+		return nil, err
+	}
+	if sc.Stage != STATE_INTRANSIT && sc.Stage != STATE_SHIPMENT_REACHED {
+		logFor(stub, sc.Contractid).debug("lines may not be delivered from the stage", "stage", sc.Stage)
+		return nil, permissionDenied("deliverLineItems")
+	}
+	if err = t.check_caller(stub, sc.Buyer, BUYER); err != nil {
+		logFor(stub, sc.Contractid).debug("caller may not deliver lines", "reason", err)
+		return nil, permissionDenied("deliverLineItems")
+	}
+	if err = check_recall_hold(stub, sc); err != nil {
+		return nil, err
+	}
+
+	selected := make(map[int]bool)
+	for _, lineNo := range lineNos {
+		if lineNo < 1 || lineNo > len(sc.LineItems) {
+			return nil, notFound(sc.Contractid, fmt.Sprintf("deliverLineItems() : contract %s has no line %d", sc.Contractid, lineNo))
+		}
+		if selected[lineNo] || sc.LineItems[lineNo-1].Delivered {
+			return nil, conflict(sc.Contractid, fmt.Sprintf("deliverLineItems() : line %d is already delivered", lineNo))
+		}
+		selected[lineNo] = true
+	}
+
+	before := sc
+	if _, err = t.settle_lines(stub, &sc, selected, true); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error applying changes", err)
+	}
+	// the lines not received are pending on the line items, the stage only changes once none is left
+	if sc.delivered() {
+		sc.Stage = STATE_SHIPMENT_DELIVERED
+	}
+
+	if _, err = t.save_changes(stub, &sc); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error saving changes", err)
+	}
+	name := EVENT_CONTRACT_STAGE_CHANGED
+	if sc.Stage == before.Stage {
+		name = EVENT_CONTRACT_UPDATED
+	}
+	if err = emitContractEvent(stub, name, &before, sc); err != nil {
+		return nil, err
+	}
+	logFor(stub, sc.Contractid).info("lines delivered", "lines", lineNos, "stage", sc.Stage)
+	return nil, nil
+}
+
+// settle_lines - Unlocks the assets of the selected undelivered line items. Delivered lines are handed to the
+//				  buyer and marked delivered, the others are given back to the seller and stay undelivered. A nil
+//				  selection settles every undelivered line.
+func (t *SimpleChaincode) settle_lines(stub shim.ChaincodeStubInterface, sc *SalesContractObject, selected map[int]bool, deliver bool) (bool, error) {
+
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return false, err
+	}
+	owner := sc.Seller
+	if deliver {
+		owner = sc.Buyer
+	}
+
+	// the line items may be shared with a copy of the contract taken before the change
+	sc.LineItems = append([]LineItem{}, sc.LineItems...)
+	var amount int64
+	for i := range sc.LineItems {
+		line := &sc.LineItems[i]
+		if line.Delivered || (selected != nil && !selected[line.LineNo]) {
+			continue
+		}
+		if err = t.hand_over_line(stub, *sc, line, owner); err != nil {
+			return false, err
+		}
+		if deliver {
+			line.Delivered = true
+			line.DeliveredAt = txTime.Format(TIME_FORMAT)
+		}
+		amount += line.Amount
+	}
+
+	// the seller is paid for the lines delivered, the transporter once all of them are, the buyer gets the rest back
+	if sc.FundedAt == "" {
+		return true, nil
+	}
+	if deliver {
+		var fee int64
+		if sc.delivered() {
+			fee = sc.TransporterFee
+		}
+		err = settle_contract_escrow(stub, sc.Contractid, amount, fee, 0)
+	} else {
+		err = settle_contract_escrow(stub, sc.Contractid, 0, 0, amount+sc.TransporterFee)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
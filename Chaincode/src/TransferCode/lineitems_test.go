@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newLineItemStub returns a stub holding contract C2 from bosch to lht over three line items: assets 2001 and
+// 2002 of LHTMO, asset 2003 of A320 and ten unserialized bolts, for EUR 12912.50 and a fee of EUR 50 to dhl
+// paid into escrow by lht
+func newLineItemStub(t *testing.T) *shim.MockStub {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bank, "depositFunds", "lht", "EUR", "2000000")
+	mustInvoke(t, stub, bosch, "initAssetsBatch", `[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2002","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"2003","Partno":"A320","Owner":"bosch"}]`)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","DocumentID":"D1","Currency":"EUR","Incoterms":"DAP",
+		"LineItems":[{"AssetIDs":["2001","2002"],"UnitPrice":150000},{"AssetIDs":["2003"],"UnitPrice":990000},{"Partno":"BOLT","Quantity":10,"UnitPrice":125}],"TransporterFee":5000}`)
+	mustInvoke(t, stub, lht, "fundEscrow", "C2")
+	return stub
+}
+
+func TestInitContractWithLineItems(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	sc := getContract(t, stub, "C2")
+	if sc.Currency != "EUR" || sc.Incoterms != "DAP" || sc.Total != 2*150000+990000+10*125 || len(sc.LineItems) != 3 {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+	if line := sc.LineItems[0]; line.LineNo != 1 || line.Partno != "LHTMO" || line.Quantity != 2 || line.Amount != 300000 {
+		t.Fatalf("expected the quantity and part number of line 1 to come from its assets, got %+v", line)
+	}
+	for _, serialNo := range []string{"2001", "2002", "2003"} {
+		if ast := getAsset(t, stub, serialNo); ast.Owner != "bosch" || ast.Contractid != "C2" {
+			t.Fatalf("expected asset %s to be locked by C2, got %+v", serialNo, ast)
+		}
+	}
+	var ev ContractEvent
+	checkEvent(t, stub, EVENT_ESCROW_FUNDED, &ev)
+	if !reflect.DeepEqual(ev.AssetIDs, []string{"2001", "2002", "2003"}) || sc.FundedAt != testTime.Format(TIME_FORMAT) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// the contract is listed under each of its assets
+	for _, serialNo := range []string{"2001", "2003"} {
+		got, err := query(stub, lht, "listContracts", `{"AssetID":"`+serialNo+`"}`)
+		if err != nil {
+			t.Fatalf("listContracts failed: %s", err)
+		}
+		var page ContractPage
+		json.Unmarshal(got, &page)
+		if len(page.Contracts) != 1 || page.Contracts[0].Contractid != "C2" {
+			t.Fatalf("expected C2 listed under asset %s, got %s", serialNo, got)
+		}
+	}
+}
+
+func TestInitContractWithLineItemsRejected(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		lineItems string
+		currency  string
+		wantErr   string
+	}{
+		{"no line items", `[]`, "EUR", "LineItems is required"},
+		{"missing quantity", `[{"Partno":"BOLT","UnitPrice":1}]`, "EUR", "line 1 : Quantity should be between 1"},
+		{"quantity off the assets", `[{"AssetIDs":["2001"],"Quantity":2}]`, "EUR", "does not match the 1 assets"},
+		{"no part number", `[{"Quantity":2}]`, "EUR", "Partno is required"},
+		{"negative price", `[{"AssetIDs":["2001"],"UnitPrice":-1}]`, "EUR", "UnitPrice should be between 0"},
+		{"total overflow", `[{"Partno":"BOLT","Quantity":1,"UnitPrice":9223372036854775807},{"Partno":"BOLT","Quantity":1,"UnitPrice":1}]`, "EUR", "total is too large"},
+		{"priced without currency", `[{"AssetIDs":["2001"],"UnitPrice":1}]`, "", "Currency is required"},
+		{"bad currency", `[{"AssetIDs":["2001"]}]`, "euro", "ISO 4217"},
+		{"unknown asset", `[{"AssetIDs":["2009"]}]`, "EUR", "2009"},
+		{"asset of another owner", `[{"AssetIDs":["3001"]}]`, "EUR", "not owned by bosch"},
+		{"locked asset", `[{"AssetIDs":["2001","1001"]}]`, "EUR", "locked by contract C1"},
+		{"asset listed twice", `[{"AssetIDs":["2001"]},{"AssetIDs":["2001"]}]`, "EUR", "listed more than once"},
+		{"part number of another part", `[{"Partno":"A320","AssetIDs":["2001"]}]`, "EUR", "2001 is not a A320"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newStub(t)
+			mustInvoke(t, stub, admin, "initAssetsBatch", `[{"Serialno":"2001","Partno":"LHTMO","Owner":"bosch"},{"Serialno":"3001","Partno":"LHTMO","Owner":"continental"}]`)
+			_, err := invoke(stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Currency":"`+test.currency+`","LineItems":`+test.lineItems+`}`)
+			checkError(t, err, test.wantErr)
+			if ast := getAsset(t, stub, "2001"); ast.Contractid != "" {
+				t.Fatalf("expected asset 2001 to stay unlocked, got %+v", ast)
+			}
+		})
+	}
+
+	stub := newStub(t)
+	_, err := invoke(stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","Incoterms":"XYZ","LineItems":[{"AssetIDs":["1002"]}]}`)
+	checkError(t, err, "Incoterms should be one of CFR, CIF")
+}
+
+func TestPartialDelivery(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+
+	// only the buyer receives line items
+	for _, c := range []caller{bosch, dhl, continental} {
+		if _, err := invoke(stub, c, "deliverLineItems", "C2", "[1]"); err == nil {
+			t.Fatalf("expected deliverLineItems by %s to be denied", c.username)
+		}
+	}
+	_, err := invoke(stub, lht, "deliverLineItems", "C2", "[4]")
+	checkError(t, err, "has no line 4")
+
+	mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[1,3]")
+	sc := getContract(t, stub, "C2")
+	if sc.Stage != STATE_INTRANSIT || !sc.LineItems[0].Delivered || sc.LineItems[1].Delivered || !sc.LineItems[2].Delivered {
+		t.Fatalf("expected lines 1 and 3 delivered and the contract still in transit, got %+v", sc)
+	}
+	if sc.LineItems[0].DeliveredAt == "" {
+		t.Fatalf("expected the delivery time of line 1, got %+v", sc.LineItems[0])
+	}
+	for serialNo, want := range map[string]AssetObject{
+		"2001": {Owner: "lht"},
+		"2002": {Owner: "lht"},
+		"2003": {Owner: "bosch", Contractid: "C2"},
+	} {
+		if ast := getAsset(t, stub, serialNo); ast.Owner != want.Owner || ast.Contractid != want.Contractid {
+			t.Fatalf("unexpected asset %+v", ast)
+		}
+	}
+	var ev ContractEvent
+	checkEvent(t, stub, EVENT_CONTRACT_UPDATED, &ev)
+	if !reflect.DeepEqual(ev.LineNos, []int{1, 3}) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	_, err = invoke(stub, lht, "deliverLineItems", "C2", "[1]")
+	checkError(t, err, "line 1 is already delivered")
+
+	// the rest of the shipment reaches the buyer, delivering the contract settles the lines left
+	mustInvoke(t, stub, dhl, "shipmentReached", "C2")
+	mustInvoke(t, stub, lht, "shipmentDelivered", "C2")
+	if sc = getContract(t, stub, "C2"); sc.Stage != STATE_SHIPMENT_DELIVERED || !sc.delivered() {
+		t.Fatalf("expected every line delivered, got %+v", sc)
+	}
+	if ast := getAsset(t, stub, "2003"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("unexpected asset %+v", ast)
+	}
+	checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &ev)
+	if !reflect.DeepEqual(ev.LineNos, []int{2}) {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestDeliverLastLineItems(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	_, err := invoke(stub, lht, "deliverLineItems", "C2", "[1]")
+	checkError(t, err, "Permission Denied")
+
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+	mustInvoke(t, stub, dhl, "shipmentReached", "C2")
+	inTransitAt := getContract(t, stub, "C2").StageTimes[STATE_INTRANSIT]
+
+	// a partial delivery of a shipment that reached the buyer leaves the contract reached
+	stub.MockTxTimestamp(testTime.Add(time.Hour))
+	mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[2]")
+	sc := getContract(t, stub, "C2")
+	if sc.Stage != STATE_SHIPMENT_REACHED || sc.StageTimes[STATE_INTRANSIT] != inTransitAt {
+		t.Fatalf("expected the contract to stay reached, got stage %d entered at %v", sc.Stage, sc.StageTimes)
+	}
+	if sc.LineItems[0].Delivered || !sc.LineItems[1].Delivered || sc.LineItems[2].Delivered {
+		t.Fatalf("expected lines 1 and 3 pending, got %+v", sc.LineItems)
+	}
+	var ev ContractEvent
+	checkEvent(t, stub, EVENT_CONTRACT_UPDATED, &ev)
+	if *ev.OldStage != STATE_SHIPMENT_REACHED || ev.NewStage != STATE_SHIPMENT_REACHED {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	got, _ := query(stub, auditor, "listContractsByTime", strconv.Itoa(STATE_INTRANSIT), inTransitAt, inTransitAt)
+	if !strings.Contains(string(got), `"Contractid":"C2"`) {
+		t.Fatalf("expected C2 listed as entering transit at %s, got %s", inTransitAt, got)
+	}
+
+	mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[1,3]")
+	if sc := getContract(t, stub, "C2"); sc.Stage != STATE_SHIPMENT_DELIVERED {
+		t.Fatalf("expected the contract delivered once every line is, got stage %d", sc.Stage)
+	}
+	checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &ev)
+	if *ev.OldStage != STATE_SHIPMENT_REACHED || ev.NewStage != STATE_SHIPMENT_DELIVERED {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+}
+
+func TestCancelReleasesLineItems(t *testing.T) {
+
+	stub := newLineItemStub(t)
+	mustInvoke(t, stub, bosch, "cancelContract", "C2", "out of stock", "")
+	for _, serialNo := range []string{"2001", "2002", "2003"} {
+		if ast := getAsset(t, stub, serialNo); ast.Owner != "bosch" || ast.Contractid != "" {
+			t.Fatalf("expected asset %s released to bosch, got %+v", serialNo, ast)
+		}
+	}
+	if sc := getContract(t, stub, "C2"); sc.delivered() {
+		t.Fatalf("expected no line delivered, got %+v", sc.LineItems)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Logging - the chaincode logs through a shim.ChaincodeLogger, interleaved with the logs of the shim in the
+//			   chaincode container of each peer. The level is read from CORE_LOGGING_CHAINCODE when the chaincode
+//			   starts and can be changed with the logLevel=<level> argument of Init, e.g. logLevel=DEBUG, on the peer
+//			   running it, by an admin once deployed; it is not kept on the ledger. Entries are key=value pairs that
+//			   start with the transaction ID, the function invoked and the asset, contract or account concerned, e.g.
+//				 txid=7f3a... function=inTransit entity=C1 msg="stage changed" from=1 to=2
+//			   so `grep entity=C1` on the container logs follows a shipment. Errors returned to the client are
+//			   logged once by Invoke and Query, as a warning or as an error for INTERNAL ones.
+//==============================================================================================================================
+const LOGGER_NAME = "TransferCode"
+const LOG_LEVEL_ENV = "CORE_LOGGING_CHAINCODE"
+const INIT_LOG_LEVEL = "logLevel"
+
+var logger = shim.NewLogger(LOGGER_NAME)
+
+// init_logging - Sets the level of the logger from the environment, INFO when it is unset or unknown
+func init_logging() {
+
+	if err := set_log_level(os.Getenv(LOG_LEVEL_ENV)); err != nil {
+		logger.Warningf("%s : %s, logging at INFO", LOG_LEVEL_ENV, err)
+		logger.SetLevel(shim.LogInfo)
+	}
+}
+
+// set_log_level - Sets the level of the logger, e.g. DEBUG or warning, INFO for an empty level
+func set_log_level(value string) error {
+
+	if value == "" {
+		value = "INFO"
+	}
+	level, err := shim.LogLevel(value)
+	if err != nil {
+		return invalidArgument(INIT_LOG_LEVEL, "the log level should be CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG : "+value)
+	}
+	logger.SetLevel(level)
+	return nil
+}
+
+// logEntry struct - the transaction, function and entity the log entries of a function are about
+type logEntry struct {
+	txID     string
+	function string
+	entityID string
+}
+
+// logFor returns the context of the log entries about an entity in the transaction of stub
+func logFor(stub shim.ChaincodeStubInterface, entityID string) logEntry {
+	return logEntry{stub.GetTxID(), getFunction(stub), entityID}
+}
+
+func (e logEntry) debug(msg string, keyvals ...interface{}) {
+	if logger.IsEnabledFor(shim.LogDebug) {
+		logger.Debug(e.format(msg, keyvals))
+	}
+}
+
+func (e logEntry) info(msg string, keyvals ...interface{}) {
+	if logger.IsEnabledFor(shim.LogInfo) {
+		logger.Info(e.format(msg, keyvals))
+	}
+}
+
+func (e logEntry) warning(msg string, keyvals ...interface{}) {
+	if logger.IsEnabledFor(shim.LogWarning) {
+		logger.Warning(e.format(msg, keyvals))
+	}
+}
+
+func (e logEntry) error(msg string, keyvals ...interface{}) {
+	if logger.IsEnabledFor(shim.LogError) {
+		logger.Error(e.format(msg, keyvals))
+	}
+}
+
+// format returns an entry as key=value pairs, the context first. Empty context values are left out.
+func (e logEntry) format(msg string, keyvals []interface{}) string {
+
+	pairs := []interface{}{"txid", e.txID, "function", e.function, "entity", e.entityID}
+	var entry []string
+	for i := 0; i < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			entry = append(entry, pairs[i].(string)+"="+logValue(pairs[i+1]))
+		}
+	}
+	entry = append(entry, "msg="+logValue(msg))
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			entry = append(entry, "extra="+logValue(keyvals[i]))
+			break
+		}
+		entry = append(entry, fmt.Sprint(keyvals[i])+"="+logValue(keyvals[i+1]))
+	}
+	return strings.Join(entry, " ")
+}
+
+// logValue returns a value of a log entry, quoted when it holds spaces, quotes or control characters
+func logValue(value interface{}) string {
+
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " \"=\t\r\n") || strconv.Quote(s) != `"`+s+`"` {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestLogEntryFormat(t *testing.T) {
+
+	tests := []struct {
+		entry   logEntry
+		msg     string
+		keyvals []interface{}
+		want    string
+	}{
+		{logEntry{"tx1", "inTransit", "C1"}, "stage changed", []interface{}{"from", 1, "to", 2},
+			`txid=tx1 function=inTransit entity=C1 msg="stage changed" from=1 to=2`},
+		{logEntry{"", "readContract", ""}, "denied", nil, `function=readContract msg=denied`},
+		{logEntry{"tx2", "initContract", "C 2"}, "rejected", []interface{}{"reason", `asset "1001" is locked`, "field", ""},
+			`txid=tx2 function=initContract entity="C 2" msg=rejected reason="asset \"1001\" is locked" field=""`},
+		{logEntry{"tx3", "keys", ""}, "listed", []interface{}{"key", "asset\x001001\x00", "odd"},
+			`txid=tx3 function=keys msg=listed key="asset\x001001\x00" extra=odd`},
+	}
+	for _, test := range tests {
+		if got := test.entry.format(test.msg, test.keyvals); got != test.want {
+			t.Fatalf("expected %s, got %s", test.want, got)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+
+	defer set_log_level("")
+	stub := newStub(t)
+	if _, err := stub.MockInit("deploy", "init", []string{"logLevel=debug"}); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	if !logger.IsEnabledFor(shim.LogDebug) {
+		t.Fatal("expected the logger to log at DEBUG")
+	}
+	_, err := stub.MockInit("deploy", "init", []string{"logLevel=verbose"})
+	checkCode(t, err, ERR_INVALID_ARGUMENT, INIT_LOG_LEVEL, "")
+	if !logger.IsEnabledFor(shim.LogDebug) {
+		t.Fatal("expected an unknown level to keep the level")
+	}
+
+	// the level is set along with the peers, and without changing them once they are set
+	contracts, _, _ := newPeerStubs(t)
+	if _, err = contracts.MockInit("redeploy", "init", []string{"logLevel=WARNING"}); err != nil {
+		t.Fatalf("expected a log level alone to keep the peers, got %s", err)
+	}
+	if logger.IsEnabledFor(shim.LogInfo) || !logger.IsEnabledFor(shim.LogWarning) {
+		t.Fatal("expected the logger to log at WARNING")
+	}
+	if config, _ := getConfig(contracts); config != (ChaincodeConfig{AssetRegistry: "registry", Settlement: "settlement"}) {
+		t.Fatalf("unexpected config %+v", config)
+	}
+}
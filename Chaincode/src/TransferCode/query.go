@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Listings - listContracts and listAssets return pages of decoded objects matching a filter. A listing walks one
+//				key range: the index of the most selective filter given, or every object of the kind when no
+//				indexed filter is set. The other filters are checked on the decoded objects. The bookmark of a page
+//				is the last key read, it is only valid with the same filter.
+//==============================================================================================================================
+const LIST_PAGE_SIZE = 50
+const LIST_MAX_PAGE_SIZE = 500
+
+// ContractFilter struct - every field left empty matches any contract
+type ContractFilter struct {
+	Stage       *int `validate:"min=0,max=9"`
+	Buyer       string
+	Seller      string
+	Transporter string
+	AssetID     string
+	CreatedFrom string // times in RFC 3339, both ends of a window are inclusive
+	CreatedTo   string
+	UpdatedFrom string
+	UpdatedTo   string
+}
+
+// AssetFilter struct - every field left empty matches any asset
+type AssetFilter struct {
+	Owner  string
+	Partno string
+	Status string `validate:"enum=assetStatus"`
+	Locked *bool // whether the asset is committed to an open contract
+}
+
+// ContractListRequest struct - the arguments of listContracts, the filter as a JSON object in the positional form
+type ContractListRequest struct {
+	ContractFilter
+	PageSize int `validate:"min=1,max=500"` // LIST_PAGE_SIZE when left out, at most LIST_MAX_PAGE_SIZE
+	Bookmark string
+}
+
+// AssetListRequest struct - the arguments of listAssets, the filter as a JSON object in the positional form
+type AssetListRequest struct {
+	AssetFilter
+	PageSize int `validate:"min=1,max=500"` // LIST_PAGE_SIZE when left out, at most LIST_MAX_PAGE_SIZE
+	Bookmark string
+}
+
+// ContractPage struct - the response of listContracts
+type ContractPage struct {
+	Contracts []SalesContractObject
+	Bookmark  string // pass back to listContracts with the same filter to read the next page, empty on the last page
+}
+
+// AssetPage struct - the response of listAssets
+type AssetPage struct {
+	Assets   []AssetObject
+	Bookmark string // pass back to listAssets with the same filter to read the next page, empty on the last page
+}
+
+// listContracts returns a page of the contracts matching a filter the caller may read, in the order of the key
+// range walked.
+// args: [filter as a JSON object, e.g. {"Stage":2,"Transporter":"dhl"}], [pageSize], [bookmark], or the filter
+// with PageSize and Bookmark as one JSON object
+func (t *SimpleChaincode) listContracts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractListRequest
+	if err := decodeRequest("listContracts", args, &req); err != nil {
+		return nil, err
+	}
+	filter, pageSize, bookmark := req.ContractFilter, listPageSize(req.PageSize), req.Bookmark
+	var err error
+	for _, bound := range []*string{&filter.CreatedFrom, &filter.CreatedTo, &filter.UpdatedFrom, &filter.UpdatedTo} {
+		if *bound, err = parseTime(*bound); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "listContracts()", err)
+		}
+	}
+
+	startKey, endKey, err := filter.keyRange()
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listContracts()", err)
+	}
+
+	r := t.get_reader(stub)
+	page := ContractPage{Contracts: []SalesContractObject{}}
+	page.Bookmark, err = walkRange(stub, "listContracts", startKey, endKey, bookmark, pageSize, func(contractID string) (bool, error) {
+		sc, err := getContractObject(stub, contractID)
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(sc) || !r.canReadContract(sc) {
+			return false, nil
+		}
+		page.Contracts = append(page.Contracts, sc)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Contracts) > pageSize {
+		page.Contracts = page.Contracts[:pageSize]
+	}
+	return json.Marshal(page)
+}
+
+// listAssets returns a page of the assets matching a filter the caller may read, in the order of the key range
+// walked.
+// args: [filter as a JSON object, e.g. {"Owner":"bosch","Locked":false}], [pageSize], [bookmark], or the filter
+// with PageSize and Bookmark as one JSON object
+func (t *SimpleChaincode) listAssets(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req AssetListRequest
+	if err := decodeRequest("listAssets", args, &req); err != nil {
+		return nil, err
+	}
+	filter, pageSize, bookmark := req.AssetFilter, listPageSize(req.PageSize), req.Bookmark
+
+	startKey, endKey, err := filter.keyRange()
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listAssets()", err)
+	}
+
+	r := t.get_reader(stub)
+	page := AssetPage{Assets: []AssetObject{}}
+	page.Bookmark, err = walkRange(stub, "listAssets", startKey, endKey, bookmark, pageSize, func(serialNo string) (bool, error) {
+		ast, err := getAssetObject(stub, serialNo)
+		if err != nil {
+			return false, err
+		}
+		if !filter.matches(ast) || !r.canReadAsset(stub, ast) {
+			return false, nil
+		}
+		page.Assets = append(page.Assets, ast)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Assets) > pageSize {
+		page.Assets = page.Assets[:pageSize]
+	}
+	return json.Marshal(page)
+}
+
+// listPageSize returns the size of a page of a listing, LIST_PAGE_SIZE when none was asked for
+func listPageSize(pageSize int) int {
+
+	if pageSize == 0 {
+		return LIST_PAGE_SIZE
+	}
+	return pageSize
+}
+
+// walkRange visits in key order the objects whose IDs end the keys of a range, starting after the key of the
+// bookmark, until visit has accepted one object more than pageSize. That last object tells there is a next
+// page, the caller drops it and hands out the returned bookmark, which is empty when there is no next page.
+func walkRange(stub shim.ChaincodeStubInterface, function string, startKey string, endKey string, bookmark string, pageSize int, visit func(objectID string) (bool, error)) (string, error) {
+
+	var after string
+	if bookmark != "" {
+		var err error
+		after, err = decodeBookmark(bookmark)
+		if err != nil || after < startKey || after > endKey {
+			return "", invalidArgument("bookmark", function+"() : invalid bookmark")
+		}
+		startKey = after
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return "", wrapError(ERR_INTERNAL, function+"() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	// the iterator returns the keys in order, the walk stops at the object that tells there is a next page
+	accepted := 0
+	var last string
+	for keysIter.HasNext() {
+		key, _, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return "", wrapError(ERR_INTERNAL, function+"() : Error accessing state", iterErr)
+		}
+		if key <= after {
+			continue
+		}
+		_, attributes, err := splitCompositeKey(key)
+		if err != nil || len(attributes) == 0 {
+			continue
+		}
+		ok, err := visit(attributes[len(attributes)-1])
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if accepted == pageSize {
+			return encodeBookmark(last), nil
+		}
+		accepted++
+		last = key
+	}
+	return "", nil
+}
+
+// keyRange returns the key range a contract listing walks, the index of the most selective filter set
+func (f ContractFilter) keyRange() (string, string, error) {
+
+	indexed := []struct {
+		indexName string
+		value     string
+	}{
+		{INDEX_CONTRACT_ASSET, f.AssetID},
+		{INDEX_CONTRACT_BUYER, f.Buyer},
+		{INDEX_CONTRACT_SELLER, f.Seller},
+		{INDEX_CONTRACT_TRANSPORTER, f.Transporter},
+	}
+	for _, index := range indexed {
+		if index.value != "" {
+			return compositeKeyRange(index.indexName, []string{index.value})
+		}
+	}
+	if f.Stage != nil {
+		return compositeKeyRange(INDEX_CONTRACT_STAGE, []string{strconv.Itoa(*f.Stage)})
+	}
+	if f.CreatedFrom != "" || f.CreatedTo != "" {
+		return windowRange(INDEX_CONTRACT_CREATED, f.CreatedFrom, f.CreatedTo)
+	}
+	if f.UpdatedFrom != "" || f.UpdatedTo != "" {
+		return windowRange(INDEX_CONTRACT_UPDATED, f.UpdatedFrom, f.UpdatedTo)
+	}
+	return compositeKeyRange(CONTRACT_OBJECT, nil)
+}
+
+// matches reports whether a contract passes every filter set
+func (f ContractFilter) matches(sc SalesContractObject) bool {
+
+	if f.Stage != nil && sc.Stage != *f.Stage {
+		return false
+	}
+	for _, field := range [][2]string{{f.Buyer, sc.Buyer}, {f.Seller, sc.Seller}, {f.Transporter, sc.Transporter}} {
+		if field[0] != "" && field[0] != field[1] {
+			return false
+		}
+	}
+	if f.AssetID != "" && !contains(sc.assetIDs(), f.AssetID) {
+		return false
+	}
+	return inWindow(sc.CreatedAt, f.CreatedFrom, f.CreatedTo) && inWindow(sc.UpdatedAt, f.UpdatedFrom, f.UpdatedTo)
+}
+
+// keyRange returns the key range an asset listing walks, the index of the most selective filter set
+func (f AssetFilter) keyRange() (string, string, error) {
+
+	if f.Owner != "" {
+		return compositeKeyRange(INDEX_ASSET_OWNER, []string{f.Owner})
+	}
+	if f.Partno != "" {
+		return compositeKeyRange(INDEX_ASSET_PARTNO, []string{f.Partno})
+	}
+	return compositeKeyRange(ASSET_OBJECT, nil)
+}
+
+// matches reports whether an asset passes every filter set
+func (f AssetFilter) matches(ast AssetObject) bool {
+
+	if f.Owner != "" && ast.Owner != f.Owner {
+		return false
+	}
+	if f.Partno != "" && ast.Partno != f.Partno {
+		return false
+	}
+	if f.Status != "" && ast.Status != f.Status {
+		return false
+	}
+	return f.Locked == nil || *f.Locked == (ast.Contractid != "")
+}
+
+// contains reports whether value is one of values
+func contains(values []string, value string) bool {
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// inWindow reports whether a ledger time lies between from and to, both inclusive, an empty bound being open
+func inWindow(value string, from string, to string) bool {
+
+	if from == "" && to == "" {
+		return true
+	}
+	return value != "" && (from == "" || value >= from) && (to == "" || value <= to)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// newListingStub returns a stub holding assets 1001 to 1009 and contracts C1 to C6, created a day apart
+// from testTime on. C1 to C3 are sold to lht, C4 to C6 to ups; the even contracts are ready for shipment.
+// Assets 1002 and 1008 are free, 1009 is an A320 of lht.
+func newListingStub(t *testing.T) *shim.MockStub {
+
+	stub := newStub(t)
+	for i := 3; i <= 8; i++ {
+		mustInvoke(t, stub, bosch, "initAssset", strconv.Itoa(1000+i), "LHTMO", "bosch")
+	}
+	mustInvoke(t, stub, admin, "initAssset", "1009", "A320", "lht")
+	for i := 2; i <= 6; i++ {
+		buyer := "lht"
+		if i > 3 {
+			buyer = "ups"
+		}
+		stub.MockTxTimestamp(testTime.Add(time.Duration(i-1) * 24 * time.Hour))
+		contractID := "C" + strconv.Itoa(i)
+		mustInvoke(t, stub, bosch, "initContract", contractID, "0", buyer, "dhl", "bosch", strconv.Itoa(1000+i+1), "D1", "")
+		if i%2 == 0 {
+			mustInvoke(t, stub, bosch, "readyForShipment", contractID, "D2")
+		}
+	}
+	stub.MockTxTimestamp(testTime)
+	return stub
+}
+
+// listAll follows the bookmarks of a listing and returns the IDs listed on each page
+func listAll(t *testing.T, stub *shim.MockStub, function string, filter string, pageSize int) [][]string {
+
+	var pages [][]string
+	bookmark := ""
+	for {
+		got, err := query(stub, auditor, function, filter, strconv.Itoa(pageSize), bookmark)
+		if err != nil {
+			t.Fatalf("%s(%s) failed: %s", function, filter, err)
+		}
+		ids := []string{}
+		if function == "listContracts" {
+			var page ContractPage
+			json.Unmarshal(got, &page)
+			for _, sc := range page.Contracts {
+				ids = append(ids, sc.Contractid)
+			}
+			bookmark = page.Bookmark
+		} else {
+			var page AssetPage
+			json.Unmarshal(got, &page)
+			for _, ast := range page.Assets {
+				ids = append(ids, ast.Serialno)
+			}
+			bookmark = page.Bookmark
+		}
+		pages = append(pages, ids)
+		if bookmark == "" || len(pages) > 10 {
+			return pages
+		}
+	}
+}
+
+func TestListContractsFiltered(t *testing.T) {
+
+	stub := newListingStub(t)
+	day := func(days int) string { return testTime.Add(time.Duration(days) * 24 * time.Hour).Format(TIME_FORMAT) }
+
+	tests := []struct {
+		filter   string
+		pageSize int
+		want     string
+	}{
+		{``, 50, `[[C1 C2 C3 C4 C5 C6]]`},
+		{``, 2, `[[C1 C2] [C3 C4] [C5 C6]]`},
+		{`{}`, 4, `[[C1 C2 C3 C4] [C5 C6]]`},
+		{`{"Buyer":"lht"}`, 2, `[[C1 C2] [C3]]`},
+		{`{"Buyer":"ups","Stage":1}`, 1, `[[C4] [C6]]`},
+		{`{"Stage":0}`, 2, `[[C1 C3] [C5]]`},
+		{`{"Stage":0,"Buyer":"lht"}`, 2, `[[C1 C3]]`},
+		{`{"Transporter":"dhl","Seller":"bosch"}`, 3, `[[C1 C2 C3] [C4 C5 C6]]`},
+		{`{"Transporter":"ups"}`, 3, `[[]]`},
+		{`{"AssetID":"1004"}`, 3, `[[C3]]`},
+		{`{"CreatedFrom":"` + day(2) + `"}`, 2, `[[C3 C4] [C5 C6]]`},
+		{`{"CreatedFrom":"` + day(1) + `","CreatedTo":"` + day(3) + `","Stage":1}`, 5, `[[C2 C4]]`},
+		{`{"UpdatedTo":"` + day(0) + `"}`, 5, `[[C1]]`},
+		{`{"Buyer":"ups","CreatedTo":"` + day(4) + `"}`, 1, `[[C4] [C5]]`},
+	}
+
+	for _, test := range tests {
+		if got := fmt.Sprint(listAll(t, stub, "listContracts", test.filter, test.pageSize)); got != test.want {
+			t.Errorf("listContracts(%s, %d): expected %s, got %s", test.filter, test.pageSize, test.want, got)
+		}
+	}
+}
+
+func TestListAssetsFiltered(t *testing.T) {
+
+	stub := newListingStub(t)
+
+	tests := []struct {
+		filter   string
+		pageSize int
+		want     string
+	}{
+		{``, 4, `[[1001 1002 1003 1004] [1005 1006 1007 1008] [1009]]`},
+		{`{"Owner":"lht"}`, 4, `[[1009]]`},
+		{`{"Partno":"A320"}`, 4, `[[1009]]`},
+		{`{"Owner":"bosch","Partno":"A320"}`, 4, `[[]]`},
+		{`{"Owner":"bosch","Locked":false}`, 5, `[[1002 1008]]`},
+		{`{"Partno":"LHTMO","Locked":true}`, 3, `[[1001 1003 1004] [1005 1006 1007]]`},
+	}
+
+	for _, test := range tests {
+		if got := fmt.Sprint(listAll(t, stub, "listAssets", test.filter, test.pageSize)); got != test.want {
+			t.Errorf("listAssets(%s, %d): expected %s, got %s", test.filter, test.pageSize, test.want, got)
+		}
+	}
+}
+
+func TestListingArguments(t *testing.T) {
+
+	stub := newListingStub(t)
+	got, _ := query(stub, lht, "listContracts", `{"Buyer":"lht"}`, "1")
+	var page ContractPage
+	json.Unmarshal(got, &page)
+
+	tests := []struct {
+		name     string
+		function string
+		args     []string
+		wantErr  string
+	}{
+		{"malformed filter", "listContracts", []string{`{"Stage":"open"}`}, "Stage should be an integer"},
+		{"unknown stage", "listContracts", []string{`{"Stage":12}`}, "Stage should be at most 9"},
+		{"bad time", "listContracts", []string{`{"CreatedFrom":"yesterday"}`}, "RFC 3339"},
+		{"bad page size", "listContracts", []string{``, "1000"}, "PageSize should be at most 500"},
+		{"bad bookmark", "listContracts", []string{``, "", "not a bookmark"}, "invalid bookmark"},
+		{"bookmark of another filter", "listContracts", []string{`{"Buyer":"ups"}`, "1", page.Bookmark}, "invalid bookmark"},
+		{"too many arguments", "listAssets", []string{``, "", "", ""}, "Incorrect number of arguments"},
+		{"malformed asset filter", "listAssets", []string{`[]`}, "should be a JSON object"},
+		{"bookmark of the same filter", "listContracts", []string{`{"Buyer":"lht"}`, "1", page.Bookmark}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := query(stub, lht, test.function, test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestWalkRangeStopsAfterPage(t *testing.T) {
+
+	// a page reads one object past its last one and leaves the rest of the range unread
+	stub := newListingStub(t)
+	startKey, endKey, _ := AssetFilter{}.keyRange()
+	var visited []string
+	bookmark, err := walkRange(stub, "listAssets", startKey, endKey, "", 2, func(serialNo string) (bool, error) {
+		visited = append(visited, serialNo)
+		return true, nil
+	})
+	if err != nil || bookmark == "" || len(visited) != 3 || visited[0] != "1001" || visited[2] != "1003" {
+		t.Fatalf("expected 1001 to 1003 visited and a bookmark, got %v, %q, %v", visited, bookmark, err)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Recalls - the manufacturer of a part, or an admin, launches a recall campaign on a part number and a range of
+//			   serial numbers. Every asset of the range that was not scrapped is flagged recalled in one transaction,
+//			   with a record naming the campaign, see lifecycle.go. A recalled asset can't be sold, and a contract
+//			   holding one can't move forward until an inspector releases the asset: it can still be cancelled,
+//			   rejected or disputed. Owners list the recalls outstanding on their assets with listRecallsByOwner.
+//==============================================================================================================================
+
+// Stages a contract holding a recalled asset can't enter
+var RECALL_HELD_STAGES = map[int]bool{STATE_READYFORSHIPMENT: true, STATE_INTRANSIT: true, STATE_SHIPMENT_REACHED: true, STATE_SHIPMENT_DELIVERED: true}
+
+// RecallCampaign struct
+type RecallCampaign struct {
+	CampaignID string
+	Partno     string
+	SerialFrom string // first serial number of the range, empty from the lowest
+	SerialTo   string // last serial number of the range, inclusive, empty up to the highest
+	Reason     string
+	Remedy     string
+	Issuer     string
+	IssuedAt   string   // transaction time in RFC 3339
+	TxID       string
+	AssetIDs   []string // the assets flagged
+	Contracts  []string // the open contracts the assets flagged were committed to
+}
+
+// RecallNotice struct - a recall outstanding on assets of an owner, returned by listRecallsByOwner
+type RecallNotice struct {
+	CampaignID string
+	Partno     string
+	Reason     string
+	Remedy     string
+	Issuer     string
+	IssuedAt   string
+	AssetIDs   []string // the assets of the owner still recalled under the campaign
+}
+
+// AssetStatus struct - the response of getAssetStatus
+type AssetStatus struct {
+	Serialno string
+	Status   string
+	RecallID string
+}
+
+// RecallRequest struct - the arguments of launchRecall
+type RecallRequest struct {
+	CampaignID string `validate:"required"`
+	Partno     string `validate:"required"`
+	SerialFrom string `validate:"pattern=integer"`
+	SerialTo   string `validate:"pattern=integer"`
+	Reason     string `validate:"required,length=1-1000"`
+	Remedy     string `validate:"length=0-1000"`
+}
+
+// CampaignIDRequest struct - the arguments of readRecall
+type CampaignIDRequest struct {
+	CampaignID string `validate:"required"`
+}
+
+// launchRecall flags the assets of a part number within a range of serial numbers recalled.
+// args: campaignID, partno, [serialFrom], [serialTo], reason, [remedy] or
+// {"CampaignID":"R1","Partno":"LHTMO","SerialFrom":"1000","SerialTo":"1999","Reason":"seal may leak"}
+func (t *SimpleChaincode) launchRecall(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req RecallRequest
+	if err := decodeRequest("launchRecall", args, &req); err != nil {
+		return nil, err
+	}
+	if req.SerialFrom != "" && req.SerialTo != "" && compareSerials(req.SerialFrom, req.SerialTo) > 0 {
+		return nil, invalidArgument("SerialTo", "launchRecall() : SerialTo should not be below SerialFrom")
+	}
+
+	part, err := getPartObject(stub, req.Partno)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.check_manufacturer(stub, part); err != nil {
+		logFor(stub, req.CampaignID).debug("caller may not recall the part", "reason", err)
+		return nil, permissionDenied("launchRecall")
+	}
+
+	// check if the campaign already exists
+	recallKey, err := getRecallKey(req.CampaignID)
+	if err != nil {
+		return nil, err
+	}
+	recallAsBytes, err := stub.GetState(recallKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get recall campaign", err)
+	}
+	if recallAsBytes != nil {
+		return nil, alreadyExists(req.CampaignID, "recall campaign already exists "+req.CampaignID)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	campaign := RecallCampaign{
+		CampaignID: req.CampaignID,
+		Partno:     req.Partno,
+		SerialFrom: req.SerialFrom,
+		SerialTo:   req.SerialTo,
+		Reason:     req.Reason,
+		Remedy:     req.Remedy,
+		Issuer:     getActor(stub),
+		IssuedAt:   txTime.Format(TIME_FORMAT),
+		TxID:       stub.GetTxID(),
+		AssetIDs:   []string{},
+		Contracts:  []string{},
+	}
+
+	serialNos, err := getIndexedIDs(stub, INDEX_ASSET_PARTNO, req.Partno)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(serialNos, func(i, j int) bool { return compareSerials(serialNos[i], serialNos[j]) < 0 })
+	for _, serialNo := range serialNos {
+		if !campaign.covers(serialNo) {
+			continue
+		}
+		ast, err := getAssetObject(stub, serialNo)
+		if err != nil {
+			return nil, err
+		}
+		if ast.Status == ASSET_SCRAPPED {
+			continue
+		}
+		rec := AssetRecord{Kind: RECORD_RECALL, Description: req.Reason, CampaignID: campaign.CampaignID}
+		if _, err = t.put_asset_record(stub, ast, rec, ASSET_RECALLED); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "launchRecall() : write error while flagging asset "+serialNo, err)
+		}
+		campaign.AssetIDs = append(campaign.AssetIDs, serialNo)
+		if ast.Contractid != "" && !contains(campaign.Contracts, ast.Contractid) {
+			campaign.Contracts = append(campaign.Contracts, ast.Contractid)
+		}
+	}
+
+	buff, err := json.Marshal(campaign)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "launchRecall() : Cannot create recall record", err)
+	}
+	if err = stub.PutState(recallKey, buff); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "launchRecall() : write error while inserting record", err)
+	}
+	if err = emitRecallEvent(stub, campaign); err != nil {
+		return nil, err
+	}
+	logFor(stub, campaign.CampaignID).info("recall launched", "partno", campaign.Partno, "assets", len(campaign.AssetIDs), "contracts", len(campaign.Contracts))
+	return nil, nil
+}
+
+// readRecall returns a recall campaign, to its issuer, inspectors, auditors and admins.
+// args: campaignID
+func (t *SimpleChaincode) readRecall(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req CampaignIDRequest
+	if err := decodeRequest("readRecall", args, &req); err != nil {
+		return nil, err
+	}
+	campaign, err := getRecallCampaign(stub, req.CampaignID)
+	if err != nil {
+		return nil, err
+	}
+	r := t.get_reader(stub)
+	if r.username == "" || !(r.privileged() || r.role == INSPECTOR || r.username == campaign.Issuer) {
+		logFor(stub, campaign.CampaignID).debug("caller may not read the recall campaign")
+		return nil, permissionDenied("readRecall")
+	}
+	return json.Marshal(campaign)
+}
+
+// listRecallsByOwner returns the recall campaigns outstanding on the assets of an owner, with the assets still recalled.
+// args: owner
+func (t *SimpleChaincode) listRecallsByOwner(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req OwnerRequest
+	if err := decodeRequest("listRecallsByOwner", args, &req); err != nil {
+		return nil, err
+	}
+	if !t.get_reader(stub).canReadRecalls(req.Owner) {
+		return nil, permissionDenied("listRecallsByOwner")
+	}
+
+	serialNos, err := getIndexedIDs(stub, INDEX_ASSET_OWNER, req.Owner)
+	if err != nil {
+		return nil, err
+	}
+	recalled := make(map[string][]string)
+	for _, serialNo := range serialNos {
+		ast, err := getAssetObject(stub, serialNo)
+		if err != nil {
+			return nil, err
+		}
+		if ast.Status == ASSET_RECALLED && ast.RecallID != "" {
+			recalled[ast.RecallID] = append(recalled[ast.RecallID], ast.Serialno)
+		}
+	}
+
+	campaignIDs := make([]string, 0, len(recalled))
+	for campaignID := range recalled {
+		campaignIDs = append(campaignIDs, campaignID)
+	}
+	sort.Strings(campaignIDs)
+	notices := []RecallNotice{}
+	for _, campaignID := range campaignIDs {
+		campaign, err := getRecallCampaign(stub, campaignID)
+		if err != nil {
+			return nil, err
+		}
+		assetIDs := recalled[campaignID]
+		sort.Slice(assetIDs, func(i, j int) bool { return compareSerials(assetIDs[i], assetIDs[j]) < 0 })
+		notices = append(notices, RecallNotice{campaign.CampaignID, campaign.Partno, campaign.Reason, campaign.Remedy, campaign.Issuer, campaign.IssuedAt, assetIDs})
+	}
+	return json.Marshal(notices)
+}
+
+// getAssetStatus returns the lifecycle status of an asset and the recall campaign it is flagged by. Recalls concern
+// everyone handling a part, any caller with a certificate reads them, e.g. a transporter through a contract of a
+// peer chaincode. args: serialno
+func (t *SimpleChaincode) getAssetStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req SerialnoRequest
+	if err := decodeRequest("getAssetStatus", args, &req); err != nil {
+		return nil, err
+	}
+	if t.get_reader(stub).username == "" {
+		return nil, permissionDenied("getAssetStatus")
+	}
+	ast, err := getAssetObject(stub, req.Serialno)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(AssetStatus{ast.Serialno, ast.Status, ast.RecallID})
+}
+
+// check_recall_hold - Verifies that no asset a contract still has to deliver is recalled. It is checked before the
+//					   contract enters one of the RECALL_HELD_STAGES.
+func check_recall_hold(stub shim.ChaincodeStubInterface, sc SalesContractObject) error {
+
+	for _, line := range sc.LineItems {
+		if line.Delivered {
+			continue
+		}
+		for _, assetID := range line.AssetIDs {
+			status, err := get_registry_asset_status(stub, assetID)
+			if err != nil {
+				return err
+			}
+			if status.Status == ASSET_RECALLED {
+				return conflict(sc.Contractid, "asset "+assetID+" of contract "+sc.Contractid+" is recalled by campaign "+status.RecallID)
+			}
+		}
+	}
+	return nil
+}
+
+// covers reports whether a serial number lies in the range of a campaign
+func (c RecallCampaign) covers(serialNo string) bool {
+	return (c.SerialFrom == "" || compareSerials(serialNo, c.SerialFrom) >= 0) && (c.SerialTo == "" || compareSerials(serialNo, c.SerialTo) <= 0)
+}
+
+// compareSerials compares two serial numbers as integers of any length, returning -1, 0 or 1
+func compareSerials(a string, b string) int {
+
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func getRecallCampaign(stub shim.ChaincodeStubInterface, campaignID string) (RecallCampaign, error) {
+
+	var campaign RecallCampaign
+	recallKey, err := getRecallKey(campaignID)
+	if err != nil {
+		return campaign, err
+	}
+	recallAsBytes, err := stub.GetState(recallKey)
+	if err != nil {
+		return campaign, wrapError(ERR_INTERNAL, "Failed to get recall campaign", err)
+	}
+	if recallAsBytes == nil {
+		return campaign, notFound(campaignID, "no recall campaign for "+campaignID)
+	}
+	if err = json.Unmarshal(recallAsBytes, &campaign); err != nil {
+		return campaign, wrapError(ERR_INTERNAL, "invalid recall record", err)
+	}
+	return campaign, nil
+}
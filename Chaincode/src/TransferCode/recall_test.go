@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLaunchRecall(t *testing.T) {
+
+	// 1001 is committed to C1, 1002 is free, 1003 was sold to lht, 1004 is scrapped and 1005 out of range
+	stub := newStub(t)
+	for _, serialNo := range []string{"1003", "1004", "1005"} {
+		mustInvoke(t, stub, bosch, "initAssset", serialNo, "LHTMO", "bosch")
+	}
+	mustInvoke(t, stub, bosch, "ownerUpdation", "1003", "lht")
+	mustInvoke(t, stub, inspector, "setAssetStatus", "1004", ASSET_SCRAPPED, "beyond repair")
+	mustInvoke(t, stub, bosch, "initAssset", "1010", "A320", "bosch")
+
+	mustInvoke(t, stub, bosch, "launchRecall", `{"CampaignID":"R1","Partno":"LHTMO","SerialFrom":"1001","SerialTo":"1004","Reason":"seal may leak","Remedy":"replace the seal"}`)
+	var ev RecallEvent
+	checkEvent(t, stub, EVENT_RECALL_LAUNCHED, &ev)
+	if !reflect.DeepEqual(ev.Campaign.AssetIDs, []string{"1001", "1002", "1003"}) || !reflect.DeepEqual(ev.Campaign.Contracts, []string{"C1"}) || ev.Campaign.Issuer != "bosch" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	for serialNo, want := range map[string]string{"1001": ASSET_RECALLED, "1002": ASSET_RECALLED, "1003": ASSET_RECALLED, "1004": ASSET_SCRAPPED, "1005": ASSET_ACTIVE, "1010": ASSET_ACTIVE} {
+		if ast := getAsset(t, stub, serialNo); ast.Status != want || (want == ASSET_RECALLED) != (ast.RecallID == "R1") {
+			t.Fatalf("expected asset %s %s, got %+v", serialNo, want, ast)
+		}
+	}
+	provenance := getProvenance(t, stub, lht, "1003")
+	if n := len(provenance.Records); n != 1 || provenance.Records[0].Kind != RECORD_RECALL || provenance.Records[0].CampaignID != "R1" || provenance.Records[0].Recorder != "bosch" {
+		t.Fatalf("unexpected records %+v", provenance.Records)
+	}
+
+	// the open contract of a recalled asset is held, it can still be cancelled
+	_, err := invoke(stub, bosch, "readyForShipment", "C1", "D2")
+	checkCode(t, err, ERR_CONFLICT, "", "C1")
+	checkError(t, err, "asset 1001 of contract C1 is recalled by campaign R1")
+	_, err = invoke(stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D1")
+	checkError(t, err, "asset 1002 is recalled")
+
+	mustInvoke(t, stub, bosch, "cancelContract", "C1", "supplier recall", "")
+	if ast := getAsset(t, stub, "1001"); ast.Contractid != "" || ast.Status != ASSET_RECALLED {
+		t.Fatalf("expected the asset released by the contract and still recalled, got %+v", ast)
+	}
+
+	// once the asset is remedied an inspector releases it from the recall
+	mustInvoke(t, stub, inspector, "setAssetStatus", "1001", ASSET_ACTIVE, "seal replaced")
+	if ast := getAsset(t, stub, "1001"); ast.RecallID != "" {
+		t.Fatalf("expected the recall cleared, got %+v", ast)
+	}
+
+	// owners list the recalls outstanding on their assets
+	got, err := query(stub, bosch, "listRecallsByOwner", "bosch")
+	if err != nil {
+		t.Fatalf("listRecallsByOwner failed: %s", err)
+	}
+	var notices []RecallNotice
+	json.Unmarshal(got, &notices)
+	if len(notices) != 1 || notices[0].CampaignID != "R1" || notices[0].Remedy != "replace the seal" || !reflect.DeepEqual(notices[0].AssetIDs, []string{"1002"}) {
+		t.Fatalf("unexpected notices %s", got)
+	}
+	got, _ = query(stub, lht, "listRecallsByOwner", "lht")
+	notices = nil
+	json.Unmarshal(got, &notices)
+	if len(notices) != 1 || !reflect.DeepEqual(notices[0].AssetIDs, []string{"1003"}) {
+		t.Fatalf("unexpected notices %s", got)
+	}
+	got, _ = query(stub, inspector, "listRecallsByOwner", "continental")
+	if string(got) != "[]" {
+		t.Fatalf("expected no recalls, got %s", got)
+	}
+	_, err = query(stub, lht, "listRecallsByOwner", "bosch")
+	checkError(t, err, "Permission Denied")
+
+	got, err = query(stub, bosch, "readRecall", "R1")
+	if err != nil {
+		t.Fatalf("readRecall failed: %s", err)
+	}
+	var campaign RecallCampaign
+	json.Unmarshal(got, &campaign)
+	if campaign.SerialTo != "1004" || campaign.IssuedAt != testTime.Format(TIME_FORMAT) {
+		t.Fatalf("unexpected campaign %s", got)
+	}
+	_, err = query(stub, lht, "readRecall", "R1")
+	checkError(t, err, "Permission Denied")
+}
+
+func TestLaunchRecallRejected(t *testing.T) {
+
+	stub := newStub(t)
+	tests := []struct {
+		name    string
+		caller  caller
+		args    []string
+		wantErr string
+	}{
+		{"not the manufacturer", continental, []string{"R1", "LHTMO", "", "", "seal may leak"}, "Permission Denied"},
+		{"inspector", inspector, []string{"R1", "LHTMO", "", "", "seal may leak"}, "Permission Denied"},
+		{"unknown part", bosch, []string{"R1", "XYZ", "", "", "seal may leak"}, "no part for XYZ"},
+		{"no reason", bosch, []string{"R1", "LHTMO"}, "Reason is required"},
+		{"serial not a number", bosch, []string{"R1", "LHTMO", "A1", "", "seal may leak"}, "SerialFrom should be an integer"},
+		{"range reversed", bosch, []string{"R1", "LHTMO", "2000", "999", "seal may leak"}, "SerialTo should not be below SerialFrom"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, "launchRecall", test.args...)
+			checkError(t, err, test.wantErr)
+		})
+	}
+
+	// an admin recalls any part, campaigns are not relaunched
+	mustInvoke(t, stub, admin, "launchRecall", "R1", "A320", "", "", "corrosion")
+	_, err := invoke(stub, bosch, "launchRecall", "R1", "LHTMO", "", "", "seal may leak")
+	checkCode(t, err, ERR_ALREADY_EXISTS, "", "R1")
+}
+
+func TestRecallHoldsPeerContract(t *testing.T) {
+
+	contracts, registry, _ := newPeerStubs(t)
+	mustInvoke(t, contracts, bosch, "initContract", peerContract)
+	mustInvoke(t, contracts, lht, "fundEscrow", "C3")
+	mustInvoke(t, contracts, bosch, "readyForShipment", "C3", "D2")
+
+	mustInvoke(t, registry, admin, "launchRecall", "R1", "A320", "3002", "3002", "corrosion")
+	_, err := invoke(contracts, dhl, "inTransit", "C3")
+	checkError(t, err, "asset 3002 of contract C3 is recalled by campaign R1")
+
+	mustInvoke(t, registry, inspector, "setAssetStatus", "3002", ASSET_ACTIVE, "no corrosion found")
+	mustInvoke(t, contracts, dhl, "inTransit", "C3")
+}
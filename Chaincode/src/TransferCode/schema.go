@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Schema versions - every asset and contract carries the version of the schema it was written with. Records of
+//					   an older version are upgraded in memory when they are read, so they keep working, and are
+//					   rewritten in the current version by the next change or by migrateRecords. Records of a newer
+//					   version than the chaincode knows are refused rather than silently truncated.
+//==============================================================================================================================
+
+// Version 1 adds SchemaVersion to assets, and CreatedAt, UpdatedAt and StageTimes to contracts in place of
+// the TimeStamp written by version 0. Contract version 2 replaces the single AssetID by LineItems and adds
+// Currency, Incoterms and Total, version 3 adds FundedAt. Asset version 2 adds Status, older assets are active,
+// version 3 adds RecallID.
+const ASSET_SCHEMA_VERSION = 3
+const CONTRACT_SCHEMA_VERSION = 3
+
+// Layout of the TimeStamp of version 0 contracts
+const LEGACY_TIME_FORMAT = "20060102150405"
+
+const MIGRATION_PAGE_SIZE = 100
+const MIGRATION_MAX_PAGE_SIZE = 500
+
+// legacyContractFields struct - fields of older contract versions that are no longer part of SalesContractObject
+type legacyContractFields struct {
+	TimeStamp string // version 0
+	AssetID   string // versions 0 and 1
+}
+
+// MigrationResult struct - the response of migrateRecords
+type MigrationResult struct {
+	Scanned  int
+	Migrated []string // IDs of the records rewritten in the current schema
+	Bookmark string   // pass back to migrateRecords to carry on with the next page, empty once every record is done
+}
+
+// decodeAsset decodes and validates a stored asset, upgrading it to the current schema
+func decodeAsset(data []byte) (AssetObject, error) {
+
+	var ast AssetObject
+	if err := json.Unmarshal(data, &ast); err != nil {
+		return ast, wrapError(ERR_INTERNAL, "invalid asset record", err)
+	}
+	if ast.SchemaVersion > ASSET_SCHEMA_VERSION {
+		return ast, fmt.Errorf("asset %s has schema version %d, this chaincode knows up to %d", ast.Serialno, ast.SchemaVersion, ASSET_SCHEMA_VERSION)
+	}
+	if ast.SchemaVersion < 2 && ast.Status == "" {
+		ast.Status = ASSET_ACTIVE
+	}
+	ast.SchemaVersion = ASSET_SCHEMA_VERSION
+	if err := ast.validate(); err != nil {
+		return ast, wrapError(ERR_INTERNAL, "invalid asset record", err)
+	}
+	return ast, nil
+}
+
+// decodeContract decodes and validates a stored contract, upgrading it to the current schema
+func decodeContract(data []byte) (SalesContractObject, error) {
+
+	var sc SalesContractObject
+	if err := json.Unmarshal(data, &sc); err != nil {
+		return sc, wrapError(ERR_INTERNAL, "invalid contract record", err)
+	}
+	if sc.SchemaVersion > CONTRACT_SCHEMA_VERSION {
+		return sc, fmt.Errorf("contract %s has schema version %d, this chaincode knows up to %d", sc.Contractid, sc.SchemaVersion, CONTRACT_SCHEMA_VERSION)
+	}
+	var legacy legacyContractFields
+	if sc.SchemaVersion < CONTRACT_SCHEMA_VERSION {
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return sc, wrapError(ERR_INTERNAL, "invalid contract record", err)
+		}
+	}
+	if sc.SchemaVersion < 1 {
+		// the version 0 TimeStamp is the time of the last change, the only time known for the contract
+		if stamped, err := time.Parse(LEGACY_TIME_FORMAT, legacy.TimeStamp); err == nil {
+			txTime := stamped.Format(TIME_FORMAT)
+			sc.CreatedAt = txTime
+			sc.UpdatedAt = txTime
+			sc.StageTimes = map[int]string{sc.Stage: txTime}
+		}
+	}
+	if sc.SchemaVersion < 2 && len(sc.LineItems) == 0 && legacy.AssetID != "" {
+		// the asset of an older contract becomes its only line item, handed over when the contract was delivered
+		line := LineItem{LineNo: 1, AssetIDs: []string{legacy.AssetID}, Quantity: 1}
+		if sc.Stage == STATE_SHIPMENT_DELIVERED {
+			line.Delivered = true
+			line.DeliveredAt = sc.StageTimes[sc.Stage]
+		}
+		sc.LineItems = []LineItem{line}
+	}
+	if sc.SchemaVersion < 3 {
+		// older contracts were funded by initContract, a sealed price can't tell whether there was anything to pay
+		sc.FundedAt = sc.CreatedAt
+	}
+	sc.SchemaVersion = CONTRACT_SCHEMA_VERSION
+	if err := sc.validate(); err != nil {
+		return sc, wrapError(ERR_INTERNAL, "invalid contract record", err)
+	}
+	return sc, nil
+}
+
+// validate checks the fields an asset can't do without
+func (ast AssetObject) validate() error {
+
+	if ast.Serialno == "" {
+		return errors.New("Serialno is missing")
+	}
+	if _, err := strconv.Atoi(ast.Serialno); err != nil {
+		return errors.New("Serialno should be an integer : " + ast.Serialno)
+	}
+	if !ASSET_STATUSES[ast.Status] {
+		return errors.New("asset " + ast.Serialno + " has an unknown status " + ast.Status)
+	}
+	return nil
+}
+
+// validate checks the fields a contract can't do without
+func (sc SalesContractObject) validate() error {
+
+	if sc.Contractid == "" {
+		return errors.New("Contractid is missing")
+	}
+	if sc.Stage < STATE_OPEN || sc.Stage > STATE_RETURNED_TO_SELLER {
+		return fmt.Errorf("contract %s has an unknown stage %d", sc.Contractid, sc.Stage)
+	}
+	missing := []string{}
+	for field, value := range map[string]string{"Buyer": sc.Buyer, "Transporter": sc.Transporter, "Seller": sc.Seller} {
+		if value == "" && !sc.sealed() { // the parties of a sealed contract are encrypted
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.New("contract " + sc.Contractid + " is missing " + strings.Join(missing, ", "))
+	}
+	if len(sc.LineItems) == 0 {
+		return errors.New("contract " + sc.Contractid + " has no line items")
+	}
+	for _, line := range sc.LineItems {
+		if line.Quantity <= 0 || (len(line.AssetIDs) > 0 && len(line.AssetIDs) != line.Quantity) {
+			return fmt.Errorf("contract %s has an invalid quantity on line %d", sc.Contractid, line.LineNo)
+		}
+	}
+	return nil
+}
+
+// MigrationRequest struct - the arguments of migrateRecords
+type MigrationRequest struct {
+	ObjectType string `validate:"required,enum=objectType"`
+	PageSize   int    `validate:"min=1,max=500"` // MIGRATION_PAGE_SIZE when left out, at most MIGRATION_MAX_PAGE_SIZE
+	Bookmark   string
+}
+
+// migrateRecords rewrites the assets or contracts written with an older schema in the current one. Records are
+// visited in key order, one page per transaction, so a large ledger is migrated over several invokes. Records
+// the baseline chaincode stored under their bare serial number or contract ID are moved under their key, see
+// keys.go, with their indexes. Only an admin migrates records.
+// args: objectType (asset|contract), [pageSize], [bookmark]
+func (t *SimpleChaincode) migrateRecords(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req MigrationRequest
+	if len(args) > 0 && !namedForm(args) { // the positional form takes the object type in any case
+		args = append([]string{strings.ToLower(args[0])}, args[1:]...)
+	}
+	if err := decodeRequest("migrateRecords", args, &req); err != nil {
+		return nil, err
+	}
+	if _, err := t.check_role(stub, ADMIN); err != nil {
+		logFor(stub, "").debug("caller may not migrate records", "reason", err)
+		return nil, permissionDenied("migrateRecords")
+	}
+
+	keyObject := ASSET_OBJECT
+	if req.ObjectType == HISTORY_CONTRACT {
+		keyObject = CONTRACT_OBJECT
+	}
+
+	pageSize := MIGRATION_PAGE_SIZE
+	if req.PageSize != 0 {
+		pageSize = req.PageSize
+	}
+
+	startKey, endKey, err := compositeKeyRange(keyObject, nil)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "migrateRecords()", err)
+	}
+
+	var after string
+	if req.Bookmark != "" {
+		after, err = decodeBookmark(req.Bookmark)
+		if err != nil || (!bareKey(after) && (after < startKey || after > endKey)) {
+			return nil, invalidArgument("Bookmark", "migrateRecords() : invalid bookmark")
+		}
+		if after > startKey {
+			startKey = after
+		}
+	}
+
+	// the bare keys of the baseline records sort among the keys of this chaincode. Both are read in key order
+	// up to the record after the page, which tells there is a next page.
+	keys, values, err := getBareRecords(stub, keyObject, after, pageSize+1)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "migrateRecords() : Error accessing state", err)
+	}
+
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "migrateRecords() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	for read := 0; read <= pageSize && keysIter.HasNext(); {
+		key, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "migrateRecords() : Error accessing state", iterErr)
+		}
+		if key <= after {
+			continue
+		}
+		keys = append(keys, key)
+		values[key] = value
+		read++
+	}
+	sort.Strings(keys)
+
+	result := MigrationResult{Migrated: []string{}}
+	for i, key := range keys {
+		if i == pageSize {
+			result.Bookmark = encodeBookmark(keys[i-1])
+			break
+		}
+		result.Scanned++
+		var objectID string
+		var migrated bool
+		if keyObject == ASSET_OBJECT {
+			objectID, migrated, err = migrate_asset(stub, key, values[key])
+		} else {
+			objectID, migrated, err = migrate_contract(stub, key, values[key])
+		}
+		if err != nil {
+			return nil, wrapError(ERR_INTERNAL, "migrateRecords()", err)
+		}
+		if migrated {
+			result.Migrated = append(result.Migrated, objectID)
+		}
+	}
+
+	if err = emitMigrationEvent(stub, req.ObjectType, result.Migrated); err != nil {
+		return nil, err
+	}
+	logFor(stub, "").info("records migrated", "objectType", req.ObjectType, "scanned", result.Scanned, "migrated", len(result.Migrated))
+	return json.Marshal(result)
+}
+
+// getBareRecords returns in key order up to limit records of the baseline chaincode stored under the bare serial
+// number of an asset or ID of a contract, with a key after the given one. A baseline record holds its own key as
+// Serialno or Contractid, which tells it from the other bare keys, e.g. _assestindex. The scan starts after the
+// given key and jumps over the composite keys of each object type it meets rather than reading them.
+func getBareRecords(stub shim.ChaincodeStubInterface, keyObject string, after string, limit int) ([]string, map[string][]byte, error) {
+
+	var keys []string
+	records := make(map[string][]byte)
+	startKey := after
+	for {
+		keysIter, err := stub.RangeQueryState(startKey, string(maxUnicodeRuneValue))
+		if err != nil {
+			return nil, nil, err
+		}
+		next := ""
+		for len(keys) < limit && keysIter.HasNext() {
+			key, value, err := keysIter.Next()
+			if err != nil {
+				keysIter.Close()
+				return nil, nil, err
+			}
+			if key <= after {
+				continue
+			}
+			if !bareKey(key) {
+				// the keys of an object type sit between its name followed by the separator and the next rune
+				next = key[:strings.Index(key, compositeKeySeparator)] + string(rune(minUnicodeRuneValue+1))
+				break
+			}
+			var probe struct {
+				Serialno   string
+				Contractid string
+			}
+			if json.Unmarshal(value, &probe) != nil {
+				continue
+			}
+			if (keyObject == ASSET_OBJECT && probe.Serialno == key) || (keyObject == CONTRACT_OBJECT && probe.Serialno == "" && probe.Contractid == key) {
+				keys = append(keys, key)
+				records[key] = value
+			}
+		}
+		keysIter.Close()
+		if next == "" {
+			return keys, records, nil
+		}
+		startKey = next
+	}
+}
+
+// bareKey reports whether a ledger key is not a composite key, as the keys of the baseline chaincode
+func bareKey(key string) bool {
+	return key != "" && !strings.Contains(key, compositeKeySeparator)
+}
+
+// migrate_asset - Rewrites a stored asset in the current schema, leaving up to date records untouched.
+func migrate_asset(stub shim.ChaincodeStubInterface, key string, before []byte) (string, bool, error) {
+
+	var stored AssetObject
+	if err := json.Unmarshal(before, &stored); err != nil {
+		return "", false, errors.New("invalid asset record " + key + " : " + err.Error())
+	}
+	if stored.SchemaVersion == ASSET_SCHEMA_VERSION && !bareKey(key) {
+		return stored.Serialno, false, nil
+	}
+	ast, err := decodeAsset(before)
+	if err != nil {
+		return "", false, err
+	}
+	after, err := json.Marshal(ast)
+	if err != nil {
+		return "", false, err
+	}
+	if bareKey(key) {
+		assetKey, err := getAssetKey(ast.Serialno)
+		if err != nil {
+			return "", false, err
+		}
+		return ast.Serialno, true, move_migrated(stub, key, assetKey, HISTORY_ASSET, ast.Serialno, before, after, assetIndexEntries(ast))
+	}
+	return ast.Serialno, true, write_migrated(stub, key, HISTORY_ASSET, ast.Serialno, before, after, assetIndexEntries(stored), assetIndexEntries(ast))
+}
+
+// migrate_contract - Rewrites a stored contract in the current schema, leaving up to date records untouched.
+func migrate_contract(stub shim.ChaincodeStubInterface, key string, before []byte) (string, bool, error) {
+
+	var stored SalesContractObject
+	if err := json.Unmarshal(before, &stored); err != nil {
+		return "", false, errors.New("invalid contract record " + key + " : " + err.Error())
+	}
+	if stored.SchemaVersion == CONTRACT_SCHEMA_VERSION && !bareKey(key) {
+		return stored.Contractid, false, nil
+	}
+	sc, err := decodeContract(before)
+	if err != nil {
+		return "", false, err
+	}
+	after, err := json.Marshal(sc)
+	if err != nil {
+		return "", false, err
+	}
+	if bareKey(key) {
+		contractKey, err := getContractKey(sc.Contractid)
+		if err != nil {
+			return "", false, err
+		}
+		return sc.Contractid, true, move_migrated(stub, key, contractKey, HISTORY_CONTRACT, sc.Contractid, before, after, contractIndexEntries(sc))
+	}
+	return sc.Contractid, true, write_migrated(stub, key, HISTORY_CONTRACT, sc.Contractid, before, after, contractIndexEntries(stored), contractIndexEntries(sc))
+}
+
+// move_migrated - Stores a baseline record migrated from its bare key under its composite key, which it must
+//				   not collide with, and deletes the bare key. The baseline kept no indexes.
+func move_migrated(stub shim.ChaincodeStubInterface, bareKey string, key string, objectType string, objectID string, before []byte, after []byte, newEntries []indexEntry) error {
+
+	existing, err := stub.GetState(key)
+	if err != nil {
+		return errors.New("Failed to get " + objectType + " " + objectID)
+	}
+	if existing != nil {
+		return conflict(objectID, objectType+" "+objectID+" is stored both under its bare key and its key")
+	}
+	if err = write_migrated(stub, key, objectType, objectID, before, after, nil, newEntries); err != nil {
+		return err
+	}
+	if err = stub.DelState(bareKey); err != nil {
+		return errors.New("Error deleting the bare key of " + objectType + " " + objectID + " : " + err.Error())
+	}
+	return nil
+}
+
+// write_migrated - Stores a migrated record along with its indexes and history. Unlike save_asset and
+//					save_changes it keeps the record as it is, a migration is not a change of the object.
+func write_migrated(stub shim.ChaincodeStubInterface, key string, objectType string, objectID string, before []byte, after []byte, oldEntries []indexEntry, newEntries []indexEntry) error {
+
+	if err := stub.PutState(key, after); err != nil {
+		return errors.New("Error storing " + objectType + " " + objectID + " : " + err.Error())
+	}
+	if err := updateIndexes(stub, objectID, oldEntries, newEntries); err != nil {
+		return errors.New("Error storing " + objectType + " indexes of " + objectID + " : " + err.Error())
+	}
+	if err := writeHistory(stub, objectType, objectID, getActor(stub), before, after); err != nil {
+		return errors.New("Error storing " + objectType + " history of " + objectID + " : " + err.Error())
+	}
+	return nil
+}
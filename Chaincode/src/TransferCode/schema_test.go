@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// putRecord stores a raw record under the key of an asset or a contract, as an older chaincode would have
+func putRecord(t *testing.T, stub *shim.MockStub, key string, record string) {
+
+	stub.MockTransactionStart("legacy")
+	if err := stub.PutState(key, []byte(record)); err != nil {
+		t.Fatalf("PutState failed: %s", err)
+	}
+	stub.MockTransactionEnd("legacy")
+}
+
+const legacyAsset = `{"Serialno":"1005","Partno":"LHTMO","Owner":"bosch","Contractid":""}`
+const legacyContract = `{"Contractid":"C5","Stage":1,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","AssetID":"1005","DocumentID":"D1","TimeStamp":"20160901083000"}`
+
+func TestDecodeRecords(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		asset   bool
+		record  string
+		wantErr string
+	}{
+		{"asset", true, `{"Serialno":"1","Owner":"bosch","SchemaVersion":1}`, ""},
+		{"legacy asset", true, legacyAsset, ""},
+		{"asset not json", true, `{"Serialno":`, "invalid asset record"},
+		{"asset wrong type", true, `{"Serialno":1001}`, "invalid asset record"},
+		{"asset without serial number", true, `{"Owner":"bosch"}`, "Serialno is missing"},
+		{"asset of an unknown status", true, `{"Serialno":"1","Status":"lost","SchemaVersion":3}`, "unknown status lost"},
+		{"asset of a newer schema", true, `{"Serialno":"1","SchemaVersion":4}`, "schema version 4"},
+		{"contract", false, `{"Contractid":"C1","Stage":3,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","AssetID":"1","SchemaVersion":1}`, ""},
+		{"legacy contract", false, legacyContract, ""},
+		{"contract wrong type", false, `{"Contractid":"C1","Stage":"3"}`, "invalid contract record"},
+		{"contract unknown stage", false, `{"Contractid":"C1","Stage":12,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","AssetID":"1"}`, "unknown stage 12"},
+		{"contract missing parties", false, `{"Contractid":"C1","Stage":0,"Seller":"bosch","AssetID":"1"}`, "missing Buyer, Transporter"},
+		{"contract without line items", false, `{"Contractid":"C1","Stage":0,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","SchemaVersion":2}`, "has no line items"},
+		{"contract line without quantity", false, `{"Contractid":"C1","Stage":0,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"LineNo":1,"Partno":"BOLT"}],"SchemaVersion":2}`, "invalid quantity on line 1"},
+		{"contract of a newer schema", false, `{"Contractid":"C1","SchemaVersion":4}`, "schema version 4"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if test.asset {
+				_, err = decodeAsset([]byte(test.record))
+			} else {
+				_, err = decodeContract([]byte(test.record))
+			}
+			checkError(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestReadLegacyRecords(t *testing.T) {
+
+	stub := newStub(t)
+	putRecord(t, stub, "Asset\x001005\x00", legacyAsset)
+	putRecord(t, stub, "Contract\x00C5\x00", legacyContract)
+
+	got, err := query(stub, lht, "readContract", "C5")
+	if err != nil {
+		t.Fatalf("readContract failed: %s", err)
+	}
+	var sc SalesContractObject
+	json.Unmarshal(got, &sc)
+	if sc.SchemaVersion != CONTRACT_SCHEMA_VERSION || sc.CreatedAt != "2016-09-01T08:30:00Z" || sc.StageTimes[STATE_READYFORSHIPMENT] != "2016-09-01T08:30:00Z" {
+		t.Fatalf("expected the legacy contract to be upgraded, got %s", got)
+	}
+	if len(sc.LineItems) != 1 || !reflect.DeepEqual(sc.LineItems[0].AssetIDs, []string{"1005"}) || sc.LineItems[0].Quantity != 1 {
+		t.Fatalf("expected the asset of the legacy contract as its only line item, got %+v", sc.LineItems)
+	}
+
+	// a malformed record is reported, not a panic
+	putRecord(t, stub, "Contract\x00C6\x00", `{"Contractid":"C6","Stage":"open"}`)
+	if _, err = invoke(stub, dhl, "inTransit", "C6"); err == nil || !strings.Contains(err.Error(), "Failed to get contract object") {
+		t.Fatalf("expected a decoding error, got %v", err)
+	}
+
+	// a legacy contract carries on through the lifecycle, it is written in the current schema
+	mustInvoke(t, stub, dhl, "inTransit", "C5")
+	sc = getContract(t, stub, "C5")
+	if sc.CreatedAt != "2016-09-01T08:30:00Z" || sc.StageTimes[STATE_INTRANSIT] != testTime.Format(TIME_FORMAT) {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+	got, _ = query(stub, lht, "listContractsByTime", TIME_CREATED, "2016-09-01T00:00:00Z", "2016-09-02T00:00:00Z")
+	if !strings.Contains(string(got), `"Contractid":"C5"`) {
+		t.Fatalf("expected C5 in its creation time index, got %s", got)
+	}
+}
+
+func TestMigrateBaselineRecords(t *testing.T) {
+
+	// the baseline chaincode stored records under their bare serial number and contract ID
+	stub := newStub(t)
+	putRecord(t, stub, "_assestindex", `["1007"]`)
+	putRecord(t, stub, "1007", `{"Serialno":"1007","Partno":"LHTMO","Owner":"bosch","Contractid":"C6"}`)
+	putRecord(t, stub, "C6", `{"Contractid":"C6","Stage":0,"Buyer":"lht","Transporter":"dhl","Seller":"bosch","AssetID":"1007","DocumentID":"D1","TimeStamp":"20160901083000"}`)
+
+	got, err := invoke(stub, admin, "migrateRecords", "asset", "2")
+	if err != nil {
+		t.Fatalf("migrateRecords failed: %s", err)
+	}
+	var result MigrationResult
+	json.Unmarshal(got, &result)
+	if result.Scanned != 2 || len(result.Migrated) != 1 || result.Migrated[0] != "1007" || result.Bookmark == "" {
+		t.Fatalf("unexpected first page %s", got)
+	}
+	got, _ = invoke(stub, admin, "migrateRecords", "asset", "2", result.Bookmark)
+	result = MigrationResult{}
+	json.Unmarshal(got, &result)
+	if len(result.Migrated) != 0 || result.Bookmark != "" {
+		t.Fatalf("expected the moved asset to be up to date, got %s", got)
+	}
+	got, err = invoke(stub, admin, "migrateRecords", "contract")
+	if err != nil {
+		t.Fatalf("migrateRecords failed: %s", err)
+	}
+	result = MigrationResult{}
+	json.Unmarshal(got, &result)
+	if len(result.Migrated) != 1 || result.Migrated[0] != "C6" {
+		t.Fatalf("unexpected contract migration %s", got)
+	}
+
+	// the records moved under their keys along with their indexes, the bare keys are gone
+	for _, key := range []string{"1007", "C6"} {
+		if _, ok := stub.State[key]; ok {
+			t.Fatalf("expected the bare key %s to be deleted", key)
+		}
+	}
+	if stub.State["_assestindex"] == nil {
+		t.Fatal("expected the other bare keys to be left alone")
+	}
+	if ast := getAsset(t, stub, "1007"); ast.Owner != "bosch" || ast.Contractid != "C6" || ast.SchemaVersion != ASSET_SCHEMA_VERSION {
+		t.Fatalf("unexpected asset %+v", ast)
+	}
+	if sc := getContract(t, stub, "C6"); sc.SchemaVersion != CONTRACT_SCHEMA_VERSION || sc.LineItems[0].AssetIDs[0] != "1007" {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+	got, _ = query(stub, bosch, "listAssetsByOwner", "bosch")
+	if !strings.Contains(string(got), `"Serialno":"1007"`) {
+		t.Fatalf("expected 1007 in the owner index, got %s", got)
+	}
+	got, _ = query(stub, lht, "listContracts", `{"AssetID":"1007"}`)
+	if !strings.Contains(string(got), `"Contractid":"C6"`) {
+		t.Fatalf("expected C6 listed under asset 1007, got %s", got)
+	}
+
+	// a record stored under both keys is left for an operator to sort out
+	putRecord(t, stub, "1001", `{"Serialno":"1001","Partno":"LHTMO","Owner":"lht","Contractid":""}`)
+	_, err = invoke(stub, admin, "migrateRecords", "asset")
+	checkError(t, err, "asset 1001 is stored both under its bare key and its key")
+	if getAsset(t, stub, "1001").Owner != "bosch" {
+		t.Fatal("expected asset 1001 to be left as it is")
+	}
+}
+
+func TestMigrateRecords(t *testing.T) {
+
+	stub := newStub(t)
+	putRecord(t, stub, "Asset\x001005\x00", legacyAsset)
+	putRecord(t, stub, "Asset\x001006\x00", `{"Serialno":"1006","Partno":"LHTMO","Owner":"lht","Contractid":""}`)
+	putRecord(t, stub, "Contract\x00C5\x00", legacyContract)
+
+	// only an admin migrates
+	_, err := invoke(stub, arbiter, "migrateRecords", "asset")
+	checkError(t, err, "Permission Denied")
+
+	// 1001, 1002 are current, 1005 and 1006 are migrated over two pages
+	got, err := invoke(stub, admin, "migrateRecords", "asset", "3")
+	if err != nil {
+		t.Fatalf("migrateRecords failed: %s", err)
+	}
+	var result MigrationResult
+	json.Unmarshal(got, &result)
+	if result.Scanned != 3 || len(result.Migrated) != 1 || result.Migrated[0] != "1005" || result.Bookmark == "" {
+		t.Fatalf("unexpected first page %s", got)
+	}
+	var ev MigrationEvent
+	checkEvent(t, stub, EVENT_RECORDS_MIGRATED, &ev)
+	if ev.ObjectType != HISTORY_ASSET || len(ev.ObjectIDs) != 1 {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	got, err = invoke(stub, admin, "migrateRecords", "asset", "3", result.Bookmark)
+	if err != nil {
+		t.Fatalf("migrateRecords failed: %s", err)
+	}
+	result = MigrationResult{}
+	json.Unmarshal(got, &result)
+	if result.Scanned != 1 || len(result.Migrated) != 1 || result.Migrated[0] != "1006" || result.Bookmark != "" {
+		t.Fatalf("unexpected last page %s", got)
+	}
+	if string(stub.State["Asset\x001006\x00"]) != `{"Serialno":"1006","Partno":"LHTMO","Owner":"lht","Contractid":"","Status":"active","RecallID":"","SchemaVersion":3}` {
+		t.Fatalf("asset 1006 was not rewritten, got %s", stub.State["Asset\x001006\x00"])
+	}
+
+	got, err = invoke(stub, admin, "migrateRecords", "contract")
+	if err != nil {
+		t.Fatalf("migrateRecords failed: %s", err)
+	}
+	result = MigrationResult{}
+	json.Unmarshal(got, &result)
+	if result.Scanned != 2 || len(result.Migrated) != 1 || result.Migrated[0] != "C5" {
+		t.Fatalf("unexpected contract migration %s", got)
+	}
+	var stored SalesContractObject
+	json.Unmarshal(stub.State["Contract\x00C5\x00"], &stored)
+	if stored.SchemaVersion != CONTRACT_SCHEMA_VERSION || stored.UpdatedAt != "2016-09-01T08:30:00Z" {
+		t.Fatalf("contract C5 was not rewritten, got %s", stub.State["Contract\x00C5\x00"])
+	}
+	got, _ = query(stub, lht, "listContractsByTime", "1", "", "2016-09-01T08:30:00Z")
+	if !strings.Contains(string(got), `"Contractid":"C5"`) {
+		t.Fatalf("expected C5 in its stage time index, got %s", got)
+	}
+
+	// a second run has nothing left to do
+	got, _ = invoke(stub, admin, "migrateRecords", "contract")
+	result = MigrationResult{}
+	json.Unmarshal(got, &result)
+	if len(result.Migrated) != 0 {
+		t.Fatalf("expected nothing to migrate, got %s", got)
+	}
+
+	history, _ := query(stub, lht, "getHistory", "contract", "C5")
+	var page HistoryPage
+	json.Unmarshal(history, &page)
+	if len(page.Records) != 1 || page.Records[0].Function != "migrateRecords" {
+		t.Fatalf("expected the migration in the history of C5, got %s", history)
+	}
+
+	for _, args := range [][]string{{}, {"vehicle"}, {"asset", "0"}, {"asset", "", "not a bookmark"}} {
+		if _, err = invoke(stub, admin, "migrateRecords", args...); err == nil {
+			t.Fatalf("expected migrateRecords(%q) to fail", args)
+		}
+	}
+}
+
+// countingStub counts the keys the range queries of a stub read
+type countingStub struct {
+	*shim.MockStub
+	read int
+}
+
+type countingIterator struct {
+	shim.StateRangeQueryIteratorInterface
+	stub *countingStub
+}
+
+func (s *countingStub) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	iter, err := s.MockStub.RangeQueryState(startKey, endKey)
+	return countingIterator{iter, s}, err
+}
+
+func (i countingIterator) Next() (string, []byte, error) {
+	i.stub.read++
+	return i.StateRangeQueryIteratorInterface.Next()
+}
+
+func TestBareRecordScan(t *testing.T) {
+
+	// the scan reads one key of each object type it jumps over, and stops at the limit
+	stub := newStub(t)
+	for _, serialNo := range []string{"0001", "0002", "0003"} {
+		putRecord(t, stub, serialNo, `{"Serialno":"`+serialNo+`","Partno":"LHTMO","Owner":"bosch"}`)
+	}
+	putRecord(t, stub, "Z1", `{"Serialno":"Z1","Partno":"LHTMO","Owner":"bosch"}`)
+	counting := &countingStub{MockStub: stub}
+	keys, _, err := getBareRecords(counting, ASSET_OBJECT, "0001", 10)
+	if err != nil || !reflect.DeepEqual(keys, []string{"0002", "0003", "Z1"}) {
+		t.Fatalf("expected the bare assets after 0001, got %v, %v", keys, err)
+	}
+	read := counting.read
+	for i := 10; i < 30; i++ {
+		mustInvoke(t, stub, bosch, "initAssset", fmt.Sprintf("20%02d", i), "LHTMO", "bosch")
+	}
+	counting.read = 0
+	if keys, _, _ = getBareRecords(counting, ASSET_OBJECT, "0001", 10); len(keys) != 3 || counting.read != read {
+		t.Fatalf("expected the keys of the new assets to be skipped, read %d keys instead of %d", counting.read, read)
+	}
+	counting.read = 0
+	if keys, _, _ = getBareRecords(counting, ASSET_OBJECT, "", 1); len(keys) != 1 || keys[0] != "0001" || counting.read != 1 {
+		t.Fatalf("expected the scan to stop at 0001, got %v after %d reads", keys, counting.read)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 SLA - a contract may set deadlines for the shipment to be ready, picked up and delivered. Whenever a contract
+//		   changes stage, the transaction time is compared with the deadlines not met yet. A deadline met late,
+//		   or passed while the contract moves on without meeting it, e.g. when it is cancelled or disputed,
+//		   records a breach on the contract naming the party responsible and the days it was late by. The
+//		   delivery is met by whichever of shipmentReached or the delivery of the last line items comes first.
+//		   A deadline is only missed once its party could act, and records one breach at most.
+//==============================================================================================================================
+const DEADLINE_READY = "ReadyBy"
+const DEADLINE_PICKUP = "PickupBy"
+const DEADLINE_DELIVER = "DeliverBy"
+
+// Deadlines struct - times in RFC 3339, a deadline left empty is not enforced
+type Deadlines struct {
+	ReadyBy   string // the seller readies the shipment
+	PickupBy  string // the transporter takes the shipment in transit
+	DeliverBy string // the transporter brings the shipment to the buyer
+}
+
+// Breach struct - a deadline a contract missed
+type Breach struct {
+	Contractid string
+	Deadline   string // DEADLINE_READY, DEADLINE_PICKUP or DEADLINE_DELIVER
+	Due        string
+	MetAt      string // transaction time the deadline was met, empty for a deadline missed without being met
+	Stage      int    // stage entered by the transaction recording the breach
+	LateDays   int // days late, a day begun counts in full
+	Party      string
+	Role       string
+	TxID       string
+}
+
+// sla ties a deadline to the stages meeting it, the stage from which its party can meet it and the role
+// answering for it
+type sla struct {
+	deadline string
+	stages   []int
+	from     int
+	role     string
+}
+
+var slas = []sla{
+	{DEADLINE_READY, []int{STATE_READYFORSHIPMENT}, STATE_OPEN, SELLER},
+	{DEADLINE_PICKUP, []int{STATE_INTRANSIT}, STATE_READYFORSHIPMENT, TRANSPORTER},
+	{DEADLINE_DELIVER, []int{STATE_SHIPMENT_REACHED, STATE_SHIPMENT_DELIVERED}, STATE_INTRANSIT, TRANSPORTER},
+}
+
+// meets reports whether entering the stage meets the deadline
+func (s sla) meets(stage int) bool {
+
+	for _, met := range s.stages {
+		if met == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// metIn reports whether one of the stages entered met the deadline
+func (s sla) metIn(stageTimes map[int]string) bool {
+
+	for _, met := range s.stages {
+		if _, entered := stageTimes[met]; entered {
+			return true
+		}
+	}
+	return false
+}
+
+// breached reports whether the contract records a breach of the deadline
+func (sc SalesContractObject) breached(deadline string) bool {
+
+	for _, breach := range sc.Breaches {
+		if breach.Deadline == deadline {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the due time of a deadline
+func (d Deadlines) get(deadline string) string {
+
+	switch deadline {
+	case DEADLINE_READY:
+		return d.ReadyBy
+	case DEADLINE_PICKUP:
+		return d.PickupBy
+	case DEADLINE_DELIVER:
+		return d.DeliverBy
+	}
+	return ""
+}
+
+// normalize converts the deadlines to UTC and checks they come in the order of the stages
+func (d *Deadlines) normalize() error {
+
+	var err error
+	for _, due := range []*string{&d.ReadyBy, &d.PickupBy, &d.DeliverBy} {
+		if *due, err = parseTime(*due); err != nil {
+			return wrapError(ERR_INVALID_ARGUMENT, "Deadlines", err)
+		}
+	}
+	last := ""
+	for _, s := range slas {
+		due := d.get(s.deadline)
+		if due == "" {
+			continue
+		}
+		if due < last {
+			return invalidArgument("Deadlines", "Deadlines : "+s.deadline+" is before an earlier deadline")
+		}
+		last = due
+	}
+	return nil
+}
+
+// check_deadlines - Records a breach for each deadline passed and not met before the stage the contract enters,
+//					 met late by the stage or missed by a party that could meet it. old is nil for a new contract.
+func (sc *SalesContractObject) check_deadlines(old *SalesContractObject, txTime time.Time, txID string) {
+
+	if old == nil || old.Stage == sc.Stage {
+		return
+	}
+	parties := map[string]string{SELLER: sc.Seller, TRANSPORTER: sc.Transporter, BUYER: sc.Buyer}
+	for _, s := range slas {
+		due, err := time.Parse(TIME_FORMAT, sc.Deadlines.get(s.deadline))
+		if err != nil || !txTime.After(due) || s.metIn(old.StageTimes) || sc.breached(s.deadline) {
+			continue
+		}
+		metAt := ""
+		if s.meets(sc.Stage) {
+			metAt = txTime.Format(TIME_FORMAT)
+		} else if _, canAct := old.StageTimes[s.from]; !canAct && s.from != STATE_OPEN {
+			continue // the party can't act yet, the breach of an earlier deadline answers for the delay
+		}
+		lateDays := int(math.Ceil(txTime.Sub(due).Hours() / 24))
+		sc.Breaches = append(sc.Breaches, Breach{sc.Contractid, s.deadline, due.Format(TIME_FORMAT), metAt, sc.Stage, lateDays, parties[s.role], s.role, txID})
+	}
+}
+
+// breachIndexEntries returns the index entries listing a contract under each party it records a breach of
+func breachIndexEntries(sc SalesContractObject) []indexEntry {
+
+	entries := []indexEntry{}
+	for _, breach := range sc.Breaches {
+		entries = append(entries, indexEntry{INDEX_CONTRACT_BREACH, breach.Party})
+	}
+	return entries
+}
+
+// BreachesRequest struct - the arguments of listBreaches
+type BreachesRequest struct {
+	Party string // every party the caller may read when empty
+}
+
+// listBreaches returns the breaches of every contract the caller may read, or those of one party, by contract and
+// in time order.
+// args: [party]
+func (t *SimpleChaincode) listBreaches(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req BreachesRequest
+	if err := decodeRequest("listBreaches", args, &req); err != nil {
+		return nil, err
+	}
+	party := req.Party
+
+	var keyParts []string
+	if party != "" {
+		keyParts = []string{party}
+	}
+	startKey, endKey, err := compositeKeyRange(INDEX_CONTRACT_BREACH, keyParts)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listBreaches()", err)
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "listBreaches() : Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	contractIDs := make(map[string]bool)
+	for keysIter.HasNext() {
+		key, _, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "listBreaches() : Error accessing state", iterErr)
+		}
+		_, attributes, err := splitCompositeKey(key)
+		if err != nil || len(attributes) != 2 {
+			continue
+		}
+		contractIDs[attributes[1]] = true
+	}
+	ids := []string{}
+	for contractID := range contractIDs {
+		ids = append(ids, contractID)
+	}
+	sort.Strings(ids)
+
+	r := t.get_reader(stub)
+	breaches := []Breach{}
+	for _, contractID := range ids {
+		sc, err := getContractObject(stub, contractID)
+		if err != nil {
+			return nil, err
+		}
+		if !r.canReadContract(sc) {
+			continue
+		}
+		for _, breach := range sc.Breaches {
+			if party == "" || breach.Party == party {
+				breaches = append(breaches, breach)
+			}
+		}
+	}
+	return json.Marshal(breaches)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const day = 24 * time.Hour
+
+func listBreaches(t *testing.T, stub *shim.MockStub, args ...string) []Breach {
+
+	got, err := query(stub, auditor, "listBreaches", args...)
+	if err != nil {
+		t.Fatalf("listBreaches failed: %s", err)
+	}
+	var breaches []Breach
+	json.Unmarshal(got, &breaches)
+	return breaches
+}
+
+func TestDeadlines(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],
+		"Deadlines":{"ReadyBy":"2016-11-02T10:30:00Z","PickupBy":"2016-11-03T12:30:00+02:00","DeliverBy":"2016-11-04T10:30:00Z"}}`)
+	if sc := getContract(t, stub, "C2"); sc.Deadlines.PickupBy != "2016-11-03T10:30:00Z" {
+		t.Fatalf("expected the deadlines in UTC, got %+v", sc.Deadlines)
+	}
+
+	// ready on time, picked up an hour late, delivered three days late
+	stub.MockTxTimestamp(testTime.Add(day))
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	stub.MockTxTimestamp(testTime.Add(2*day + time.Hour))
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+	var ev ContractEvent
+	checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &ev)
+	if len(ev.Breaches) != 1 || ev.Breaches[0].Deadline != DEADLINE_PICKUP {
+		t.Fatalf("expected the pickup breach in the event, got %+v", ev)
+	}
+	stub.MockTxTimestamp(testTime.Add(6 * day))
+	mustInvoke(t, stub, dhl, "shipmentReached", "C2")
+	stub.MockTxTimestamp(testTime.Add(7 * day))
+	mustInvoke(t, stub, lht, "shipmentDelivered", "C2")
+
+	breaches := getContract(t, stub, "C2").Breaches
+	if len(breaches) != 2 {
+		t.Fatalf("expected two breaches, got %+v", breaches)
+	}
+	pickup := Breach{"C2", DEADLINE_PICKUP, "2016-11-03T10:30:00Z", "2016-11-03T11:30:00Z", STATE_INTRANSIT, 1, "dhl", TRANSPORTER, breaches[0].TxID}
+	deliver := Breach{"C2", DEADLINE_DELIVER, "2016-11-04T10:30:00Z", "2016-11-07T10:30:00Z", STATE_SHIPMENT_REACHED, 3, "dhl", TRANSPORTER, breaches[1].TxID}
+	if breaches[0] != pickup || breaches[1] != deliver || breaches[0].TxID == "" {
+		t.Fatalf("unexpected breaches %+v", breaches)
+	}
+
+	if got := listBreaches(t, stub); len(got) != 2 {
+		t.Fatalf("expected every breach listed, got %+v", got)
+	}
+	if got := listBreaches(t, stub, "dhl"); len(got) != 2 || got[1].Deadline != DEADLINE_DELIVER {
+		t.Fatalf("expected the breaches of dhl, got %+v", got)
+	}
+	if got := listBreaches(t, stub, "bosch"); len(got) != 0 {
+		t.Fatalf("expected no breach of bosch, got %+v", got)
+	}
+
+	// C1 has no deadlines
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	if sc := getContract(t, stub, "C1"); len(sc.Breaches) != 0 {
+		t.Fatalf("unexpected breaches %+v", sc.Breaches)
+	}
+}
+
+func TestSellerBreach(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],
+		"Deadlines":{"ReadyBy":"2016-11-01T12:00:00Z"}}`)
+	stub.MockTxTimestamp(testTime.Add(day))
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	if got := listBreaches(t, stub, "bosch"); len(got) != 1 || got[0].Role != SELLER || got[0].LateDays != 1 {
+		t.Fatalf("expected a breach of bosch, got %+v", got)
+	}
+}
+
+func TestDeliveryOfLineItemsMeetsDeadline(t *testing.T) {
+
+	// the buyer receives the line items in transit, the contract is delivered without being reported reached
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],
+		"Deadlines":{"DeliverBy":"2016-11-03T10:30:00Z"}}`)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+	stub.MockTxTimestamp(testTime.Add(4 * day))
+	mustInvoke(t, stub, lht, "deliverLineItems", "C2", "[1]")
+
+	sc := getContract(t, stub, "C2")
+	if sc.Stage != STATE_SHIPMENT_DELIVERED || len(sc.Breaches) != 1 {
+		t.Fatalf("expected the delivery to be met late, got %+v", sc)
+	}
+	deliver := Breach{"C2", DEADLINE_DELIVER, "2016-11-03T10:30:00Z", "2016-11-05T10:30:00Z", STATE_SHIPMENT_DELIVERED, 2, "dhl", TRANSPORTER, sc.Breaches[0].TxID}
+	if sc.Breaches[0] != deliver || deliver.TxID == "" {
+		t.Fatalf("expected the delivery to be met late, got %+v", sc.Breaches)
+	}
+}
+
+func TestMissedDeadlines(t *testing.T) {
+
+	// a contract cancelled after its ready deadline records the breach of the seller
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],
+		"Deadlines":{"ReadyBy":"2016-11-02T10:30:00Z","PickupBy":"2016-11-03T10:30:00Z"}}`)
+	stub.MockTxTimestamp(testTime.Add(3 * day))
+	mustInvoke(t, stub, bosch, "cancelContract", "C2", "out of stock", "")
+	sc := getContract(t, stub, "C2")
+	if len(sc.Breaches) != 1 {
+		t.Fatalf("expected only the seller to miss its deadline, got %+v", sc.Breaches)
+	}
+	ready := Breach{"C2", DEADLINE_READY, "2016-11-02T10:30:00Z", "", STATE_CANCELLED, 2, "bosch", SELLER, sc.Breaches[0].TxID}
+	if sc.Breaches[0] != ready {
+		t.Fatalf("expected only the seller to miss its deadline, got %+v", sc.Breaches)
+	}
+
+	// a dispute raised after the delivery deadline records the breach of the transporter, once
+	stub = newStub(t)
+	mustInvoke(t, stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],
+		"Deadlines":{"DeliverBy":"2016-11-03T10:30:00Z"}}`)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C2", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C2")
+	stub.MockTxTimestamp(testTime.Add(3 * day))
+	mustInvoke(t, stub, lht, "raiseDispute", "C2", "shipment lost", "E1")
+	if got := listBreaches(t, stub, "dhl"); len(got) != 1 || got[0].MetAt != "" || got[0].Stage != STATE_DISPUTED || got[0].LateDays != 1 {
+		t.Fatalf("expected the missed delivery of dhl, got %+v", got)
+	}
+	mustInvoke(t, stub, arbiter, "resolveDispute", "C2", RESOLUTION_DELIVER, "found", "")
+	stub.MockTxTimestamp(testTime.Add(5 * day))
+	mustInvoke(t, stub, lht, "shipmentDelivered", "C2")
+	if got := listBreaches(t, stub, "dhl"); len(got) != 1 {
+		t.Fatalf("expected the delivery deadline to be recorded once, got %+v", got)
+	}
+}
+
+func TestInvalidDeadlines(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		deadlines string
+		wantErr   string
+	}{
+		{"not a time", `{"ReadyBy":"tomorrow"}`, "RFC 3339"},
+		{"out of order", `{"ReadyBy":"2016-11-05T00:00:00Z","PickupBy":"2016-11-04T00:00:00Z"}`, "PickupBy is before an earlier deadline"},
+		{"out of order with a gap", `{"ReadyBy":"2016-11-05T00:00:00Z","DeliverBy":"2016-11-04T00:00:00Z"}`, "DeliverBy is before an earlier deadline"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newStub(t)
+			_, err := invoke(stub, bosch, "initContract", `{"Contractid":"C2","Buyer":"lht","Transporter":"dhl","Seller":"bosch","LineItems":[{"AssetIDs":["1002"]}],"Deadlines":`+test.deadlines+`}`)
+			checkError(t, err, test.wantErr)
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 Tracking - while a contract is in transit its transporter records checkpoints: where the shipment is, who holds
+//				it, under which carrier reference and what its sensors read. Checkpoints are numbered per contract
+//				and chained, each one carries the SHA-256 hash of the one before it, so the chain of custody
+//				returned by getTrackingTimeline can be checked end to end.
+//==============================================================================================================================
+const SENSOR_TEMPERATURE = "temperature"
+const SENSOR_SHOCK = "shock"
+const SENSOR_HUMIDITY = "humidity"
+
+var SENSOR_TYPES = map[string]bool{SENSOR_TEMPERATURE: true, SENSOR_SHOCK: true, SENSOR_HUMIDITY: true}
+
+const MAX_SENSOR_READINGS = 50
+
+// Location struct - a named place, coordinates, or both
+type Location struct {
+	Name      string
+	Latitude  *float64
+	Longitude *float64
+}
+
+// SensorReading struct
+type SensorReading struct {
+	Sensor string // SENSOR_TEMPERATURE, SENSOR_SHOCK or SENSOR_HUMIDITY
+	Value  float64
+	Unit   string // e.g. C, g, %
+}
+
+// Checkpoint struct
+type Checkpoint struct {
+	Contractid string
+	Seq        int // 1 for the first checkpoint of the contract
+	Location   Location
+	Custodian  string // who holds the shipment after the checkpoint
+	Handoff    bool   // whether custody changed hands at the checkpoint
+	CarrierRef string // e.g. the flight, vessel or truck the shipment travels on
+	Readings   []SensorReading
+	RecordedAt string // time the checkpoint was taken on site, in RFC 3339
+	TimeStamp  string // transaction time in RFC 3339
+	Recorder   string
+	TxID       string
+	PrevHash   string // Hash of the previous checkpoint, empty for the first one
+	Hash       string // SHA-256 of the checkpoint with an empty Hash
+}
+
+// TrackingTimeline struct - the response of getTrackingTimeline
+type TrackingTimeline struct {
+	Contractid  string
+	Checkpoints []Checkpoint
+	Verified    bool   // whether every checkpoint matches its hash and links to the one before it
+	BrokenAt    int    // Seq of the first checkpoint failing the check, 0 when Verified
+	Custodian   string // who holds the shipment after the last checkpoint
+}
+
+// CheckpointRequest struct - the arguments of recordCheckpoint
+type CheckpointRequest struct {
+	Contractid string     `validate:"required"`
+	Checkpoint Checkpoint `validate:"required"`
+}
+
+// recordCheckpoint records where a shipment in transit is. Only the transporter of the contract records checkpoints.
+// args: contractid, checkpoint as a JSON object, e.g. {"Location":{"Name":"FRA","Latitude":50.03,"Longitude":8.57},
+// "Custodian":"fraport","CarrierRef":"LH8160","Readings":[{"Sensor":"temperature","Value":4.5,"Unit":"C"}]}
+func (t *SimpleChaincode) recordCheckpoint(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req CheckpointRequest
+	if err := decodeRequest("recordCheckpoint", args, &req); err != nil {
+		return nil, err
+	}
+	cp := req.Checkpoint
+	if err := cp.validate(); err != nil {
+		return nil, wrapError(ERR_INVALID_ARGUMENT, "recordCheckpoint()", err)
+	}
+
+	sc, err := getContractObject(stub, req.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if sc.Stage != STATE_INTRANSIT {
+		logFor(stub, sc.Contractid).debug("checkpoints may not be recorded from the stage", "stage", sc.Stage)
+		return nil, permissionDenied("recordCheckpoint")
+	}
+	if err = t.check_caller(stub, sc.Transporter, TRANSPORTER); err != nil {
+		logFor(stub, sc.Contractid).debug("caller may not record checkpoints", "reason", err)
+		return nil, permissionDenied("recordCheckpoint")
+	}
+
+	checkpoints, err := getCheckpoints(stub, sc.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "recordCheckpoint()", err)
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the shipment is in the hands of the transporter until the first handoff
+	custodian := sc.Transporter
+	cp.Seq = 1
+	cp.PrevHash = ""
+	if n := len(checkpoints); n > 0 {
+		custodian = checkpoints[n-1].Custodian
+		cp.Seq = checkpoints[n-1].Seq + 1
+		cp.PrevHash = checkpoints[n-1].Hash
+	}
+	if cp.Custodian == "" {
+		cp.Custodian = custodian
+	}
+	cp.Handoff = cp.Custodian != custodian
+	cp.Contractid = sc.Contractid
+	cp.TimeStamp = txTime.Format(TIME_FORMAT)
+	cp.Recorder = getActor(stub)
+	cp.TxID = stub.GetTxID()
+	if cp.Hash, err = cp.digest(); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "recordCheckpoint()", err)
+	}
+
+	checkpointKey, err := getCheckpointKey(cp.Contractid, cp.Seq)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "recordCheckpoint()", err)
+	}
+	buff, err := json.Marshal(cp)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "recordCheckpoint() : Cannot create checkpoint record", err)
+	}
+	if err = stub.PutState(checkpointKey, buff); err != nil {
+		return nil, wrapError(ERR_INTERNAL, "recordCheckpoint() : write error while inserting record", err)
+	}
+	if err = emitCheckpointEvent(stub, cp); err != nil {
+		return nil, err
+	}
+	logFor(stub, sc.Contractid).info("checkpoint recorded", "seq", cp.Seq)
+	return nil, nil
+}
+
+// getTrackingTimeline returns the checkpoints of a contract in order and checks the chain they form.
+// args: contractid
+func (t *SimpleChaincode) getTrackingTimeline(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("getTrackingTimeline", args, &req); err != nil {
+		return nil, err
+	}
+	sc, err := t.check_contract_reader(stub, "getTrackingTimeline", req.Contractid)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints, err := getCheckpoints(stub, sc.Contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "getTrackingTimeline()", err)
+	}
+
+	timeline := TrackingTimeline{Contractid: sc.Contractid, Checkpoints: checkpoints, Verified: true, Custodian: sc.Transporter}
+	prevHash := ""
+	for i, cp := range checkpoints {
+		hash, err := cp.digest()
+		if err != nil || hash != cp.Hash || cp.PrevHash != prevHash || cp.Seq != i+1 {
+			timeline.Verified = false
+			timeline.BrokenAt = i + 1
+			break
+		}
+		prevHash = cp.Hash
+	}
+	if n := len(checkpoints); n > 0 {
+		timeline.Custodian = checkpoints[n-1].Custodian
+	}
+	return json.Marshal(timeline)
+}
+
+// getCheckpoints reads the checkpoints of a contract, in sequence order
+func getCheckpoints(stub shim.ChaincodeStubInterface, contractID string) ([]Checkpoint, error) {
+
+	startKey, endKey, err := compositeKeyRange(CHECKPOINT_OBJECT, []string{contractID})
+	if err != nil {
+		return nil, err
+	}
+	keysIter, err := stub.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error accessing state", err)
+	}
+	defer keysIter.Close()
+
+	checkpoints := []Checkpoint{}
+	for keysIter.HasNext() {
+		_, value, iterErr := keysIter.Next()
+		if iterErr != nil {
+			return nil, wrapError(ERR_INTERNAL, "Error accessing state", iterErr)
+		}
+		var cp Checkpoint
+		if err = json.Unmarshal(value, &cp); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "invalid checkpoint record", err)
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	sort.Slice(checkpoints, func(i, j int) bool { return checkpoints[i].Seq < checkpoints[j].Seq })
+	return checkpoints, nil
+}
+
+// validate checks the fields of a checkpoint given by the transporter
+func (cp *Checkpoint) validate() error {
+
+	loc := cp.Location
+	if loc.Name == "" && loc.Latitude == nil && loc.Longitude == nil {
+		return errors.New("Location needs a Name or coordinates")
+	}
+	if (loc.Latitude == nil) != (loc.Longitude == nil) {
+		return errors.New("Location needs both Latitude and Longitude")
+	}
+	if loc.Latitude != nil && (*loc.Latitude < -90 || *loc.Latitude > 90 || *loc.Longitude < -180 || *loc.Longitude > 180) {
+		return errors.New("Location coordinates are out of range")
+	}
+	if len(cp.Readings) > MAX_SENSOR_READINGS {
+		return fmt.Errorf("a checkpoint holds up to %d sensor readings", MAX_SENSOR_READINGS)
+	}
+	for _, reading := range cp.Readings {
+		if !SENSOR_TYPES[reading.Sensor] {
+			return errors.New("Sensor should be " + SENSOR_TEMPERATURE + ", " + SENSOR_SHOCK + " or " + SENSOR_HUMIDITY + " : " + reading.Sensor)
+		}
+	}
+	recordedAt, err := parseTime(cp.RecordedAt)
+	if err != nil {
+		return wrapError(ERR_INTERNAL, "RecordedAt", err)
+	}
+	cp.RecordedAt = recordedAt
+	return nil
+}
+
+// digest returns the SHA-256 of the checkpoint with an empty Hash, as hex
+func (cp Checkpoint) digest() (string, error) {
+
+	cp.Hash = ""
+	buff, err := json.Marshal(cp)
+	if err != nil {
+		return "", wrapError(ERR_INTERNAL, "Cannot hash checkpoint", err)
+	}
+	sum := sha256.Sum256(buff)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func getTimeline(t *testing.T, stub *shim.MockStub, contractID string) TrackingTimeline {
+
+	got, err := query(stub, lht, "getTrackingTimeline", contractID)
+	if err != nil {
+		t.Fatalf("getTrackingTimeline failed: %s", err)
+	}
+	var timeline TrackingTimeline
+	if err = json.Unmarshal(got, &timeline); err != nil {
+		t.Fatalf("cannot decode timeline %s: %s", got, err)
+	}
+	return timeline
+}
+
+func TestRecordCheckpoint(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C1")
+
+	mustInvoke(t, stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Name":"STR","Latitude":48.69,"Longitude":9.22},"CarrierRef":"TRUCK-7",
+		"Readings":[{"Sensor":"temperature","Value":4.5,"Unit":"C"},{"Sensor":"shock","Value":0.2,"Unit":"g"}],"RecordedAt":"2016-11-01T11:30:00+01:00"}`)
+	var ev CheckpointEvent
+	checkEvent(t, stub, EVENT_CHECKPOINT_RECORDED, &ev)
+	if ev.Checkpoint.Seq != 1 || ev.Checkpoint.Custodian != "dhl" || ev.Checkpoint.Handoff || ev.Checkpoint.RecordedAt != "2016-11-01T10:30:00Z" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	stub.MockTxTimestamp(testTime.Add(2 * time.Hour))
+	mustInvoke(t, stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Name":"FRA"},"Custodian":"fraport","CarrierRef":"LH8160"}`)
+	stub.MockTxTimestamp(testTime.Add(5 * time.Hour))
+	mustInvoke(t, stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Latitude":53.63,"Longitude":10.0},"Readings":[{"Sensor":"humidity","Value":40,"Unit":"%"}]}`)
+
+	timeline := getTimeline(t, stub, "C1")
+	if !timeline.Verified || len(timeline.Checkpoints) != 3 || timeline.Custodian != "fraport" {
+		t.Fatalf("unexpected timeline %+v", timeline)
+	}
+	first, second, third := timeline.Checkpoints[0], timeline.Checkpoints[1], timeline.Checkpoints[2]
+	if first.PrevHash != "" || second.PrevHash != first.Hash || third.PrevHash != second.Hash {
+		t.Fatalf("expected the checkpoints to be chained, got %+v", timeline.Checkpoints)
+	}
+	if !second.Handoff || second.Custodian != "fraport" || third.Handoff || third.Custodian != "fraport" {
+		t.Fatalf("expected custody to pass to fraport at the second checkpoint, got %+v %+v", second, third)
+	}
+	if third.TimeStamp != testTime.Add(5*time.Hour).Format(TIME_FORMAT) || third.Recorder != "dhl" || len(first.Readings) != 2 {
+		t.Fatalf("unexpected checkpoints %+v", timeline.Checkpoints)
+	}
+
+	// checkpoints end when the shipment reaches the buyer
+	mustInvoke(t, stub, dhl, "shipmentReached", "C1")
+	_, err := invoke(stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Name":"HAM"}}`)
+	checkError(t, err, "Permission Denied")
+}
+
+func TestRecordCheckpointRejected(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		caller     caller
+		checkpoint string
+		wantErr    string
+	}{
+		{"not the transporter", bosch, `{"Location":{"Name":"FRA"}}`, "Permission Denied"},
+		{"buyer", lht, `{"Location":{"Name":"FRA"}}`, "Permission Denied"},
+		{"no location", dhl, `{"CarrierRef":"LH8160"}`, "Location needs a Name or coordinates"},
+		{"half coordinates", dhl, `{"Location":{"Latitude":50.0}}`, "both Latitude and Longitude"},
+		{"coordinates out of range", dhl, `{"Location":{"Latitude":95.0,"Longitude":8.5}}`, "out of range"},
+		{"unknown sensor", dhl, `{"Location":{"Name":"FRA"},"Readings":[{"Sensor":"light","Value":1}]}`, "Sensor should be"},
+		{"bad time", dhl, `{"Location":{"Name":"FRA"},"RecordedAt":"yesterday"}`, "RecordedAt"},
+		{"not json", dhl, `FRA`, "Checkpoint should be a JSON object"},
+	}
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	_, err := invoke(stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Name":"STR"}}`)
+	checkError(t, err, "Permission Denied")
+	mustInvoke(t, stub, dhl, "inTransit", "C1")
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := invoke(stub, test.caller, "recordCheckpoint", "C1", test.checkpoint)
+			checkError(t, err, test.wantErr)
+		})
+	}
+	if timeline := getTimeline(t, stub, "C1"); len(timeline.Checkpoints) != 0 || !timeline.Verified || timeline.Custodian != "dhl" {
+		t.Fatalf("expected no checkpoints, got %+v", timeline)
+	}
+}
+
+func TestTrackingTimelineDetectsTampering(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C1")
+	for _, name := range []string{"STR", "FRA", "HAM"} {
+		mustInvoke(t, stub, dhl, "recordCheckpoint", "C1", `{"Location":{"Name":"`+name+`"}}`)
+	}
+
+	// rewrite the location of the second checkpoint behind the chaincode's back
+	key, _ := getCheckpointKey("C1", 2)
+	stored, _ := stub.GetState(key)
+	putRecord(t, stub, key, strings.Replace(string(stored), `"FRA"`, `"MUC"`, 1))
+
+	timeline := getTimeline(t, stub, "C1")
+	if timeline.Verified || timeline.BrokenAt != 2 {
+		t.Fatalf("expected the chain to break at checkpoint 2, got %+v", timeline)
+	}
+
+	_, err := query(stub, lht, "getTrackingTimeline", "C9")
+	checkError(t, err, "Failed to get contract object")
+}
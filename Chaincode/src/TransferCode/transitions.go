@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 State machine - every stage transition of a contract is a row of the transitions table. A row names the
+//					 action, the stages it can start from, the stage it leads to, the role allowed to perform it, the
+//					 fields it takes and the side effects run on the contract before it is saved.
+//==============================================================================================================================
+
+// PARTY allows any of the seller, transporter or buyer of the contract to perform a transition
+const PARTY = "party"
+
+// Fields a transition can set on the contract
+const FIELD_DOCUMENT = "DocumentID"
+const FIELD_REASON = "Reason"
+const FIELD_EVIDENCE = "EvidenceID"
+const FIELD_RESOLUTION = "Resolution"
+
+// TRANSITION_FIELDS lists the fields of TransitionFields, a transition takes those of its Params only
+var TRANSITION_FIELDS = []string{FIELD_DOCUMENT, FIELD_REASON, FIELD_EVIDENCE, FIELD_RESOLUTION}
+
+// Transition struct
+type Transition struct {
+	Action   string
+	From     []int
+	To       int
+	Role     string
+	Params   []string                          // fields taken, in the order of the positional arguments after the contract ID
+	Required []string                          // fields that must not be empty
+	Guard    func(sc SalesContractObject) bool // extra precondition on the contract, may be nil
+	Effect   func(t *SimpleChaincode, stub shim.ChaincodeStubInterface, sc *SalesContractObject) (bool, error) // run before the contract is saved
+}
+
+// TransitionFields struct - the values a caller supplies to a transition
+type TransitionFields struct {
+	DocumentID string
+	Reason     string
+	EvidenceID string
+	Resolution string `validate:"enum=resolution"`
+}
+
+// TransitionRequest struct - the arguments of transition, the fields as a JSON object in the positional form
+type TransitionRequest struct {
+	Contractid string `validate:"required"`
+	Action     string `validate:"required"`
+	TransitionFields
+}
+
+// ActionRequest struct - the named form of the arguments of the invoke of a transition, e.g. readyForShipment
+type ActionRequest struct {
+	Contractid string `validate:"required"`
+	TransitionFields
+}
+
+// AllowedAction struct - a transition the caller may perform on a contract, returned by allowedActions
+type AllowedAction struct {
+	Action   string
+	To       int
+	Params   []string
+	Required []string
+}
+
+var transitions = []Transition{
+	{Action: "readyForShipment", From: []int{STATE_OPEN}, To: STATE_READYFORSHIPMENT, Role: SELLER,
+		Params: []string{FIELD_DOCUMENT}},
+	{Action: "inTransit", From: []int{STATE_READYFORSHIPMENT}, To: STATE_INTRANSIT, Role: TRANSPORTER},
+	{Action: "shipmentReached", From: []int{STATE_INTRANSIT}, To: STATE_SHIPMENT_REACHED, Role: TRANSPORTER},
+	{Action: "shipmentDelivered", From: []int{STATE_SHIPMENT_REACHED, STATE_RESOLVED}, To: STATE_SHIPMENT_DELIVERED, Role: BUYER,
+		Guard: resolvedAs(RESOLUTION_DELIVER), Effect: (*SimpleChaincode).transfer_assets},
+	{Action: "cancelContract", From: []int{STATE_OPEN, STATE_READYFORSHIPMENT}, To: STATE_CANCELLED, Role: SELLER,
+		Params: []string{FIELD_REASON, FIELD_EVIDENCE}, Required: []string{FIELD_REASON}, Effect: (*SimpleChaincode).release_assets},
+	{Action: "rejectShipment", From: []int{STATE_SHIPMENT_REACHED}, To: STATE_REJECTED, Role: BUYER,
+		Params: []string{FIELD_REASON, FIELD_EVIDENCE}, Required: []string{FIELD_REASON, FIELD_EVIDENCE}},
+	{Action: "raiseDispute", From: []int{STATE_INTRANSIT, STATE_SHIPMENT_REACHED, STATE_REJECTED}, To: STATE_DISPUTED, Role: PARTY,
+		Params: []string{FIELD_REASON, FIELD_EVIDENCE}, Required: []string{FIELD_REASON, FIELD_EVIDENCE}},
+	{Action: "resolveDispute", From: []int{STATE_DISPUTED}, To: STATE_RESOLVED, Role: ARBITER,
+		Params: []string{FIELD_RESOLUTION, FIELD_REASON, FIELD_EVIDENCE}, Required: []string{FIELD_RESOLUTION, FIELD_REASON}},
+	{Action: "returnedToSeller", From: []int{STATE_REJECTED, STATE_RESOLVED}, To: STATE_RETURNED_TO_SELLER, Role: SELLER,
+		Params: []string{FIELD_EVIDENCE}, Guard: resolvedAs(RESOLUTION_RETURN), Effect: (*SimpleChaincode).release_assets},
+}
+
+// resolvedAs only lets a resolved contract through when the dispute ended with the given outcome
+func resolvedAs(resolution string) func(sc SalesContractObject) bool {
+	return func(sc SalesContractObject) bool {
+		return sc.Stage != STATE_RESOLVED || sc.Resolution == resolution
+	}
+}
+
+// getTransition returns the row of the transitions table for an action
+func getTransition(action string) (Transition, bool) {
+	for _, tr := range transitions {
+		if tr.Action == action {
+			return tr, true
+		}
+	}
+	return Transition{}, false
+}
+
+// transition - generic invoke driving the transitions table.
+// args: contractid, action, [fields as a JSON object, e.g. {"Reason":"damaged","EvidenceID":"D42"}], or
+// {"Contractid":"C1","Action":"cancelContract","Reason":"damaged"}
+func (t *SimpleChaincode) transition(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req TransitionRequest
+	if err := decodeRequest("transition", args, &req); err != nil {
+		return nil, err
+	}
+
+	tr, ok := getTransition(req.Action)
+	if !ok {
+		return nil, invalidArgument("Action", "transition() : unknown action "+req.Action)
+	}
+	object := ""
+	if namedForm(args) {
+		object = args[0]
+	} else if len(args) > 2 {
+		object = args[2]
+	}
+	if err := check_params("transition", tr, object); err != nil {
+		return nil, err
+	}
+	return t.apply_transition(stub, tr, req.Contractid, req.TransitionFields)
+}
+
+// positional_transition - runs a transition from a named invoke taking the contract ID followed by the
+// fields of the transition as positional arguments, or the contract ID and the fields as one JSON object,
+// e.g. {"Contractid":"C1","Reason":"damaged"}
+func (t *SimpleChaincode) positional_transition(stub shim.ChaincodeStubInterface, tr Transition, args []string) ([]byte, error) {
+
+	var req ActionRequest
+	if namedForm(args) {
+		if err := decodeRequest(tr.Action, args, &req); err != nil {
+			return nil, err
+		}
+		if err := check_params(tr.Action, tr, args[0]); err != nil {
+			return nil, err
+		}
+		return t.apply_transition(stub, tr, req.Contractid, req.TransitionFields)
+	}
+
+	if len(args) != len(tr.Params)+1 {
+		return nil, requestError(tr.Action, []Violation{{"", "arguments", fmt.Sprintf("Incorrect number of arguments. Expecting contractid %v", tr.Params)}})
+	}
+	var fields TransitionFields
+	for i, field := range tr.Params {
+		fields.set(field, args[i+1])
+	}
+	return t.apply_transition(stub, tr, args[0], fields)
+}
+
+// check_params - Rejects the fields of a transition given in a JSON object that the transition does not take,
+//				  the way decodeNamed rejects the fields a request does not know
+func check_params(function string, tr Transition, object string) error {
+
+	var raw map[string]json.RawMessage
+	if object == "" || json.Unmarshal([]byte(object), &raw) != nil {
+		return nil
+	}
+	var unknown []string
+	for key := range raw {
+		for _, field := range TRANSITION_FIELDS {
+			if strings.EqualFold(key, field) && !contains(tr.Params, field) {
+				unknown = append(unknown, key)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	var violations []Violation
+	for _, key := range unknown {
+		violations = append(violations, Violation{key, "unknown", "unknown field " + key})
+	}
+	return requestError(function, violations)
+}
+
+// apply_transition - checks a transition against the contract and the caller, then saves the contract in
+// its new stage and runs the side effects of the transition
+func (t *SimpleChaincode) apply_transition(stub shim.ChaincodeStubInterface, tr Transition, contractid string, fields TransitionFields) ([]byte, error) {
+
+	if err := validateRequest(tr.Action, &fields, tr.Required...); err != nil {
+		return nil, err
+	}
+
+	// check if the contract exists
+	sc, err := getContractObject(stub, contractid)
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Failed to get contract object", err)
+	}
+	if err = check_open(sc); err != nil {
+		return nil, err
+	}
+
+	if !tr.startsFrom(sc) {
+		logFor(stub, contractid).debug("action not allowed from the stage", "stage", sc.Stage)
+		return nil, permissionDenied(tr.Action)
+	}
+	if err = t.check_transition_caller(stub, tr, sc); err != nil {
+		logFor(stub, contractid).debug("caller may not perform the action", "reason", err)
+		return nil, permissionDenied(tr.Action)
+	}
+	if tr.To == STATE_READYFORSHIPMENT {
+		if err = check_funded(sc); err != nil {
+			return nil, err
+		}
+	}
+	if RECALL_HELD_STAGES[tr.To] {
+		if err = check_recall_hold(stub, sc); err != nil {
+			return nil, err
+		}
+	}
+
+	before := sc
+	sc.Stage = tr.To
+	for _, field := range tr.Params {
+		if value := fields.get(field); value != "" {
+			sc.set(field, value)
+		}
+	}
+
+	if tr.Effect != nil {
+		if _, err = tr.Effect(t, stub, &sc); err != nil {
+			return nil, wrapError(ERR_INTERNAL, "Error applying changes", err)
+		}
+	}
+	_, err = t.save_changes(stub, &sc) // Write new state
+	if err != nil {
+		return nil, wrapError(ERR_INTERNAL, "Error saving changes", err)
+	}
+	if err = emitContractEvent(stub, EVENT_CONTRACT_STAGE_CHANGED, &before, sc); err != nil {
+		return nil, err
+	}
+	logFor(stub, contractid).info("stage changed", "action", tr.Action, "from", before.Stage, "to", sc.Stage)
+	return nil, nil // We are Done
+}
+
+// allowedActions returns the transitions the caller may perform on a contract in its current stage.
+// args: contractid
+func (t *SimpleChaincode) allowedActions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var req ContractIDRequest
+	if err := decodeRequest("allowedActions", args, &req); err != nil {
+		return nil, err
+	}
+
+	sc, err := t.check_contract_reader(stub, "allowedActions", req.Contractid)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []AllowedAction{}
+	for _, tr := range transitions {
+		if !tr.startsFrom(sc) || t.check_transition_caller(stub, tr, sc) != nil {
+			continue
+		}
+		actions = append(actions, AllowedAction{tr.Action, tr.To, tr.Params, tr.Required})
+	}
+	return json.Marshal(actions)
+}
+
+// startsFrom reports whether the transition can be taken from the current stage of the contract
+func (tr Transition) startsFrom(sc SalesContractObject) bool {
+	for _, stage := range tr.From {
+		if stage == sc.Stage {
+			return tr.Guard == nil || tr.Guard(sc)
+		}
+	}
+	return false
+}
+
+// check_transition_caller - Verifies the caller holds the role a transition requires on the contract
+func (t *SimpleChaincode) check_transition_caller(stub shim.ChaincodeStubInterface, tr Transition, sc SalesContractObject) error {
+
+	switch tr.Role {
+	case SELLER:
+		return t.check_caller(stub, sc.Seller, SELLER)
+	case TRANSPORTER:
+		return t.check_caller(stub, sc.Transporter, TRANSPORTER)
+	case BUYER:
+		return t.check_caller(stub, sc.Buyer, BUYER)
+	case PARTY:
+		_, err := t.check_party(stub, sc)
+		return err
+	default:
+		_, err := t.check_role(stub, tr.Role)
+		return err
+	}
+}
+
+func (f TransitionFields) get(field string) string {
+	switch field {
+	case FIELD_DOCUMENT:
+		return f.DocumentID
+	case FIELD_REASON:
+		return f.Reason
+	case FIELD_EVIDENCE:
+		return f.EvidenceID
+	case FIELD_RESOLUTION:
+		return f.Resolution
+	}
+	return ""
+}
+
+func (f *TransitionFields) set(field string, value string) {
+	switch field {
+	case FIELD_DOCUMENT:
+		f.DocumentID = value
+	case FIELD_REASON:
+		f.Reason = value
+	case FIELD_EVIDENCE:
+		f.EvidenceID = value
+	case FIELD_RESOLUTION:
+		f.Resolution = value
+	}
+}
+
+func (sc *SalesContractObject) set(field string, value string) {
+	switch field {
+	case FIELD_DOCUMENT:
+		sc.DocumentID = value
+	case FIELD_REASON:
+		sc.Reason = value
+	case FIELD_EVIDENCE:
+		sc.EvidenceID = value
+	case FIELD_RESOLUTION:
+		sc.Resolution = value
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// step is one invoke of a contract lifecycle test
+type step struct {
+	caller   caller
+	function string
+	args     []string
+	stage    int // stage of C1 after the invoke
+}
+
+func runSteps(t *testing.T, stub *shim.MockStub, steps ...step) {
+
+	for _, s := range steps {
+		mustInvoke(t, stub, s.caller, s.function, s.args...)
+		if stage := getContract(t, stub, "C1").Stage; stage != s.stage {
+			t.Fatalf("%s: expected stage %d, got %d", s.function, s.stage, stage)
+		}
+	}
+}
+
+func TestDeliveryLifecycle(t *testing.T) {
+
+	stub := newStub(t)
+	runSteps(t, stub,
+		step{bosch, "readyForShipment", []string{"C1", "D2"}, STATE_READYFORSHIPMENT},
+		step{dhl, "inTransit", []string{"C1"}, STATE_INTRANSIT},
+		step{dhl, "shipmentReached", []string{"C1"}, STATE_SHIPMENT_REACHED},
+		step{lht, "shipmentDelivered", []string{"C1"}, STATE_SHIPMENT_DELIVERED},
+	)
+
+	if ast := getAsset(t, stub, "1001"); ast.Owner != "lht" || ast.Contractid != "" {
+		t.Fatalf("expected asset 1001 to be handed over to lht, got %+v", ast)
+	}
+	var ev ContractEvent
+	checkEvent(t, stub, EVENT_CONTRACT_STAGE_CHANGED, &ev)
+	if *ev.OldStage != STATE_SHIPMENT_REACHED || ev.NewStage != STATE_SHIPMENT_DELIVERED {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+
+	// a delivered contract is final
+	for _, c := range []caller{bosch, dhl, lht, arbiter} {
+		if _, err := invoke(stub, c, "raiseDispute", "C1", "late", "D5"); err == nil {
+			t.Fatalf("expected raiseDispute by %s to fail on a delivered contract", c.username)
+		}
+	}
+}
+
+func TestDisputeLifecycle(t *testing.T) {
+
+	toDisputed := []step{
+		{bosch, "readyForShipment", []string{"C1", "D2"}, STATE_READYFORSHIPMENT},
+		{dhl, "inTransit", []string{"C1"}, STATE_INTRANSIT},
+		{dhl, "shipmentReached", []string{"C1"}, STATE_SHIPMENT_REACHED},
+		{lht, "rejectShipment", []string{"C1", "damaged", "D3"}, STATE_REJECTED},
+		{dhl, "raiseDispute", []string{"C1", "damaged on unloading", "D4"}, STATE_DISPUTED},
+	}
+
+	tests := []struct {
+		name       string
+		resolution string
+		final      step
+		denied     step
+		owner      string
+	}{
+		{"resolved for delivery", RESOLUTION_DELIVER,
+			step{lht, "shipmentDelivered", []string{"C1"}, STATE_SHIPMENT_DELIVERED},
+			step{bosch, "returnedToSeller", []string{"C1", "D6"}, STATE_RESOLVED},
+			"lht"},
+		{"resolved for return", RESOLUTION_RETURN,
+			step{bosch, "returnedToSeller", []string{"C1", "D6"}, STATE_RETURNED_TO_SELLER},
+			step{lht, "shipmentDelivered", []string{"C1"}, STATE_RESOLVED},
+			"bosch"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := newStub(t)
+			runSteps(t, stub, toDisputed...)
+			runSteps(t, stub, step{arbiter, "resolveDispute", []string{"C1", test.resolution, "survey", "D5"}, STATE_RESOLVED})
+			if sc := getContract(t, stub, "C1"); sc.Resolution != test.resolution || sc.Reason != "survey" || sc.EvidenceID != "D5" {
+				t.Fatalf("unexpected contract %+v", sc)
+			}
+
+			if _, err := invoke(stub, test.denied.caller, test.denied.function, test.denied.args...); err == nil {
+				t.Fatalf("expected %s to be denied", test.denied.function)
+			}
+			runSteps(t, stub, test.final)
+			if ast := getAsset(t, stub, "1001"); ast.Owner != test.owner || ast.Contractid != "" {
+				t.Fatalf("expected asset 1001 to end with %s, got %+v", test.owner, ast)
+			}
+		})
+	}
+}
+
+func TestDisputeRoles(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "readyForShipment", "C1", "D2")
+	mustInvoke(t, stub, dhl, "inTransit", "C1")
+
+	// only the parties of the contract raise disputes, only the arbiter resolves them
+	for _, c := range []caller{arbiter, continental, anonymous} {
+		if _, err := invoke(stub, c, "raiseDispute", "C1", "late", "D3"); err == nil {
+			t.Fatalf("expected raiseDispute by %q to be denied", c.username)
+		}
+	}
+	if _, err := invoke(stub, lht, "raiseDispute", "C1", "late", ""); err == nil {
+		t.Fatal("expected raiseDispute without evidence to fail")
+	}
+	mustInvoke(t, stub, lht, "raiseDispute", "C1", "late", "D3")
+
+	for _, c := range []caller{bosch, dhl, lht} {
+		if _, err := invoke(stub, c, "resolveDispute", "C1", RESOLUTION_RETURN, "survey", ""); err == nil {
+			t.Fatalf("expected resolveDispute by %s to be denied", c.username)
+		}
+	}
+	if _, err := invoke(stub, arbiter, "resolveDispute", "C1", "", "survey", ""); err == nil {
+		t.Fatal("expected resolveDispute without a resolution to fail")
+	}
+	mustInvoke(t, stub, arbiter, "resolveDispute", "C1", RESOLUTION_RETURN, "survey", "")
+}
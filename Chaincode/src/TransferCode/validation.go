@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Requests - every function decodes its arguments into a request struct with decodeRequest. A single JSON object
+//				is the named form, e.g. {"Serialno":"1001","Partno":"LHTMO","Owner":"bosch"}, its keys the fields
+//				of the struct. Any other arguments are the positional form kept for older clients: they fill the
+//				fields in the order they are declared, a JSON object or array for a field that is not a string or
+//				a number. An empty positional argument leaves its field unset. The fields of an embedded struct are
+//				named fields of their own and one positional argument, a JSON object.
+//				The validate tag of a field lists its rules, e.g. `validate:"required,pattern=currency"`:
+//				  required		the field is set, and not empty for a string or a list
+//				  pattern=<name>	a string matches a pattern of requestPatterns
+//				  enum=<name>		a string is a value of a set of requestEnums
+//				  length=<min>-<max>	the length of a string or a list, in characters or items
+//				  min=<n>, max=<n>	bounds of a number
+//				Every rule a request breaks is reported at once in the Violations of an INVALID_ARGUMENT error.
+//==============================================================================================================================
+
+// Violation struct - a rule a field of a request breaks
+type Violation struct {
+	Field   string
+	Rule    string // required, pattern, enum, length, min, max, type, unknown or arguments
+	Message string
+}
+
+// requestPattern struct - a pattern a string field may be checked against
+type requestPattern struct {
+	re          *regexp.Regexp
+	description string
+}
+
+var requestPatterns = map[string]requestPattern{
+	"integer":  {regexp.MustCompile("^[0-9]+$"), "an integer"},
+	"currency": {currencyCode, "an ISO 4217 code, e.g. EUR"},
+	"sha256":   {sha256Hex, "a SHA-256 digest of 64 hex digits"},
+}
+
+var requestEnums = map[string]map[string]bool{
+	"assetStatus":      ASSET_STATUSES,
+	"docType":          DOCUMENT_TYPES,
+	"incoterms":        INCOTERMS,
+	"inspectionResult": {INSPECTION_PASSED: true, INSPECTION_FAILED: true},
+	"objectType":       {HISTORY_ASSET: true, HISTORY_CONTRACT: true},
+	"resolution":       {RESOLUTION_DELIVER: true, RESOLUTION_RETURN: true},
+	"sensor":           SENSOR_TYPES,
+}
+
+// requestField struct - a field of a request struct being decoded
+type requestField struct {
+	name  string
+	value reflect.Value
+	rules string
+}
+
+// decodeRequest - Decodes the arguments of a function into req, a pointer to a request struct, and validates it
+func decodeRequest(function string, args []string, req interface{}) error {
+
+	v := reflect.ValueOf(req).Elem()
+	present := make(map[string]bool)
+	var violations []Violation
+	if namedForm(args) {
+		violations = decodeNamed(args[0], "the request", namedFields(v), present)
+	} else {
+		violations = decodePositional(args, v, present)
+	}
+	if len(violations) == 1 && violations[0].Field == "" {
+		// the arguments could not be read at all
+		return requestError(function, violations)
+	}
+	broken := make(map[string]bool)
+	for _, violation := range violations {
+		broken[violation.Field] = true
+	}
+	for _, violation := range checkRules(namedFields(v), present) {
+		if !broken[violation.Field] {
+			violations = append(violations, violation)
+		}
+	}
+	return requestError(function, violations)
+}
+
+// namedForm tells whether the arguments of a function are a single JSON object
+func namedForm(args []string) bool {
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+// validateRequest - Validates a request struct built by the chaincode, e.g. an item of a batch. Every field that
+// is not empty is taken as set. The fields named in required are required on top of the rules of their tags.
+func validateRequest(function string, req interface{}, required ...string) error {
+
+	fields := namedFields(reflect.ValueOf(req).Elem())
+	present := make(map[string]bool)
+	for i, f := range fields {
+		present[f.name] = !isZero(f.value)
+		for _, name := range required {
+			if name == f.name {
+				fields[i].rules = strings.TrimSuffix("required,"+f.rules, ",")
+			}
+		}
+	}
+	return requestError(function, checkRules(fields, present))
+}
+
+// namedFields returns the fields of a request struct, those of an embedded struct in its place
+func namedFields(v reflect.Value) []requestField {
+
+	var fields []requestField
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, namedFields(v.Field(i))...)
+		} else if sf.PkgPath == "" {
+			fields = append(fields, requestField{sf.Name, v.Field(i), sf.Tag.Get("validate")})
+		}
+	}
+	return fields
+}
+
+// decodeNamed decodes a JSON object, the request or an embedded struct named by label, into the fields with the
+// names of its keys, matched regardless of case the way encoding/json does
+func decodeNamed(object string, label string, fields []requestField, present map[string]bool) []Violation {
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(object), &raw); err != nil {
+		return []Violation{{"", "type", label + " should be a JSON object"}}
+	}
+	var violations []Violation
+	for _, f := range fields {
+		for key, value := range raw {
+			if !strings.EqualFold(key, f.name) {
+				continue
+			}
+			delete(raw, key)
+			if string(value) == "null" {
+				break
+			}
+			present[f.name] = true
+			if err := json.Unmarshal(value, f.value.Addr().Interface()); err != nil {
+				violations = append(violations, Violation{f.name, "type", f.name + " should be " + typeName(f.value.Type())})
+			}
+			break
+		}
+	}
+	var unknown []string
+	for key := range raw {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		violations = append(violations, Violation{key, "unknown", "unknown field " + key})
+	}
+	return violations
+}
+
+// decodePositional fills the fields of a request struct with the arguments in order
+func decodePositional(args []string, v reflect.Value, present map[string]bool) []Violation {
+
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		if sf := v.Type().Field(i); sf.PkgPath == "" || sf.Anonymous {
+			names = append(names, sf.Name)
+		}
+	}
+	if len(args) > len(names) {
+		return []Violation{{"", "arguments", fmt.Sprintf("Incorrect number of arguments. Expecting at most %d : %s", len(names), strings.Join(names, ", "))}}
+	}
+	var violations []Violation
+	for i, arg := range args {
+		if arg == "" {
+			continue
+		}
+		sf, fv := v.Type().Field(i), v.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			violations = append(violations, decodeNamed(arg, sf.Name, namedFields(fv), present)...)
+			continue
+		}
+		present[sf.Name] = true
+		if err := setField(fv, arg); err != nil {
+			violations = append(violations, Violation{sf.Name, "type", sf.Name + " should be " + typeName(fv.Type())})
+		}
+	}
+	return violations
+}
+
+// setField sets a field from a positional argument
+func setField(fv reflect.Value, arg string) error {
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(arg)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || fv.OverflowInt(n) {
+			return fmt.Errorf("not an integer : %s", arg)
+		}
+		fv.SetInt(n)
+	default:
+		return json.Unmarshal([]byte(arg), fv.Addr().Interface())
+	}
+	return nil
+}
+
+// checkRules returns the rules of their validate tags the fields of a request break
+func checkRules(fields []requestField, present map[string]bool) []Violation {
+
+	var violations []Violation
+	for _, f := range fields {
+		if f.rules == "" {
+			continue
+		}
+		for _, rule := range strings.Split(f.rules, ",") {
+			name, param := rule, ""
+			if i := strings.Index(rule, "="); i >= 0 {
+				name, param = rule[:i], rule[i+1:]
+			}
+			if message := checkRule(f, name, param, present[f.name]); message != "" {
+				violations = append(violations, Violation{f.name, name, f.name + " " + message})
+				break
+			}
+		}
+	}
+	return violations
+}
+
+// checkRule returns how a field breaks a rule, empty when it does not. Rules other than required only apply to a
+// field that is set.
+func checkRule(f requestField, rule string, param string, present bool) string {
+
+	value := reflect.Indirect(f.value)
+	if rule == "required" {
+		if !present || (value.Kind() == reflect.String || value.Kind() == reflect.Slice) && value.Len() == 0 {
+			return "is required"
+		}
+		return ""
+	}
+	if !present || !value.IsValid() {
+		return ""
+	}
+	switch rule {
+	case "pattern":
+		pattern := requestPatterns[param]
+		if value.Len() > 0 && !pattern.re.MatchString(value.String()) {
+			return "should be " + pattern.description + " : " + value.String()
+		}
+	case "enum":
+		if value.Len() > 0 && !requestEnums[param][value.String()] {
+			return "should be one of " + strings.Join(sortedKeys(requestEnums[param]), ", ") + " : " + value.String()
+		}
+	case "length":
+		bounds := strings.SplitN(param, "-", 2)
+		min, _ := strconv.Atoi(bounds[0])
+		max, _ := strconv.Atoi(bounds[1])
+		if value.Len() < min || value.Len() > max {
+			if value.Kind() == reflect.String {
+				return fmt.Sprintf("should hold between %d and %d characters", min, max)
+			}
+			return fmt.Sprintf("should hold between %d and %d items", min, max)
+		}
+	case "min":
+		bound, _ := strconv.ParseInt(param, 10, 64)
+		if value.Int() < bound {
+			return fmt.Sprintf("should be at least %d", bound)
+		}
+	case "max":
+		bound, _ := strconv.ParseInt(param, 10, 64)
+		if value.Int() > bound {
+			return fmt.Sprintf("should be at most %d", bound)
+		}
+	}
+	return ""
+}
+
+// requestError returns the INVALID_ARGUMENT error reporting the violations of a request, nil when there are none
+func requestError(function string, violations []Violation) error {
+
+	if len(violations) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, violation := range violations {
+		messages = append(messages, violation.Message)
+	}
+	ce := &ChaincodeError{Code: ERR_INVALID_ARGUMENT, Violations: violations}
+	if len(violations) == 1 {
+		ce.Field = violations[0].Field
+		ce.Message = function + "() : " + messages[0]
+	} else {
+		ce.Message = fmt.Sprintf("%s() : %d invalid arguments : %s", function, len(violations), strings.Join(messages, "; "))
+	}
+	return ce
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// typeName returns how the type of a field reads in a message
+func typeName(t reflect.Type) string {
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeName(t.Elem())
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice:
+		return "a JSON array"
+	}
+	return "a JSON object"
+}
+
+func sortedKeys(set map[string]bool) []string {
+
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
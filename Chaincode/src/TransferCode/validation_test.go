@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testFilter and testRequest exercise every rule of decodeRequest
+type testFilter struct {
+	Owner string
+	Stage *int `validate:"min=0,max=9"`
+}
+
+type testRequest struct {
+	Serialno string `validate:"required,pattern=integer"`
+	Currency string `validate:"pattern=currency"`
+	DocType  string `validate:"enum=docType"`
+	Amount   int64  `validate:"required,min=1,max=100"`
+	Items    []int  `validate:"length=1-2"`
+	testFilter
+}
+
+// violated returns the fields the violations of an error are about, in order
+func violated(t *testing.T, err error) []string {
+
+	ce, ok := parseError(err)
+	if !ok || ce.Code != ERR_INVALID_ARGUMENT {
+		t.Fatalf("expected an INVALID_ARGUMENT error, got %v", err)
+	}
+	fields := []string{}
+	for _, violation := range ce.Violations {
+		fields = append(fields, violation.Field)
+	}
+	return fields
+}
+
+func TestDecodeRequest(t *testing.T) {
+
+	stage := 2
+	tests := []struct {
+		name string
+		args []string
+		want testRequest
+	}{
+		{"positional", []string{"1001", "EUR", DOC_INVOICE, "5", "[1,2]", `{"Owner":"bosch","Stage":2}`},
+			testRequest{"1001", "EUR", DOC_INVOICE, 5, []int{1, 2}, testFilter{"bosch", &stage}}},
+		{"positional left out", []string{"1001", "", "", "5"}, testRequest{Serialno: "1001", Amount: 5}},
+		{"named", []string{`{"Serialno":"1001","Amount":5,"Items":[1],"Owner":"bosch","Stage":2}`},
+			testRequest{Serialno: "1001", Amount: 5, Items: []int{1}, testFilter: testFilter{"bosch", &stage}}},
+		{"named in any case", []string{` {"serialno":"1001","AMOUNT":5,"owner":null}`}, testRequest{Serialno: "1001", Amount: 5}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req testRequest
+			if err := decodeRequest("test", test.args, &req); err != nil {
+				t.Fatalf("unexpected error %s", err)
+			}
+			if !reflect.DeepEqual(req, test.want) {
+				t.Fatalf("expected %+v, got %+v", test.want, req)
+			}
+		})
+	}
+}
+
+func TestRequestViolations(t *testing.T) {
+
+	tests := []struct {
+		name string
+		args []string
+		want []string // fields at fault, "" for the arguments as a whole
+	}{
+		{"every rule", []string{"S1", "euro", "receipt", "0", "[1,2,3]", `{"Stage":12}`},
+			[]string{"Serialno", "Currency", "DocType", "Amount", "Items", "Stage"}},
+		{"required", nil, []string{"Serialno", "Amount"}},
+		{"empty list", []string{"1001", "", "", "1", "[]"}, []string{"Items"}},
+		{"types", []string{"1001", "", "", "five", `{"a":1}`}, []string{"Amount", "Items"}},
+		{"type and rule", []string{`{"Serialno":1001,"Amount":500}`}, []string{"Serialno", "Amount"}},
+		{"unknown fields", []string{`{"Serialno":"1001","Amount":1,"Price":3,"Color":"red"}`}, []string{"Color", "Price"}},
+		{"too many arguments", []string{"1001", "", "", "1", "", "", "extra"}, []string{""}},
+		{"malformed", []string{`{"Serialno":`}, []string{""}},
+		{"malformed embedded", []string{"1001", "", "", "1", "", `[]`}, []string{""}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var req testRequest
+			if got := violated(t, decodeRequest("test", test.args, &req)); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("expected violations of %v, got %v", test.want, got)
+			}
+		})
+	}
+
+	// a single violation names its field, several are counted in the message
+	var req testRequest
+	checkCode(t, decodeRequest("test", []string{"1001", "", "", "0"}, &req), ERR_INVALID_ARGUMENT, "Amount", "")
+	err := decodeRequest("test", nil, &req)
+	checkCode(t, err, ERR_INVALID_ARGUMENT, "", "")
+	checkError(t, err, "test() : 2 invalid arguments : Serialno is required; Amount is required")
+}
+
+func TestValidateRequest(t *testing.T) {
+
+	// the fields of a request built by the chaincode are set when they are not empty
+	if err := validateRequest("test", &testRequest{Serialno: "1001", Amount: 1}); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if got := violated(t, validateRequest("test", &testRequest{Serialno: "1001"}, "Owner")); !reflect.DeepEqual(got, []string{"Amount", "Owner"}) {
+		t.Fatalf("expected Amount and Owner to be required, got %v", got)
+	}
+}
+
+func TestNamedInvokes(t *testing.T) {
+
+	stub := newStub(t)
+	mustInvoke(t, stub, bosch, "initAssset", `{"Serialno":"1003","Partno":"LHTMO","Owner":"bosch"}`)
+	mustInvoke(t, stub, bosch, "ownerUpdation", `{"Serialno":"1003","NewOwner":"continental"}`)
+	if ast := getAsset(t, stub, "1003"); ast.Owner != "continental" {
+		t.Fatalf("unexpected asset %+v", ast)
+	}
+	mustInvoke(t, stub, bosch, "readyForShipment", `{"Contractid":"C1","DocumentID":"D2"}`)
+	mustInvoke(t, stub, dhl, "transition", `{"Contractid":"C1","Action":"inTransit"}`)
+	if sc := getContract(t, stub, "C1"); sc.Stage != STATE_INTRANSIT || sc.DocumentID != "D2" {
+		t.Fatalf("unexpected contract %+v", sc)
+	}
+	payload, err := query(stub, lht, "readContract", `{"Contractid":"C1"}`)
+	if err != nil || len(payload) == 0 {
+		t.Fatalf("readContract failed: %v", err)
+	}
+
+	// the positional form of initContract still takes an empty TimeStamp, or goes without it
+	mustInvoke(t, stub, bosch, "initAssset", "1004", "LHTMO", "bosch")
+	mustInvoke(t, stub, bosch, "initContract", "C2", "0", "lht", "dhl", "bosch", "1002", "D1", "")
+	mustInvoke(t, stub, bosch, "initContract", "C3", "0", "lht", "dhl", "bosch", "1004", "D1")
+
+	// every violation of a request is reported at once
+	_, err = invoke(stub, bosch, "initContract", `{"Contractid":"C4","Buyer":"lht","Currency":"euro","Incoterms":"ABC","LineItems":[],"Color":"red"}`)
+	if got := violated(t, err); !reflect.DeepEqual(got, []string{"Color", "Transporter", "Seller", "Currency", "Incoterms", "LineItems"}) {
+		t.Fatalf("unexpected violations %v", got)
+	}
+	_, err = invoke(stub, lht, "raiseDispute", `{"Contractid":"C1"}`)
+	if got := violated(t, err); !reflect.DeepEqual(got, []string{FIELD_REASON, FIELD_EVIDENCE}) {
+		t.Fatalf("unexpected violations %v", got)
+	}
+	_, err = invoke(stub, lht, "raiseDispute", "C1", "damaged")
+	checkError(t, err, "Incorrect number of arguments")
+
+	// a transition takes the fields of its own parameters only
+	_, err = invoke(stub, dhl, "shipmentReached", `{"Contractid":"C1","Reason":"late","resolution":"deliver"}`)
+	if got := violated(t, err); !reflect.DeepEqual(got, []string{"Reason", "resolution"}) {
+		t.Fatalf("unexpected violations %v", got)
+	}
+	_, err = invoke(stub, dhl, "transition", "C1", "shipmentReached", `{"DocumentID":"D3"}`)
+	checkCode(t, err, ERR_INVALID_ARGUMENT, "DocumentID", "")
+	if sc := getContract(t, stub, "C1"); sc.Stage != STATE_INTRANSIT || sc.DocumentID != "D2" {
+		t.Fatalf("expected a rejected transition to leave C1 alone, got %+v", sc)
+	}
+}